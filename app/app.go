@@ -1,6 +1,7 @@
 package app
 
 import (
+	"github.com/tonkla/autotp/clock"
 	"github.com/tonkla/autotp/exchange"
 	"github.com/tonkla/autotp/rdb"
 	"github.com/tonkla/autotp/strategy"
@@ -15,4 +16,8 @@ type AppParams struct {
 	TK t.Ticker
 	TO t.TradeOrders
 	QO t.QueryOrder
+	CL clock.Clock
+
+	// PausedUntil is the time in milliseconds until which the robot does not trade, e.g. after the API keys have been refused
+	PausedUntil int64
 }
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path"
+
+	"github.com/spf13/viper"
+	t "github.com/tonkla/autotp/types"
+)
+
+// LoadBotParams reads the bot parameters from the YAML configuration file
+func LoadBotParams(configFile string) (*t.BotParams, error) {
+	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		return nil, err
+	} else if ext := path.Ext(configFile); ext != ".yml" && ext != ".yaml" {
+		return nil, errors.New("Accept only YAML file")
+	}
+
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &t.BotParams{
+		ApiKey:    v.GetString("apiKey"),
+		SecretKey: v.GetString("secretKey"),
+		DbName:    v.GetString("dbName"),
+		OrderType: v.GetString("orderType"),
+		View:      v.GetString("view"),
+		Mode:      v.GetString("mode"),
+
+		Network: v.GetString("network"),
+		BaseURL: v.GetString("baseURL"),
+		WsURL:   v.GetString("wsURL"),
+
+		IntervalSec: v.GetInt64("intervalSec"),
+		CacheKlines: v.GetBool("cacheKlines"),
+		UserStream:  v.GetBool("userStream"),
+
+		MarketData:    v.GetString("marketData"),
+		TickerSource:  v.GetString("tickerSource"),
+		MinIntervalMs: v.GetInt64("minIntervalMs"),
+
+		Exchange:    v.GetString("exchange"),
+		Symbol:      v.GetString("symbol"),
+		BotID:       v.GetInt64("botID"),
+		Product:     v.GetString("product"),
+		Strategy:    v.GetString("strategy"),
+		PriceDigits: v.GetInt64("priceDigits"),
+		QtyDigits:   v.GetInt64("qtyDigits"),
+		BaseQty:     v.GetFloat64("baseQty"),
+		QuoteQty:    v.GetFloat64("quoteQty"),
+		MakerFee:    v.GetFloat64("makerFee"),
+		TakerFee:    v.GetFloat64("takerFee"),
+
+		StartPrice: v.GetFloat64("startPrice"),
+		UpperPrice: v.GetFloat64("upperPrice"),
+		LowerPrice: v.GetFloat64("lowerPrice"),
+		GridSize:   v.GetFloat64("gridSize"),
+		GridTP:     v.GetFloat64("gridTP"),
+		OpenZones:  v.GetInt64("openZones"),
+		ApplyTA:    v.GetBool("applyTA"),
+		Slippage:   v.GetFloat64("slippage"),
+
+		MATf1st:     v.GetString("maTf1st"),
+		MAPeriod1st: v.GetInt64("maPeriod1st"),
+		MATf2nd:     v.GetString("maTf2nd"),
+		MAPeriod2nd: v.GetInt64("maPeriod2nd"),
+		MATf3rd:     v.GetString("maTf3rd"),
+		MAPeriod3rd: v.GetInt64("maPeriod3rd"),
+		OrderGap:    v.GetFloat64("orderGap"),
+		OrderGapATR: v.GetFloat64("orderGapATR"),
+		MoS:         v.GetFloat64("mos"),
+
+		ForceClose: v.GetBool("forceClose"),
+		AutoSL:     v.GetBool("autoSL"),
+		AutoTP:     v.GetBool("autoTP"),
+		QuoteSL:    v.GetFloat64("quoteSL"),
+		QuoteTP:    v.GetFloat64("quoteTP"),
+		AtrSL:      v.GetFloat64("atrSL"),
+		AtrTP:      v.GetFloat64("atrTP"),
+		TimeSecSL:  v.GetInt64("timeSecSL"),
+		TimeSecTP:  v.GetInt64("timeSecTP"),
+
+		QuoteTrail:   v.GetFloat64("quoteTrail"),
+		PercentTrail: v.GetFloat64("percentTrail"),
+		AtrTrail:     v.GetFloat64("atrTrail"),
+
+		TimeSecCancel: v.GetInt64("timeSecCancel"),
+
+		CloseLong:  v.GetBool("closeLong"),
+		CloseShort: v.GetBool("closeShort"),
+		StopMarket: v.GetBool("stopMarket"),
+
+		Leverage:     v.GetInt64("leverage"),
+		MarginType:   v.GetString("marginType"),
+		PositionMode: v.GetString("positionMode"),
+
+		RecvWindow: v.GetInt64("recvWindow"),
+
+		Gap: t.StopLimit{
+			SLStop:    v.GetInt64("slStop"),
+			SLLimit:   v.GetInt64("slLimit"),
+			TPStop:    v.GetInt64("tpStop"),
+			TPLimit:   v.GetInt64("tpLimit"),
+			OpenLimit: v.GetInt64("openLimit"),
+		},
+	}, nil
+}
@@ -0,0 +1,304 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tonkla/autotp/app"
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/sim"
+	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/rdb"
+	"github.com/tonkla/autotp/report"
+	"github.com/tonkla/autotp/robot"
+	"github.com/tonkla/autotp/strategy"
+	t "github.com/tonkla/autotp/types"
+)
+
+// Params holds the inputs of a backtest run
+type Params struct {
+	BP        *t.BotParams
+	Prices    []t.HistoricalPrice
+	Timeframe string
+	StartTime int64
+	EndTime   int64
+	DbName    string
+	Report    bool
+
+	// PathModel is the intrabar path model, AUTO when empty, Seed is for the RANDOM model
+	PathModel string
+	Seed      int64
+
+	// SubPrices are the k-lines of a smaller timeframe, replayed instead of the paths of the bars
+	SubPrices    []t.HistoricalPrice
+	SubTimeframe string
+}
+
+// Result is the PnL summary of a bot after a backtest run
+type Result struct {
+	BotID    int64
+	Symbol   string
+	Strategy string
+
+	Trades       int
+	Wins         int
+	Losses       int
+	GrossProfit  float64
+	GrossLoss    float64
+	NetPL        float64
+	MaxDrawdown  float64
+	ProfitFactor float64
+
+	OpenOrders   int
+	UnrealizedPL float64
+	LastPrice    float64
+
+	// Report is the performance report of the closed orders, only when Params.Report is set
+	Report *report.Report
+
+	// pls is the realized PnL of the closed orders, sorted by the close time
+	pls []float64
+}
+
+// Run replays the historical prices through the strategy and the robot, on a simulated exchange.
+// Bars before the start time are preloaded as historical prices, bars after the end time are skipped.
+func Run(p Params) (*Result, error) {
+	if p.BP == nil {
+		return nil, errors.New("bot parameters are required")
+	}
+	dur := h.TfDuration(p.Timeframe)
+	if dur == 0 {
+		return nil, fmt.Errorf("invalid timeframe: %s", p.Timeframe)
+	}
+
+	path, err := NewPath(p.PathModel, p.Seed)
+	if err != nil {
+		return nil, err
+	}
+	subDur := h.TfDuration(p.SubTimeframe)
+	if len(p.SubPrices) > 0 && (subDur == 0 || subDur >= dur) {
+		return nil, fmt.Errorf("invalid sub-bar timeframe: %s", p.SubTimeframe)
+	}
+
+	var history, prices []t.HistoricalPrice
+	for _, price := range p.Prices {
+		if price.Time < p.StartTime {
+			history = append(history, price)
+		} else if p.EndTime == 0 || price.Time <= p.EndTime {
+			prices = append(prices, price)
+		}
+	}
+	if len(prices) == 0 {
+		return nil, errors.New("no historical prices to replay")
+	}
+
+	dbName := p.DbName
+	if dbName == "" {
+		dbName = fmt.Sprintf("file:backtest%s?mode=memory&cache=shared", h.GenID())
+	}
+	db := rdb.Connect(dbName)
+	if p.DbName == "" {
+		defer db.Close()
+	}
+
+	ex := sim.NewClient(p.BP.Symbol, p.Timeframe, p.BP.MakerFee, p.BP.TakerFee)
+	ex.LoadHistory(history)
+
+	cl := clock.NewSim(prices[0].Time)
+	st, err := strategy.New(db, p.BP, ex, cl)
+	if err != nil {
+		return nil, err
+	}
+
+	qo := t.QueryOrder{
+		BotID:    p.BP.BotID,
+		Exchange: p.BP.Exchange,
+		Symbol:   p.BP.Symbol,
+	}
+
+	ap := app.AppParams{
+		EX: ex,
+		ST: st,
+		DB: db,
+		BP: p.BP,
+		QO: qo,
+		CL: cl,
+	}
+
+	var sub int
+	for _, price := range prices {
+		var ticks []t.Ticker
+		if len(p.SubPrices) > 0 {
+			ticks, sub = subBarTicks(path, price, dur, p.SubPrices, subDur, sub)
+		} else {
+			ticks = path.Ticks(price, dur)
+		}
+		for _, ticker := range ticks {
+			ticker.Exchange = p.BP.Exchange
+			ticker.Symbol = p.BP.Symbol
+			cl.Set(ticker.Time)
+			ex.Tick(ticker)
+			ap.TK = ticker
+			tradeOrders := ap.ST.OnTick(ticker)
+			if tradeOrders != nil {
+				ap.TO = *tradeOrders
+				robot.Trade(&ap)
+			}
+		}
+	}
+
+	r := Summarize(db, p.BP, prices[len(prices)-1].Close)
+	if p.Report {
+		r.Report = report.New(p.BP, report.Trades(db, qo))
+	}
+	return r, nil
+}
+
+// Ticks returns synthetic tickers of the bar along the AUTO path:
+// OPEN, LOW, HIGH, CLOSE for a green bar and OPEN, HIGH, LOW, CLOSE for a red bar
+func Ticks(p t.HistoricalPrice, dur int64) []t.Ticker {
+	return Path(autoPath).Ticks(p, dur)
+}
+
+// Summarize returns the PnL summary of the bot from its orders in the DB
+func Summarize(db *rdb.DB, bp *t.BotParams, lastPrice float64) *Result {
+	qo := t.QueryOrder{
+		BotID:    bp.BotID,
+		Exchange: bp.Exchange,
+		Symbol:   bp.Symbol,
+	}
+
+	r := Result{
+		BotID:     bp.BotID,
+		Symbol:    bp.Symbol,
+		Strategy:  bp.Strategy,
+		LastPrice: lastPrice,
+	}
+
+	for _, o := range db.GetClosedOrders(qo) {
+		r.pls = append(r.pls, o.PL)
+	}
+	r.addClosed()
+
+	for _, o := range db.GetActiveLimitOrders(qo) {
+		if o.Status != t.OrderStatusFilled {
+			continue
+		}
+		r.OpenOrders++
+		if o.PosSide == t.OrderPosSideShort || (o.PosSide == "" && o.Side == t.OrderSideSell) {
+			r.UnrealizedPL += (o.OpenPrice-lastPrice)*o.Qty - o.Commission
+		} else {
+			r.UnrealizedPL += (lastPrice-o.OpenPrice)*o.Qty - o.Commission
+		}
+	}
+
+	r.normalize(bp.PriceDigits)
+	return &r
+}
+
+// addClosed calculates the statistics of the realized PnL
+func (r *Result) addClosed() {
+	peak := 0.0
+	for _, pl := range r.pls {
+		r.Trades++
+		if pl > 0 {
+			r.Wins++
+			r.GrossProfit += pl
+		} else {
+			r.Losses++
+			r.GrossLoss += pl
+		}
+		r.NetPL += pl
+		peak = math.Max(peak, r.NetPL)
+		r.MaxDrawdown = math.Max(r.MaxDrawdown, peak-r.NetPL)
+	}
+	if r.GrossLoss < 0 {
+		r.ProfitFactor = r.GrossProfit / -r.GrossLoss
+	} else if r.GrossProfit > 0 {
+		r.ProfitFactor = math.Inf(1)
+	}
+}
+
+func (r *Result) normalize(digits int64) {
+	r.NetPL = h.NormalizeDouble(r.NetPL, digits)
+	r.MaxDrawdown = h.NormalizeDouble(r.MaxDrawdown, digits)
+	r.UnrealizedPL = h.NormalizeDouble(r.UnrealizedPL, digits)
+}
+
+// PrintSummary writes the PnL summary of the bots as a table
+func PrintSummary(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "BotID\tSymbol\tStrategy\tTrades\tWins\tLosses\tWinRate\tGrossProfit\tGrossLoss\tNetPL\tMaxDD\tPF\tOpen\tUnrealizedPL\t")
+	for _, r := range results {
+		winRate := 0.0
+		if r.Trades > 0 {
+			winRate = float64(r.Wins) / float64(r.Trades) * 100
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%d\t%.2f%%\t%f\t%f\t%f\t%f\t%.2f\t%d\t%f\t\n",
+			r.BotID, r.Symbol, r.Strategy, r.Trades, r.Wins, r.Losses, winRate,
+			r.GrossProfit, r.GrossLoss, r.NetPL, r.MaxDrawdown, r.ProfitFactor, r.OpenOrders, r.UnrealizedPL)
+	}
+	tw.Flush()
+}
+
+// LoadCSV reads k-lines from a CSV file in the format of Binance public data,
+// the columns are open time (milliseconds), open, high, low, close, and the rest are ignored
+func LoadCSV(fileName string, symbol string) ([]t.HistoricalPrice, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var prices []t.HistoricalPrice
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 5 {
+			return nil, fmt.Errorf("%s:%d: expect at least 5 columns", fileName, line)
+		}
+
+		ot, err := strconv.ParseInt(strings.TrimSpace(rec[0]), 10, 64)
+		if err != nil {
+			// Skip the header
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: %v", fileName, line, err)
+		}
+
+		var ohlc [4]float64
+		for i := range ohlc {
+			ohlc[i], err = strconv.ParseFloat(strings.TrimSpace(rec[i+1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", fileName, line, err)
+			}
+		}
+
+		prices = append(prices, t.HistoricalPrice{
+			Symbol: symbol,
+			Time:   ot,
+			Open:   ohlc[0],
+			High:   ohlc[1],
+			Low:    ohlc[2],
+			Close:  ohlc[3],
+		})
+	}
+	return prices, nil
+}
@@ -0,0 +1,117 @@
+package backtest
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tonkla/autotp/types"
+)
+
+func TestTicks(t *testing.T) {
+	green := types.HistoricalPrice{Time: 60000, Open: 10, High: 13, Low: 9, Close: 12}
+	red := types.HistoricalPrice{Time: 60000, Open: 12, High: 13, Low: 9, Close: 10}
+
+	expected := []float64{10, 9, 13, 12}
+	for i, tk := range Ticks(green, 60000) {
+		if tk.Price != expected[i] {
+			t.Errorf("Green bar, tick %d: Expect: %f, Got: %f", i, expected[i], tk.Price)
+		}
+	}
+
+	expected = []float64{12, 13, 9, 10}
+	ticks := Ticks(red, 60000)
+	for i, tk := range ticks {
+		if tk.Price != expected[i] {
+			t.Errorf("Red bar, tick %d: Expect: %f, Got: %f", i, expected[i], tk.Price)
+		}
+	}
+	if ticks[0].Time != 60000 || ticks[len(ticks)-1].Time != 119999 {
+		t.Errorf("Ticks must stay inside the bar: %d-%d", ticks[0].Time, ticks[len(ticks)-1].Time)
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "klines.csv")
+	data := "open_time,open,high,low,close,volume\n" +
+		"1634083200000,470.1,480.5,460.2,475.3,1000\n" +
+		"1634169600000,475.3,490.0,470.0,488.8,1200\n"
+	if err := os.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prices, err := LoadCSV(fileName, "BNBUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 2 || prices[1].Time != 1634169600000 || prices[1].Close != 488.8 || prices[0].Symbol != "BNBUSDT" {
+		t.Errorf("Unexpected prices: %+v", prices)
+	}
+}
+
+// sinePrices returns 1-minute bars swinging between 100 and 140
+func sinePrices(n int) []types.HistoricalPrice {
+	var prices []types.HistoricalPrice
+	for i := 0; i < n; i++ {
+		open := 120 + 20*math.Sin(float64(i)/60)
+		close := 120 + 20*math.Sin(float64(i+1)/60)
+		prices = append(prices, types.HistoricalPrice{
+			Time:  1633046400000 + int64(i)*60000,
+			Open:  open,
+			High:  math.Max(open, close) + 0.1,
+			Low:   math.Min(open, close) - 0.1,
+			Close: close,
+		})
+	}
+	return prices
+}
+
+func gridBotParams() types.BotParams {
+	return types.BotParams{
+		Exchange:    types.ExcBinance,
+		Symbol:      "BNBUSDT",
+		BotID:       1,
+		Product:     types.ProductSpot,
+		Strategy:    types.StrategyGrid,
+		OrderType:   types.OrderTypeLimit,
+		View:        types.ViewLong,
+		PriceDigits: 2,
+		QtyDigits:   3,
+		BaseQty:     1,
+		UpperPrice:  150,
+		LowerPrice:  90,
+		GridSize:    12,
+		GridTP:      1,
+		OpenZones:   1,
+		Gap: types.StopLimit{
+			TPStop:  10,
+			TPLimit: 20,
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	bp := gridBotParams()
+	prices := sinePrices(2880)
+	r, err := Run(Params{BP: &bp, Prices: prices, Timeframe: "1m", Report: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Trades == 0 || r.Wins != r.Trades || r.NetPL <= 0 {
+		t.Errorf("Unexpected result: %+v", r)
+	}
+
+	// The orders are closed at the simulated time of the k-lines
+	end := prices[len(prices)-1].Time + 60000
+	for _, tr := range r.Report.TradeList {
+		if tr.CloseTime < prices[0].Time || tr.CloseTime >= end || tr.CloseTime < tr.OpenTime {
+			t.Fatalf("Unexpected times of the trade: %+v", tr)
+		}
+	}
+}
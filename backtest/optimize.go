@@ -0,0 +1,242 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+const (
+	SortByNetPL        = "pnl"
+	SortByDrawdown     = "drawdown"
+	SortByProfitFactor = "pf"
+)
+
+// setters maps the configuration keys of the tunable bot parameters to their setters
+var setters = map[string]func(bp *t.BotParams, v float64){
+	"maPeriod1st": func(bp *t.BotParams, v float64) { bp.MAPeriod1st = int64(v) },
+	"maPeriod2nd": func(bp *t.BotParams, v float64) { bp.MAPeriod2nd = int64(v) },
+	"maPeriod3rd": func(bp *t.BotParams, v float64) { bp.MAPeriod3rd = int64(v) },
+	"atrSL":       func(bp *t.BotParams, v float64) { bp.AtrSL = v },
+	"atrTP":       func(bp *t.BotParams, v float64) { bp.AtrTP = v },
+	"quoteSL":     func(bp *t.BotParams, v float64) { bp.QuoteSL = v },
+	"quoteTP":     func(bp *t.BotParams, v float64) { bp.QuoteTP = v },
+	"mos":         func(bp *t.BotParams, v float64) { bp.MoS = v },
+	"gridSize":    func(bp *t.BotParams, v float64) { bp.GridSize = v },
+	"gridTP":      func(bp *t.BotParams, v float64) { bp.GridTP = v },
+	"orderGap":    func(bp *t.BotParams, v float64) { bp.OrderGap = v },
+	"orderGapATR": func(bp *t.BotParams, v float64) { bp.OrderGapATR = v },
+}
+
+// Range is the values of a bot parameter to sweep, from Min to Max by Step
+type Range struct {
+	Field string
+	Min   float64
+	Max   float64
+	Step  float64
+}
+
+// Values returns all values of the range
+func (r Range) Values() []float64 {
+	if r.Step <= 0 || r.Max <= r.Min {
+		return []float64{r.Min}
+	}
+	var values []float64
+	for i := 0; ; i++ {
+		v := h.NormalizeDouble(r.Min+float64(i)*r.Step, 8)
+		if v > r.Max {
+			break
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// ParseRange parses a range in the format of field=min:max:step, e.g. atrSL=1:3:0.5,
+// a single value, e.g. maPeriod1st=20, is a range of one value
+func ParseRange(s string) (*Range, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("invalid range: %s", s)
+	}
+	if _, ok := setters[kv[0]]; !ok {
+		return nil, fmt.Errorf("unsupported parameter: %s", kv[0])
+	}
+
+	var nums [3]float64
+	parts := strings.Split(kv[1], ":")
+	if len(parts) != 1 && len(parts) != 3 {
+		return nil, fmt.Errorf("invalid range: %s", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range: %s", s)
+		}
+		nums[i] = n
+	}
+	if len(parts) == 1 {
+		nums[1] = nums[0]
+	}
+	return &Range{Field: kv[0], Min: nums[0], Max: nums[1], Step: nums[2]}, nil
+}
+
+// Trial is the result of a backtest run with a combination of the parameter values,
+// Err is the error of the run that has failed
+type Trial struct {
+	Values []float64
+	Result
+	Err error
+}
+
+// Optimize runs the backtest over the cartesian product of the ranges in parallel,
+// then returns the trials ranked by the sort key. A failed run is kept as a trial with its error,
+// it fails only when every run has failed.
+func Optimize(p Params, ranges []Range, sortBy string, workers int) ([]Trial, error) {
+	if p.BP == nil {
+		return nil, errors.New("bot parameters are required")
+	}
+	for _, r := range ranges {
+		if _, ok := setters[r.Field]; !ok {
+			return nil, fmt.Errorf("unsupported parameter: %s", r.Field)
+		}
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	combos := [][]float64{{}}
+	for _, r := range ranges {
+		var next [][]float64
+		for _, c := range combos {
+			for _, v := range r.Values() {
+				next = append(next, append(append([]float64{}, c...), v))
+			}
+		}
+		combos = next
+	}
+
+	trials := make([]Trial, len(combos))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				bp := *p.BP
+				for j, r := range ranges {
+					setters[r.Field](&bp, combos[i][j])
+				}
+				tp := p
+				tp.BP = &bp
+				tp.DbName = ""
+				r, err := Run(tp)
+				if err != nil {
+					trials[i] = Trial{Values: combos[i], Err: err}
+					continue
+				}
+				trials[i] = Trial{Values: combos[i], Result: *r}
+			}
+		}()
+	}
+	for i := range combos {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	for _, tr := range trials {
+		if tr.Err != nil {
+			h.Log("Optimize", tr.Values, tr.Err)
+			failed++
+		}
+	}
+	if failed == len(trials) {
+		return nil, trials[0].Err
+	}
+
+	if err := SortTrials(trials, sortBy); err != nil {
+		return nil, err
+	}
+	return trials, nil
+}
+
+// SortTrials ranks the trials by net PnL, max drawdown or profit factor, the best first.
+// The trials without trades, then the failed trials, are ranked last.
+func SortTrials(trials []Trial, sortBy string) error {
+	var better func(a, b Result) bool
+	switch sortBy {
+	case SortByNetPL, "":
+		better = func(a, b Result) bool { return a.NetPL > b.NetPL }
+	case SortByDrawdown:
+		better = func(a, b Result) bool { return a.MaxDrawdown < b.MaxDrawdown }
+	case SortByProfitFactor:
+		better = func(a, b Result) bool { return a.ProfitFactor > b.ProfitFactor }
+	default:
+		return fmt.Errorf("invalid sort key: %s", sortBy)
+	}
+	sort.SliceStable(trials, func(i, j int) bool {
+		if ri, rj := rank(trials[i]), rank(trials[j]); ri != rj {
+			return ri < rj
+		}
+		a, b := trials[i].Result, trials[j].Result
+		if better(a, b) {
+			return true
+		}
+		if better(b, a) {
+			return false
+		}
+		return a.NetPL > b.NetPL
+	})
+	return nil
+}
+
+// rank returns the group of the trial in the ranking: with trades, without trades, then failed
+func rank(tr Trial) int {
+	if tr.Err != nil {
+		return 2
+	}
+	if tr.Trades == 0 {
+		return 1
+	}
+	return 0
+}
+
+// PrintTrials writes the top trials as a table
+func PrintTrials(w io.Writer, ranges []Range, trials []Trial, top int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, r := range ranges {
+		fmt.Fprintf(tw, "%s\t", r.Field)
+	}
+	fmt.Fprintln(tw, "Trades\tWinRate\tNetPL\tMaxDD\tPF\tOpen\tUnrealizedPL\t")
+	for i, tr := range trials {
+		if top > 0 && i >= top {
+			break
+		}
+		for _, v := range tr.Values {
+			fmt.Fprintf(tw, "%v\t", v)
+		}
+		if tr.Err != nil {
+			fmt.Fprintf(tw, "%v\t\n", tr.Err)
+			continue
+		}
+		winRate := 0.0
+		if tr.Trades > 0 {
+			winRate = float64(tr.Wins) / float64(tr.Trades) * 100
+		}
+		fmt.Fprintf(tw, "%d\t%.2f%%\t%f\t%f\t%.2f\t%d\t%f\t\n",
+			tr.Trades, winRate, tr.NetPL, tr.MaxDrawdown, tr.ProfitFactor, tr.OpenOrders, tr.UnrealizedPL)
+	}
+	tw.Flush()
+}
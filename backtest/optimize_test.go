@@ -0,0 +1,77 @@
+package backtest
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("atrSL=1:2:0.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := r.Values()
+	if len(values) != 5 || values[0] != 1 || values[4] != 2 {
+		t.Errorf("Unexpected values: %v", values)
+	}
+
+	r, err = ParseRange("maPeriod1st=20")
+	if err != nil || len(r.Values()) != 1 || r.Values()[0] != 20 {
+		t.Errorf("Unexpected range: %+v, %v", r, err)
+	}
+
+	for _, s := range []string{"atrSL", "atrSL=1:2", "unknown=1:2:1", "atrSL=a:b:c"} {
+		if _, err := ParseRange(s); err == nil {
+			t.Errorf("Expect an error for %s", s)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	bp := gridBotParams()
+	ranges := []Range{
+		{Field: "gridSize", Min: 6, Max: 12, Step: 6},
+		{Field: "gridTP", Min: 0.5, Max: 1, Step: 0.5},
+	}
+	trials, err := Optimize(Params{BP: &bp, Prices: sinePrices(1440), Timeframe: "1m"}, ranges, SortByNetPL, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trials) != 4 {
+		t.Fatalf("Expect 4 trials, got %d", len(trials))
+	}
+	for i := 1; i < len(trials); i++ {
+		if trials[i-1].NetPL < trials[i].NetPL {
+			t.Errorf("Trials must be ranked by net PnL: %v", trials)
+		}
+	}
+	if bp.GridSize != 12 || bp.GridTP != 1 {
+		t.Error("The bot parameters must not be modified")
+	}
+}
+
+func TestSortTrials(t *testing.T) {
+	trials := []Trial{
+		{Values: []float64{1}, Err: errors.New("invalid timeframe")},
+		{Values: []float64{2}},
+		{Values: []float64{3}, Result: Result{Trades: 5, NetPL: 10, MaxDrawdown: 4}},
+		{Values: []float64{4}, Result: Result{Trades: 3, NetPL: 5, MaxDrawdown: 2}},
+	}
+	if err := SortTrials(trials, SortByDrawdown); err != nil {
+		t.Fatal(err)
+	}
+	// The trial without trades has no drawdown, it must not be ranked first
+	var order []float64
+	for _, tr := range trials {
+		order = append(order, tr.Values[0])
+	}
+	if order[0] != 4 || order[1] != 3 || order[2] != 2 || order[3] != 1 {
+		t.Errorf("Unexpected ranking: %v", order)
+	}
+}
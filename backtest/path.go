@@ -0,0 +1,103 @@
+package backtest
+
+import (
+	"fmt"
+	"math/rand"
+
+	t "github.com/tonkla/autotp/types"
+)
+
+// Intrabar path models, the order in which the prices of a bar are visited
+const (
+	// PathAuto visits the low first in a green bar, and the high first in a red bar
+	PathAuto = "AUTO"
+	// PathOHLC visits OPEN, HIGH, LOW, CLOSE
+	PathOHLC = "OHLC"
+	// PathOLHC visits OPEN, LOW, HIGH, CLOSE
+	PathOLHC = "OLHC"
+	// PathNearest visits the extreme nearer to the open first
+	PathNearest = "NEAREST"
+	// PathRandom visits OHLC or OLHC by chance
+	PathRandom = "RANDOM"
+)
+
+// Path returns the prices visited inside the bar
+type Path func(p t.HistoricalPrice) []float64
+
+// NewPath returns the intrabar path of the model, the seed is used by the RANDOM model only
+func NewPath(model string, seed int64) (Path, error) {
+	switch model {
+	case PathAuto, "":
+		return autoPath, nil
+	case PathOHLC:
+		return ohlcPath, nil
+	case PathOLHC:
+		return olhcPath, nil
+	case PathNearest:
+		return func(p t.HistoricalPrice) []float64 {
+			up, down := p.High-p.Open, p.Open-p.Low
+			if up < down || (up == down && p.Close < p.Open) {
+				return ohlcPath(p)
+			}
+			if down < up {
+				return olhcPath(p)
+			}
+			return autoPath(p)
+		}, nil
+	case PathRandom:
+		rnd := rand.New(rand.NewSource(seed))
+		return func(p t.HistoricalPrice) []float64 {
+			if rnd.Intn(2) == 0 {
+				return ohlcPath(p)
+			}
+			return olhcPath(p)
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid path model: %s", model)
+}
+
+func autoPath(p t.HistoricalPrice) []float64 {
+	if p.Close >= p.Open {
+		return olhcPath(p)
+	}
+	return ohlcPath(p)
+}
+
+func ohlcPath(p t.HistoricalPrice) []float64 {
+	return []float64{p.Open, p.High, p.Low, p.Close}
+}
+
+func olhcPath(p t.HistoricalPrice) []float64 {
+	return []float64{p.Open, p.Low, p.High, p.Close}
+}
+
+// Ticks returns synthetic tickers along the path of the bar, spread over the duration of the bar
+func (path Path) Ticks(p t.HistoricalPrice, dur int64) []t.Ticker {
+	prices := path(p)
+	tickers := make([]t.Ticker, 0, len(prices))
+	for i, price := range prices {
+		tickers = append(tickers, t.Ticker{
+			Symbol: p.Symbol,
+			Price:  price,
+			Time:   p.Time + int64(i)*(dur-1)/int64(len(prices)-1),
+		})
+	}
+	return tickers
+}
+
+// subBarTicks returns the tickers of the bar from its sub-bars, starting at the sub-bar index i.
+// It falls back to the path of the bar, when there is no sub-bar inside the bar.
+// The next sub-bar index is returned as well.
+func subBarTicks(path Path, p t.HistoricalPrice, dur int64, subs []t.HistoricalPrice, subDur int64, i int) ([]t.Ticker, int) {
+	for i < len(subs) && subs[i].Time < p.Time {
+		i++
+	}
+	var tickers []t.Ticker
+	for ; i < len(subs) && subs[i].Time < p.Time+dur; i++ {
+		tickers = append(tickers, path.Ticks(subs[i], subDur)...)
+	}
+	if len(tickers) == 0 {
+		return path.Ticks(p, dur), i
+	}
+	return tickers, i
+}
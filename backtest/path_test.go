@@ -0,0 +1,79 @@
+package backtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tonkla/autotp/types"
+)
+
+func TestNewPath(t *testing.T) {
+	// A green bar, the high is nearer to the open
+	bar := types.HistoricalPrice{Open: 10, High: 12, Low: 5, Close: 11}
+
+	tests := map[string][]float64{
+		PathAuto:    {10, 5, 12, 11},
+		PathOHLC:    {10, 12, 5, 11},
+		PathOLHC:    {10, 5, 12, 11},
+		PathNearest: {10, 12, 5, 11},
+	}
+	for model, expected := range tests {
+		path, err := NewPath(model, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := path(bar); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: Expect: %v, Got: %v", model, expected, got)
+		}
+	}
+
+	if _, err := NewPath("UNKNOWN", 0); err == nil {
+		t.Error("Expect an error for an unknown model")
+	}
+}
+
+func TestRandomPath(t *testing.T) {
+	bar := types.HistoricalPrice{Open: 10, High: 12, Low: 5, Close: 11}
+	p1, _ := NewPath(PathRandom, 42)
+	p2, _ := NewPath(PathRandom, 42)
+
+	highFirst := 0
+	for i := 0; i < 100; i++ {
+		a, b := p1(bar), p2(bar)
+		if !reflect.DeepEqual(a, b) {
+			t.Fatal("The same seed must give the same paths")
+		}
+		if a[1] == bar.High {
+			highFirst++
+		}
+	}
+	if highFirst == 0 || highFirst == 100 {
+		t.Errorf("Expect both OHLC and OLHC paths, got %d OHLC paths of 100", highFirst)
+	}
+}
+
+func TestSubBarTicks(t *testing.T) {
+	path, _ := NewPath(PathAuto, 0)
+	bar := types.HistoricalPrice{Time: 3600000, Open: 10, High: 12, Low: 5, Close: 11}
+	subs := []types.HistoricalPrice{
+		{Time: 0, Open: 9, High: 9, Low: 9, Close: 9},
+		{Time: 3600000, Open: 10, High: 12, Low: 10, Close: 12},
+		{Time: 5400000, Open: 12, High: 12, Low: 5, Close: 11},
+		{Time: 7200000, Open: 11, High: 11, Low: 11, Close: 11},
+	}
+
+	ticks, next := subBarTicks(path, bar, 3600000, subs, 1800000, 0)
+	if next != 3 || len(ticks) != 8 {
+		t.Fatalf("Expect 8 ticks of 2 sub-bars, got %d, next %d", len(ticks), next)
+	}
+	// The high of the first half comes before the low of the second half
+	if ticks[2].Price != 12 || ticks[6].Price != 5 || ticks[7].Time != 7199999 {
+		t.Errorf("Unexpected ticks: %+v", ticks)
+	}
+
+	// No sub-bars, fall back to the path of the bar
+	ticks, _ = subBarTicks(path, bar, 3600000, nil, 1800000, 0)
+	if len(ticks) != 4 || ticks[1].Price != 5 {
+		t.Errorf("Unexpected ticks: %+v", ticks)
+	}
+}
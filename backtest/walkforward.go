@@ -0,0 +1,146 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// WalkForwardParams holds the inputs of a walk-forward analysis
+type WalkForwardParams struct {
+	Params
+	Ranges  []Range
+	SortBy  string
+	Workers int
+
+	// InSample and OutSample are the lengths of the windows in milliseconds
+	InSample  int64
+	OutSample int64
+}
+
+// Window is an in-sample optimization followed by an out-of-sample backtest
+// with the best parameter values of the in-sample window
+type Window struct {
+	InStart  int64
+	OutStart int64
+	OutEnd   int64
+
+	Values    []float64
+	InSample  Result
+	OutSample Result
+}
+
+// WalkForwardResult holds the windows, and the out-of-sample results stitched together
+type WalkForwardResult struct {
+	Windows []Window
+	Result
+}
+
+// WalkForward rolls the in-sample and the out-of-sample windows forward by the out-of-sample length.
+// Each out-of-sample window starts without any orders, the orders left open at its end
+// are counted in the stitched result as unrealized PnL at the end of the window.
+func WalkForward(p WalkForwardParams) (*WalkForwardResult, error) {
+	if p.BP == nil {
+		return nil, errors.New("bot parameters are required")
+	}
+	if p.InSample <= 0 || p.OutSample <= 0 {
+		return nil, errors.New("the in-sample and the out-of-sample lengths are required")
+	}
+	if len(p.Prices) == 0 {
+		return nil, errors.New("no historical prices to replay")
+	}
+
+	startTime := p.StartTime
+	if startTime < p.Prices[0].Time {
+		startTime = p.Prices[0].Time
+	}
+	endTime := p.EndTime
+	if last := p.Prices[len(p.Prices)-1].Time; endTime == 0 || endTime > last {
+		endTime = last
+	}
+
+	wf := WalkForwardResult{
+		Result: Result{
+			BotID:    p.BP.BotID,
+			Symbol:   p.BP.Symbol,
+			Strategy: p.BP.Strategy,
+		},
+	}
+	for inStart := startTime; inStart+p.InSample <= endTime; inStart += p.OutSample {
+		w := Window{
+			InStart:  inStart,
+			OutStart: inStart + p.InSample,
+			OutEnd:   inStart + p.InSample + p.OutSample - 1,
+		}
+		if w.OutEnd > endTime {
+			w.OutEnd = endTime
+		}
+
+		ip := p.Params
+		ip.StartTime = w.InStart
+		ip.EndTime = w.OutStart - 1
+		trials, err := Optimize(ip, p.Ranges, p.SortBy, p.Workers)
+		if err != nil {
+			return nil, err
+		}
+		w.Values = trials[0].Values
+		w.InSample = trials[0].Result
+
+		bp := *p.BP
+		for i, r := range p.Ranges {
+			setters[r.Field](&bp, w.Values[i])
+		}
+		op := p.Params
+		op.BP = &bp
+		op.StartTime = w.OutStart
+		op.EndTime = w.OutEnd
+		op.DbName = ""
+		r, err := Run(op)
+		if err != nil {
+			return nil, err
+		}
+		w.OutSample = *r
+
+		wf.pls = append(wf.pls, r.pls...)
+		wf.OpenOrders += r.OpenOrders
+		wf.UnrealizedPL += r.UnrealizedPL
+		wf.LastPrice = r.LastPrice
+		wf.Windows = append(wf.Windows, w)
+	}
+	if len(wf.Windows) == 0 {
+		return nil, errors.New("not enough historical prices for a window")
+	}
+
+	wf.addClosed()
+	wf.normalize(p.BP.PriceDigits)
+	return &wf, nil
+}
+
+// PrintWalkForward writes the windows and the stitched out-of-sample result as a table
+func PrintWalkForward(w io.Writer, ranges []Range, wf *WalkForwardResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "InSample\tOutSample\t")
+	for _, r := range ranges {
+		fmt.Fprintf(tw, "%s\t", r.Field)
+	}
+	fmt.Fprintln(tw, "IS NetPL\tOOS Trades\tOOS NetPL\tOOS MaxDD\tOOS PF\tOOS UnrealizedPL\t")
+	for _, win := range wf.Windows {
+		fmt.Fprintf(tw, "%s\t%s\t", formatTime(win.InStart), formatTime(win.OutStart))
+		for _, v := range win.Values {
+			fmt.Fprintf(tw, "%v\t", v)
+		}
+		o := win.OutSample
+		fmt.Fprintf(tw, "%f\t%d\t%f\t%f\t%.2f\t%f\t\n",
+			win.InSample.NetPL, o.Trades, o.NetPL, o.MaxDrawdown, o.ProfitFactor, o.UnrealizedPL)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	PrintSummary(w, []Result{wf.Result})
+}
+
+func formatTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,42 @@
+package backtest
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestWalkForward(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	bp := gridBotParams()
+	prices := sinePrices(2880)
+	wf, err := WalkForward(WalkForwardParams{
+		Params:    Params{BP: &bp, Prices: prices, Timeframe: "1m"},
+		Ranges:    []Range{{Field: "gridTP", Min: 0.5, Max: 1, Step: 0.5}},
+		Workers:   2,
+		InSample:  12 * 3600000,
+		OutSample: 12 * 3600000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wf.Windows) != 3 {
+		t.Fatalf("Expect 3 windows, got %d", len(wf.Windows))
+	}
+
+	trades, netPL := 0, 0.0
+	for i, w := range wf.Windows {
+		if w.OutStart != w.InStart+12*3600000 || (i > 0 && w.InStart != wf.Windows[i-1].OutStart) {
+			t.Errorf("Unexpected window %d: %+v", i, w)
+		}
+		trades += w.OutSample.Trades
+		netPL += w.OutSample.NetPL
+	}
+	if wf.Trades != trades || math.Abs(wf.NetPL-netPL) > 0.01 {
+		t.Errorf("The stitched result must sum the out-of-sample results: %+v", wf.Result)
+	}
+}
@@ -0,0 +1,45 @@
+package clock
+
+import (
+	"sync/atomic"
+
+	h "github.com/tonkla/autotp/helper"
+)
+
+// Clock tells the current time, so time-based logics can be replayed and tested
+type Clock interface {
+	Now13() int64
+}
+
+// Real is the clock of the system
+type Real struct{}
+
+// Now13 returns the current time in milliseconds
+func (Real) Now13() int64 {
+	return h.Now13()
+}
+
+// Sim is a simulated clock, which moves only when it is set
+type Sim struct {
+	ms int64
+}
+
+// NewSim returns a simulated clock at the time in milliseconds
+func NewSim(ms int64) *Sim {
+	return &Sim{ms: ms}
+}
+
+// Now13 returns the simulated time in milliseconds
+func (c *Sim) Now13() int64 {
+	return atomic.LoadInt64(&c.ms)
+}
+
+// Set moves the clock to the time in milliseconds
+func (c *Sim) Set(ms int64) {
+	atomic.StoreInt64(&c.ms, ms)
+}
+
+// Add moves the clock forward by the milliseconds
+func (c *Sim) Add(ms int64) {
+	atomic.AddInt64(&c.ms, ms)
+}
@@ -0,0 +1,29 @@
+package clock
+
+import (
+	"testing"
+
+	h "github.com/tonkla/autotp/helper"
+)
+
+func TestReal(t *testing.T) {
+	var c Clock = Real{}
+	if now := c.Now13(); now < h.Now13()-1000 || now > h.Now13() {
+		t.Errorf("Expect the system time, got %d", now)
+	}
+}
+
+func TestSim(t *testing.T) {
+	c := NewSim(1000)
+	if c.Now13() != 1000 {
+		t.Errorf("Expect 1000, got %d", c.Now13())
+	}
+	c.Add(500)
+	if c.Now13() != 1500 {
+		t.Errorf("Expect 1500, got %d", c.Now13())
+	}
+	c.Set(200)
+	if c.Now13() != 200 {
+		t.Errorf("Expect 200, got %d", c.Now13())
+	}
+}
@@ -0,0 +1,12 @@
+package exchange
+
+import (
+	t "github.com/tonkla/autotp/types"
+)
+
+// BatchRepository is implemented by the clients that can place and cancel several orders in a request.
+// The results are in the order of the orders, a failed order has its error instead.
+type BatchRepository interface {
+	OpenBatchOrders(orders []t.Order) ([]t.Order, []error)
+	CancelBatchOrders(orders []t.Order) ([]t.Order, []error)
+}
@@ -0,0 +1,74 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/tonkla/autotp/clock"
+	h "github.com/tonkla/autotp/helper"
+)
+
+// DefaultRecvWindow is the milliseconds that a signed request is valid for, after its timestamp
+const DefaultRecvWindow = 5000
+
+// syncInterval is the milliseconds between the synchronizations of the server time
+const syncInterval = 5 * 60 * 1000
+
+// ServerClock tells the time of the Binance server, by the offset of the local clock
+type ServerClock struct {
+	baseURL  string
+	local    clock.Clock
+	offset   int64
+	syncedAt int64
+	mu       sync.Mutex
+}
+
+// NewServerClock returns the clock of the server, which synchronizes from baseURL/time
+func NewServerClock(baseURL string) *ServerClock {
+	return &ServerClock{baseURL: baseURL, local: clock.Real{}}
+}
+
+// Now13 returns the server time in milliseconds, it synchronizes when the offset is outdated
+func (c *ServerClock) Now13() int64 {
+	now := c.local.Now13()
+	if now-atomic.LoadInt64(&c.syncedAt) > syncInterval {
+		if err := c.Sync(); err != nil {
+			h.Log("ServerClock", err)
+		}
+		now = c.local.Now13()
+	}
+	return now + atomic.LoadInt64(&c.offset)
+}
+
+// Offset returns the milliseconds that the server is ahead of the local clock
+func (c *ServerClock) Offset() int64 {
+	return atomic.LoadInt64(&c.offset)
+}
+
+// Sync queries the server time, and keeps its offset from the midpoint of the request
+func (c *ServerClock) Sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := c.local.Now13()
+	// A failed synchronization is not retried until the next interval
+	atomic.StoreInt64(&c.syncedAt, start)
+
+	data, err := h.Get(context.Background(), c.baseURL+"/time")
+	if err != nil {
+		return err
+	}
+	end := c.local.Now13()
+
+	r := gjson.ParseBytes(data)
+	serverTime := r.Get("serverTime").Int()
+	if serverTime <= 0 {
+		return fmt.Errorf("Sync: %s", r.Get("msg").String())
+	}
+	atomic.StoreInt64(&c.offset, serverTime-(start+end)/2)
+	return nil
+}
@@ -0,0 +1,93 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tonkla/autotp/clock"
+)
+
+const (
+	localTime  = 1634090700000
+	serverTime = localTime + 3000
+)
+
+func newTimeServer(calls *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/time", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"serverTime":%d}`, serverTime)
+	})
+	mux.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		q := r.URL.Query()
+		payload := strings.TrimSuffix(r.URL.RawQuery, "&signature="+q.Get("signature"))
+		ts, _ := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+		window, _ := strconv.ParseInt(q.Get("recvWindow"), 10, 64)
+		if Sign(payload, "secret") != q.Get("signature") {
+			w.Write([]byte(`{"code":-1022,"msg":"Signature for this request is not valid."}`))
+		} else if ts < serverTime-window || ts > serverTime+1000 {
+			w.Write([]byte(`{"code":-1021,"msg":"Timestamp for this request is outside of the recvWindow."}`))
+		} else {
+			w.Write([]byte(`{"orderId":22542179,"status":"NEW"}`))
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestServerClock(t *testing.T) {
+	var calls int32
+	srv := newTimeServer(&calls)
+	defer srv.Close()
+
+	local := clock.NewSim(localTime)
+	c := &ServerClock{baseURL: srv.URL, local: local}
+	if now := c.Now13(); now != serverTime || c.Offset() != 3000 {
+		t.Errorf("Expect the server time %d, got %d", serverTime, now)
+	}
+
+	// The offset is kept until the next synchronization
+	local.Add(1000)
+	if now := c.Now13(); now != serverTime+1000 {
+		t.Errorf("Expect the server time %d, got %d", serverTime+1000, now)
+	}
+}
+
+func TestSendResync(t *testing.T) {
+	var calls int32
+	srv := newTimeServer(&calls)
+	defer srv.Close()
+
+	// The clock was synchronized before the local clock drifted
+	c := &ServerClock{baseURL: srv.URL, local: clock.NewSim(localTime - 4000), syncedAt: localTime}
+
+	var payload strings.Builder
+	BuildBaseQS(&payload, "BNBUSDT", c.Now13(), 500)
+	url := fmt.Sprintf("%s/order?%s&signature=%s", srv.URL, payload.String(), Sign(payload.String(), "secret"))
+
+	data, err := Send(context.Background(), http.MethodPost, url, "key", "secret", c)
+	if err != nil || !strings.Contains(string(data), `"status":"NEW"`) {
+		t.Fatalf("Expect the order placed, got %s, %v", data, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expect a retry after the resynchronization, got %d calls", n)
+	}
+	if c.Offset() != serverTime-(localTime-4000) {
+		t.Errorf("Expect the offset %d, got %d", serverTime-(localTime-4000), c.Offset())
+	}
+
+	// Another clock is not resynchronized, the error is returned as is
+	atomic.StoreInt32(&calls, 0)
+	payload.Reset()
+	BuildBaseQS(&payload, "BNBUSDT", localTime, 500)
+	url = fmt.Sprintf("%s/order?%s&signature=%s", srv.URL, payload.String(), Sign(payload.String(), "secret"))
+	data, _ = Send(context.Background(), http.MethodPost, url, "key", "secret", clock.NewSim(localTime))
+	if !strings.Contains(string(data), `"code":-1021`) || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expect the error -1021 without a retry, got %s", data)
+	}
+}
@@ -1,22 +1,31 @@
 package binance
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/tidwall/gjson"
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/exerr"
 	h "github.com/tonkla/autotp/helper"
 	t "github.com/tonkla/autotp/types"
 )
 
 type Client struct {
-	BaseURL   string
-	ApiKey    string
-	SecretKey string
+	// Ctx cancels the requests, they are not canceled when it is nil
+	Ctx        context.Context
+	BaseURL    string
+	ApiKey     string
+	SecretKey  string
+	Clock      clock.Clock
+	RecvWindow int64
 }
 
 // Sign signs a payload with a Binance API secret key
@@ -32,9 +41,86 @@ func NewHeader(apiKey string) http.Header {
 	return header
 }
 
-// Build a base query string
-func BuildBaseQS(payload *strings.Builder, symbol string) {
-	fmt.Fprintf(payload, "timestamp=%d&recvWindow=50000&symbol=%s", h.Now13(), symbol)
+// Build a base query string, the timestamp and the recvWindow are in milliseconds
+func BuildBaseQS(payload *strings.Builder, symbol string, timestamp int64, recvWindow int64) {
+	fmt.Fprintf(payload, "timestamp=%d&recvWindow=%d&symbol=%s", timestamp, recvWindow, symbol)
+}
+
+var (
+	reTimestamp = regexp.MustCompile(`timestamp=\d+`)
+	reSignature = regexp.MustCompile(`&signature=[0-9a-f]+$`)
+)
+
+// Send calls the signed URL with the method, it is canceled by the context. When the timestamp is outside
+// the recvWindow (-1021), the server clock is synchronized, and the request is signed again and retried once.
+func Send(ctx context.Context, method string, rawURL string, apiKey string, secretKey string, cl clock.Clock) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	data, err := h.Do(ctx, method, rawURL, NewHeader(apiKey), "")
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := cl.(*ServerClock)
+	if !ok || gjson.GetBytes(data, "code").Int() != -1021 {
+		return data, nil
+	}
+	if err := sc.Sync(); err != nil {
+		return data, nil
+	}
+	h.Log("Send", "resynchronized the server time, offset", sc.Offset())
+
+	i := strings.Index(rawURL, "?")
+	if i < 0 {
+		return data, nil
+	}
+	payload := reSignature.ReplaceAllString(rawURL[i+1:], "")
+	payload = reTimestamp.ReplaceAllString(payload, fmt.Sprintf("timestamp=%d", sc.Now13()))
+	signedURL := fmt.Sprintf("%s?%s&signature=%s", rawURL[:i], payload, Sign(payload, secretKey))
+	return h.Do(ctx, method, signedURL, NewHeader(apiKey), "")
+}
+
+// NewError returns the error of the response of the operation, its kind is mapped from the code of Binance
+func NewError(op string, r gjson.Result) error {
+	code := r.Get("code").Int()
+	msg := r.Get("msg").String()
+	return exerr.New(op, code, msg, errorKind(code, msg))
+}
+
+// errorKind returns the kind of the error code, https://binance-docs.github.io/apidocs/spot/en/#error-codes
+func errorKind(code int64, msg string) error {
+	switch code {
+	case -1003, -1015:
+		return exerr.ErrRateLimited
+	case -1021:
+		return exerr.ErrTimestamp
+	case -1002, -1022, -2014, -2015:
+		return exerr.ErrUnauthorized
+	case -1001, -1016:
+		return exerr.ErrUnavailable
+	case -1007:
+		return exerr.ErrUnknownStatus
+	case -2011, -2013:
+		return exerr.ErrOrderNotFound
+	case -2018, -2019:
+		return exerr.ErrInsufficientBalance
+	case -2021, -5022:
+		return exerr.ErrWouldTrigger
+	case -2010:
+		// The spot rejects a new order with this code, its message tells the reason
+		msg = strings.ToLower(msg)
+		if strings.Contains(msg, "insufficient balance") {
+			return exerr.ErrInsufficientBalance
+		} else if strings.Contains(msg, "immediately") {
+			return exerr.ErrWouldTrigger
+		}
+		return exerr.ErrRejected
+	}
+	// -11xx are the invalid parameters, -1013 is a failure of the filters, -4xxx are the invalid futures orders
+	if code == -1013 || (code <= -1100 && code > -1200) || (code <= -4000 && code > -5000) {
+		return exerr.ErrInvalidOrder
+	}
+	return exerr.ErrRejected
 }
 
 // GetTicker returns the latest ticker
@@ -42,7 +128,7 @@ func GetTicker(baseURL string, symbol string) *t.Ticker {
 	var url strings.Builder
 
 	fmt.Fprintf(&url, "%s/ticker/price?symbol=%s", baseURL, symbol)
-	data, err := h.Get(url.String())
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
 		return nil
 	}
@@ -55,12 +141,72 @@ func GetTicker(baseURL string, symbol string) *t.Ticker {
 	}
 }
 
+// GetSymbolInfo returns the filters of the symbol from the exchange information
+func GetSymbolInfo(baseURL string, symbol string) (*t.SymbolInfo, error) {
+	var url strings.Builder
+
+	fmt.Fprintf(&url, "%s/exchangeInfo?symbol=%s", baseURL, symbol)
+	data, err := h.Get(context.Background(), url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	r := gjson.ParseBytes(data)
+
+	if r.Get("code").Int() < 0 {
+		return nil, NewError("GetSymbolInfo", r)
+	}
+
+	return ParseSymbolInfo(r, symbol)
+}
+
+// ParseSymbolInfo returns the filters of the symbol, of either the spot or the futures exchange information
+func ParseSymbolInfo(r gjson.Result, symbol string) (*t.SymbolInfo, error) {
+	var s gjson.Result
+	for _, _s := range r.Get("symbols").Array() {
+		if _s.Get("symbol").String() == symbol {
+			s = _s
+			break
+		}
+	}
+	if !s.Exists() {
+		return nil, fmt.Errorf("GetSymbolInfo: unknown symbol %s", symbol)
+	}
+
+	si := t.SymbolInfo{Symbol: symbol}
+	for _, f := range s.Get("filters").Array() {
+		switch f.Get("filterType").String() {
+		case "PRICE_FILTER":
+			si.TickSize = f.Get("tickSize").Float()
+		case "LOT_SIZE":
+			si.StepSize = f.Get("stepSize").Float()
+			si.MinQty = f.Get("minQty").Float()
+			si.MaxQty = f.Get("maxQty").Float()
+		case "MIN_NOTIONAL", "NOTIONAL":
+			// The futures name it notional
+			if v := f.Get("minNotional").Float(); v > 0 {
+				si.MinNotional = v
+			} else {
+				si.MinNotional = f.Get("notional").Float()
+			}
+		case "PERCENT_PRICE":
+			si.MultiplierUp = f.Get("multiplierUp").Float()
+			si.MultiplierDown = f.Get("multiplierDown").Float()
+		case "PERCENT_PRICE_BY_SIDE":
+			// The narrower bounds of both sides
+			si.MultiplierUp = math.Min(f.Get("bidMultiplierUp").Float(), f.Get("askMultiplierUp").Float())
+			si.MultiplierDown = math.Max(f.Get("bidMultiplierDown").Float(), f.Get("askMultiplierDown").Float())
+		}
+	}
+	return &si, nil
+}
+
 // GetOrderBook returns an order book (market depth)
 func GetOrderBook(baseURL string, symbol string, limit int) *t.OrderBook {
 	var url strings.Builder
 
 	fmt.Fprintf(&url, "%s/depth?symbol=%s&limit=%d", baseURL, symbol, limit)
-	data, err := h.Get(url.String())
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
 		return nil
 	}
@@ -93,7 +239,7 @@ func GetHistoricalPrices(baseURL string, symbol string, timeframe string, limit
 	var url strings.Builder
 
 	fmt.Fprintf(&url, "%s/klines?symbol=%s&interval=%s&limit=%d", baseURL, symbol, timeframe, limit)
-	data, err := h.Get(url.String())
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
 		return nil
 	}
@@ -117,6 +263,48 @@ func GetHistoricalPrices(baseURL string, symbol string, timeframe string, limit
 	return hPrices
 }
 
+// GetHistoricalPricesRange returns historical prices between the start time and the end time,
+// fetched page by page, each page has the limit number of k-lines at most
+func GetHistoricalPricesRange(baseURL string, symbol string, timeframe string, startTime int64, endTime int64, limit int) ([]t.HistoricalPrice, error) {
+	var hPrices []t.HistoricalPrice
+	for startTime <= endTime {
+		var url strings.Builder
+
+		fmt.Fprintf(&url, "%s/klines?symbol=%s&interval=%s&startTime=%d&endTime=%d&limit=%d",
+			baseURL, symbol, timeframe, startTime, endTime, limit)
+		data, err := h.Get(context.Background(), url.String())
+		if err != nil {
+			return nil, err
+		}
+
+		r := gjson.ParseBytes(data)
+		if r.Get("code").Int() < 0 {
+			return nil, NewError("GetHistoricalPricesRange", r)
+		}
+
+		rs := r.Array()
+		for _, data := range rs {
+			d := data.Array()
+			if len(d) < 5 {
+				continue
+			}
+			hPrices = append(hPrices, t.HistoricalPrice{
+				Symbol: symbol,
+				Time:   d[0].Int(),
+				Open:   d[1].Float(),
+				High:   d[2].Float(),
+				Low:    d[3].Float(),
+				Close:  d[4].Float(),
+			})
+		}
+		if len(rs) < limit || len(hPrices) == 0 {
+			break
+		}
+		startTime = hPrices[len(hPrices)-1].Time + 1
+	}
+	return hPrices, nil
+}
+
 // GetOrderByID returns the order by its ID
 func GetOrderByID(c Client, symbol string, ID string, refID string) (*t.Order, error) {
 	if symbol == "" || (ID == "" && refID == "") {
@@ -125,7 +313,7 @@ func GetOrderByID(c Client, symbol string, ID string, refID string) (*t.Order, e
 
 	var payload, url strings.Builder
 
-	BuildBaseQS(&payload, symbol)
+	BuildBaseQS(&payload, symbol, c.Clock.Now13(), c.RecvWindow)
 	if refID != "" {
 		fmt.Fprintf(&payload, "&orderId=%s", refID)
 	}
@@ -136,21 +324,23 @@ func GetOrderByID(c Client, symbol string, ID string, refID string) (*t.Order, e
 	signature := Sign(payload.String(), c.SecretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.BaseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), NewHeader(c.ApiKey))
+	data, err := Send(c.Ctx, http.MethodGet, url.String(), c.ApiKey, c.SecretKey, c.Clock)
 	if err != nil {
 		return nil, err
 	}
 
 	r := gjson.ParseBytes(data)
 
+	// -2013: Order does not exist
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("GetOrderByID: %s", r.Get("msg").String())
+		return nil, NewError("GetOrderByID", r)
 	}
 
 	return &t.Order{
 		ID:         ID,
-		RefID:      refID,
+		RefID:      r.Get("orderId").String(),
 		Symbol:     symbol,
+		OpenPrice:  r.Get("avgPrice").Float(),
 		Status:     r.Get("status").String(),
 		UpdateTime: r.Get("updateTime").Int(),
 	}, nil
@@ -165,7 +355,14 @@ func GetOrder(c Client, o t.Order) (*t.Order, error) {
 	if exo == nil {
 		return nil, nil
 	}
+	if exo.RefID != "" {
+		o.RefID = exo.RefID
+	}
 	o.Status = exo.Status
 	o.UpdateTime = exo.UpdateTime
+	// The average price of the filled order, only on futures
+	if exo.OpenPrice > 0 {
+		o.OpenPrice = exo.OpenPrice
+	}
 	return &o, nil
 }
@@ -1,8 +1,13 @@
 package binance
 
 import (
+	"errors"
 	"strings"
 	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/tonkla/autotp/exchange/exerr"
 )
 
 func TestSign(t *testing.T) {
@@ -14,3 +19,64 @@ func TestSign(t *testing.T) {
 		t.Fail()
 	}
 }
+
+const spotExchangeInfo = `{"symbols":[{"symbol":"BNBUSDT","filters":[
+{"filterType":"PRICE_FILTER","minPrice":"0.10000000","maxPrice":"100000.00000000","tickSize":"0.10000000"},
+{"filterType":"LOT_SIZE","minQty":"0.00100000","maxQty":"9000000.00000000","stepSize":"0.00100000"},
+{"filterType":"PERCENT_PRICE_BY_SIDE","bidMultiplierUp":"5","bidMultiplierDown":"0.2","askMultiplierUp":"4","askMultiplierDown":"0.1"},
+{"filterType":"NOTIONAL","minNotional":"5.00000000","applyMinToMarket":true}]}]}`
+
+const futuresExchangeInfo = `{"symbols":[{"symbol":"BTCUSDT","filters":[]},{"symbol":"BNBUSDT","filters":[
+{"filterType":"PRICE_FILTER","minPrice":"6.600","maxPrice":"100000","tickSize":"0.010"},
+{"filterType":"LOT_SIZE","stepSize":"0.01","maxQty":"100000","minQty":"0.01"},
+{"filterType":"MIN_NOTIONAL","notional":"5"},
+{"filterType":"PERCENT_PRICE","multiplierUp":"1.0500","multiplierDown":"0.9500","multiplierDecimal":"4"}]}]}`
+
+func TestParseSymbolInfo(t *testing.T) {
+	si, err := ParseSymbolInfo(gjson.Parse(spotExchangeInfo), "BNBUSDT")
+	if err != nil || si.TickSize != 0.1 || si.StepSize != 0.001 || si.MinQty != 0.001 || si.MaxQty != 9000000 ||
+		si.MinNotional != 5 || si.MultiplierUp != 4 || si.MultiplierDown != 0.2 {
+		t.Errorf("Unexpected spot info: %+v, %v", si, err)
+	}
+
+	si, err = ParseSymbolInfo(gjson.Parse(futuresExchangeInfo), "BNBUSDT")
+	if err != nil || si.TickSize != 0.01 || si.StepSize != 0.01 || si.MinNotional != 5 ||
+		si.MultiplierUp != 1.05 || si.MultiplierDown != 0.95 {
+		t.Errorf("Unexpected futures info: %+v, %v", si, err)
+	}
+
+	if _, err = ParseSymbolInfo(gjson.Parse(futuresExchangeInfo), "ETHUSDT"); err == nil {
+		t.Error("Expect an unknown symbol")
+	}
+}
+
+func TestNewError(t *testing.T) {
+	cases := []struct {
+		body string
+		kind error
+	}{
+		{`{"code":-1021,"msg":"Timestamp for this request is outside of the recvWindow."}`, exerr.ErrTimestamp},
+		{`{"code":-2015,"msg":"Invalid API-key, IP, or permissions for action."}`, exerr.ErrUnauthorized},
+		{`{"code":-2013,"msg":"Order does not exist."}`, exerr.ErrOrderNotFound},
+		{`{"code":-2019,"msg":"Margin is insufficient."}`, exerr.ErrInsufficientBalance},
+		{`{"code":-2021,"msg":"Order would immediately trigger."}`, exerr.ErrWouldTrigger},
+		{`{"code":-2010,"msg":"Account has insufficient balance for requested action."}`, exerr.ErrInsufficientBalance},
+		{`{"code":-2010,"msg":"Stop price would trigger immediately."}`, exerr.ErrWouldTrigger},
+		{`{"code":-1111,"msg":"Precision is over the maximum defined for this asset."}`, exerr.ErrInvalidOrder},
+		{`{"code":-4164,"msg":"Order's notional must be no smaller than 5."}`, exerr.ErrInvalidOrder},
+	}
+	for _, c := range cases {
+		err := NewError("OpenStopOrder", gjson.Parse(c.body))
+		if !errors.Is(err, c.kind) {
+			t.Errorf("Expect %v of %s, got %v", c.kind, c.body, err)
+		}
+	}
+
+	err := NewError("OpenLimitOrder", gjson.Parse(`{"code":-1003,"msg":"Too many requests."}`))
+	if !exerr.IsRetryable(err) || exerr.IsRejected(err) || err.Error() != "OpenLimitOrder: Too many requests." {
+		t.Errorf("Expect a retryable error, got %v", err)
+	}
+	if err = NewError("OpenLimitOrder", gjson.Parse(`{"code":-2019}`)); !exerr.IsRejected(err) || exerr.IsRetryable(err) {
+		t.Errorf("Expect a rejected order, got %v", err)
+	}
+}
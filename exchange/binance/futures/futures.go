@@ -1,29 +1,93 @@
 package futures
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/tidwall/gjson"
+	"github.com/tonkla/autotp/clock"
 	b "github.com/tonkla/autotp/exchange/binance"
+	"github.com/tonkla/autotp/exchange/exerr"
 	h "github.com/tonkla/autotp/helper"
 	t "github.com/tonkla/autotp/types"
 )
 
+// The REST and the WebSocket endpoints of the Futures production and testnet
+const (
+	BaseURL        = "https://fapi.binance.com/fapi/v1"
+	WsURL          = "wss://fstream.binance.com"
+	TestnetBaseURL = "https://testnet.binancefuture.com/fapi/v1"
+	TestnetWsURL   = "wss://stream.binancefuture.com"
+)
+
+// v1Path and v2Path are the paths of the REST endpoints from the root of the host
+const (
+	v1Path = "/fapi/v1"
+	v2Path = "/fapi/v2"
+)
+
 type Client struct {
+	// rootURL is the root of the REST host, baseURL is of its v1 endpoints
+	rootURL   string
 	baseURL   string
+	wsURL     string
 	apiKey    string
 	secretKey string
+	clock     clock.Clock
+	// recvWindow is the milliseconds that a signed request is valid for
+	recvWindow int64
+	// ctx cancels the signed requests, they are not canceled when it is nil
+	ctx context.Context
 }
 
 // NewFuturesClient returns Binance USDⓈ-M Futures client
 func NewFuturesClient(apiKey string, secretKey string) Client {
 	return Client{
-		baseURL:   "https://fapi.binance.com/fapi/v1",
-		apiKey:    apiKey,
-		secretKey: secretKey,
+		rootURL:    strings.TrimSuffix(BaseURL, v1Path),
+		baseURL:    BaseURL,
+		wsURL:      WsURL,
+		apiKey:     apiKey,
+		secretKey:  secretKey,
+		clock:      b.NewServerClock(BaseURL),
+		recvWindow: b.DefaultRecvWindow,
+	}
+}
+
+// WithRecvWindow returns the client that signs the requests with the recvWindow in milliseconds
+func (c Client) WithRecvWindow(recvWindow int64) Client {
+	if recvWindow > 0 {
+		c.recvWindow = recvWindow
+	}
+	return c
+}
+
+// WithContext returns the client that its signed requests are canceled by the context
+func (c Client) WithContext(ctx context.Context) Client {
+	c.ctx = ctx
+	return c
+}
+
+// WithEndpoints returns the client of the REST and the WebSocket base URLs, e.g. of the testnet.
+// The REST base URL is the root of the host, with or without /fapi/v1. An empty URL is unchanged.
+func (c Client) WithEndpoints(baseURL string, wsURL string) Client {
+	if baseURL != "" {
+		c.rootURL = strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), v1Path)
+		c.baseURL = c.rootURL + v1Path
+		c.clock = b.NewServerClock(c.baseURL)
+	}
+	if wsURL != "" {
+		c.wsURL = strings.TrimSuffix(wsURL, "/")
 	}
+	return c
+}
+
+// send calls the signed URL with the method
+func (c Client) send(method string, url string) ([]byte, error) {
+	return b.Send(c.ctx, method, url, c.apiKey, c.secretKey, c.clock)
 }
 
 // GetTicker returns the latest ticker
@@ -36,11 +100,21 @@ func (c Client) GetOrderBook(symbol string, limit int) *t.OrderBook {
 	return b.GetOrderBook(c.baseURL, symbol, limit)
 }
 
+// GetSymbolInfo returns the tick size, the lot size and the other filters of the symbol
+func (c Client) GetSymbolInfo(symbol string) (*t.SymbolInfo, error) {
+	return b.GetSymbolInfo(c.baseURL, symbol)
+}
+
 // GetHistoricalPrices returns historical prices in a format of k-lines/candlesticks
 func (c Client) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
 	return b.GetHistoricalPrices(c.baseURL, symbol, timeframe, limit)
 }
 
+// GetHistoricalPricesRange returns historical prices between the start time and the end time
+func (c Client) GetHistoricalPricesRange(symbol string, timeframe string, startTime int64, endTime int64) ([]t.HistoricalPrice, error) {
+	return b.GetHistoricalPricesRange(c.baseURL, symbol, timeframe, startTime, endTime, 1500)
+}
+
 // Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
 func (c Client) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
 	return c.GetHistoricalPrices(symbol, "1w", limit)
@@ -66,6 +140,23 @@ func (c Client) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalP
 	return c.GetHistoricalPrices(symbol, "15m", limit)
 }
 
+// StreamOrders pushes the order updates of the user data stream, until the context is done
+func (c Client) StreamOrders(ctx context.Context, onUpdate func(t.OrderUpdate), connected func(bool)) {
+	us := b.UserStream{
+		ListenKeyURL: c.baseURL + "/listenKey",
+		WsURL:        c.wsURL + "/ws",
+		ApiKey:       c.apiKey,
+		Futures:      true,
+	}
+	us.Run(ctx, onUpdate, connected)
+}
+
+// StreamMarket pushes the tickers and the k-lines of the symbol from the market data stream, until the context is done
+func (c Client) StreamMarket(ctx context.Context, symbol string, tickerSource string, subscribe <-chan string,
+	onTicker func(t.Ticker), onKline func(string, t.HistoricalPrice), connected func(bool)) {
+	b.NewMarketStream(c.wsURL, symbol, tickerSource).Stream(ctx, subscribe, onTicker, onKline, connected)
+}
+
 // OpenLimitOrder opens a limit order
 func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
 	if o.Type != t.OrderTypeLimit {
@@ -74,14 +165,14 @@ func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
 
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, o.Symbol)
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
 	fmt.Fprintf(&payload, "&newClientOrderId=%s&side=%s&positionSide=%s&type=%s&quantity=%f&price=%f&timeInForce=GTC",
 		o.ID, o.Side, o.PosSide, o.Type, o.Qty, o.OpenPrice)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.Post(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodPost, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +180,7 @@ func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
 	r := gjson.ParseBytes(data)
 
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("OpenLimitOrder: %s", r.Get("msg").String())
+		return nil, b.NewError("OpenLimitOrder", r)
 	}
 
 	status := r.Get("status").String()
@@ -102,26 +193,93 @@ func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
 	return &o, nil
 }
 
-func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
-	return nil, nil
+// reduceOnly returns the reduceOnly parameter of the close order, which is rejected in the hedge mode
+func reduceOnly(o t.Order) string {
+	if o.ReduceOnly && (o.PosSide == "" || o.PosSide == t.OrderPosSideBoth) {
+		return "&reduceOnly=true"
+	}
+	return ""
 }
 
-// OpenStopOrder opens a stop order
-func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
-	if o.Type != t.OrderTypeFSL && o.Type != t.OrderTypeFTP {
+// OpenMarketOrder opens a market order, the fill price, the quantity and the commission are of the trades
+func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
+	if o.Type != t.OrderTypeMarket {
 		return nil, nil
 	}
 
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, o.Symbol)
-	fmt.Fprintf(&payload, "&newClientOrderId=%s&side=%s&positionSide=%s&type=%s&quantity=%f&price=%f&stopPrice=%f&timeInForce=GTC",
-		o.ID, o.Side, o.PosSide, o.Type, o.Qty, o.OpenPrice, o.StopPrice)
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
+	fmt.Fprintf(&payload, "&newClientOrderId=%s&side=%s&positionSide=%s&type=%s&quantity=%f&newOrderRespType=RESULT%s",
+		o.ID, o.Side, o.PosSide, o.Type, o.Qty, reduceOnly(o))
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
+	data, err := c.send(http.MethodPost, url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	r := gjson.ParseBytes(data)
+
+	if r.Get("code").Int() < 0 {
+		return nil, b.NewError("OpenMarketOrder", r)
+	}
+
+	o.RefID = r.Get("orderId").String()
+	o.Status = r.Get("status").String()
+	o.OpenTime = r.Get("updateTime").Int()
+	o.UpdateTime = o.OpenTime
+	if price := r.Get("avgPrice").Float(); price > 0 {
+		o.OpenPrice = price
+	}
+	if qty := r.Get("executedQty").Float(); qty > 0 {
+		o.Qty = qty
+	}
+	if commission, err := c.getOrderCommission(o.Symbol, o.RefID); err == nil {
+		o.Commission = commission
+	} else {
+		h.Log("OpenMarketOrder", err)
+	}
+	return &o, nil
+}
+
+// OpenStopOrder opens a stop order, STOP/TAKE_PROFIT at the limit price, STOP_MARKET/TAKE_PROFIT_MARKET,
+// or TRAILING_STOP_MARKET that is activated at the stop price and follows the price by the callback rate.
+// A market stop order with closePosition closes the whole position, without a quantity.
+func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
+	var payload, url strings.Builder
+
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
+	fmt.Fprintf(&payload, "&newClientOrderId=%s&side=%s&positionSide=%s&type=%s",
+		o.ID, o.Side, o.PosSide, o.Type)
+
+	switch o.Type {
+	case t.OrderTypeFSL, t.OrderTypeFTP:
+		fmt.Fprintf(&payload, "&stopPrice=%f&quantity=%f&price=%f&timeInForce=GTC%s",
+			o.StopPrice, o.Qty, o.OpenPrice, reduceOnly(o))
+	case t.OrderTypeFSLM, t.OrderTypeFTPM:
+		fmt.Fprintf(&payload, "&stopPrice=%f", o.StopPrice)
+		if o.ClosePosition {
+			fmt.Fprintf(&payload, "&closePosition=true")
+		} else {
+			fmt.Fprintf(&payload, "&quantity=%f%s", o.Qty, reduceOnly(o))
+		}
+	case t.OrderTypeFTSM:
+		// The trailing stop is activated at the current price, when it has no activation price
+		fmt.Fprintf(&payload, "&callbackRate=%.1f&quantity=%f%s", o.CallbackRate, o.Qty, reduceOnly(o))
+		if o.StopPrice > 0 {
+			fmt.Fprintf(&payload, "&activationPrice=%f", o.StopPrice)
+		}
+	default:
+		return nil, nil
+	}
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.Post(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodPost, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -129,10 +287,11 @@ func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
 	r := gjson.ParseBytes(data)
 
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("OpenStopOrder: %s", r.Get("msg").String())
+		return nil, b.NewError("OpenStopOrder", r)
 	}
 
 	o.RefID = r.Get("orderId").String()
+	o.Status = r.Get("status").String()
 	o.OpenTime = r.Get("updateTime").Int()
 	return &o, nil
 }
@@ -141,7 +300,7 @@ func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
 func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime int) ([]t.TradeOrder, error) {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	if limit > 0 {
 		fmt.Fprintf(&payload, "&limit=%d", limit)
@@ -158,7 +317,7 @@ func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime in
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/userTrades?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +326,7 @@ func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime in
 
 	if rs.Get("code").Int() < 0 {
 		h.Log("GetTradeList", rs)
-		return nil, errors.New(rs.Get("msg").String())
+		return nil, b.NewError("GetTradeList", rs)
 	}
 
 	var orders []t.TradeOrder
@@ -193,9 +352,12 @@ func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime in
 // GetOrder returns the order by its IDs
 func (c Client) GetOrder(o t.Order) (*t.Order, error) {
 	cc := b.Client{
-		BaseURL:   c.baseURL,
-		ApiKey:    c.apiKey,
-		SecretKey: c.secretKey,
+		Ctx:        c.ctx,
+		BaseURL:    c.baseURL,
+		ApiKey:     c.apiKey,
+		SecretKey:  c.secretKey,
+		Clock:      c.clock,
+		RecvWindow: c.recvWindow,
 	}
 	return b.GetOrder(cc, o)
 }
@@ -204,12 +366,12 @@ func (c Client) GetOrder(o t.Order) (*t.Order, error) {
 func (c Client) CountOpenOrders(symbol string) (int, error) {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/openOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return 0, err
 	}
@@ -217,8 +379,7 @@ func (c Client) CountOpenOrders(symbol string) (int, error) {
 	rs := gjson.ParseBytes(data)
 
 	if rs.Get("code").Int() < 0 {
-		h.Log("CountOpenOrders", rs)
-		return 0, errors.New("error")
+		return 0, b.NewError("CountOpenOrders", rs)
 	}
 
 	return len(rs.Array()), nil
@@ -228,12 +389,12 @@ func (c Client) CountOpenOrders(symbol string) (int, error) {
 func (c Client) GetOpenOrders(symbol string) []t.Order {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/openOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil
 	}
@@ -269,7 +430,7 @@ func (c Client) GetOpenOrders(symbol string) []t.Order {
 func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime int) []t.Order {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	if limit > 0 {
 		fmt.Fprintf(&payload, "&limit=%d", limit)
@@ -284,7 +445,7 @@ func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime in
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/allOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil
 	}
@@ -320,14 +481,14 @@ func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime in
 func (c Client) GetCommission(symbol string, orderRefID string) *float64 {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	fmt.Fprintf(&payload, "&limit=10")
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/userTrades?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil
 	}
@@ -349,22 +510,69 @@ func (c Client) GetCommission(symbol string, orderRefID string) *float64 {
 	return nil
 }
 
-// CloseOrder closes an order
+// CloseOrder closes a filled order with an opposite reduce-only market order, and returns the market order.
+// The market order is of the close order ID of the order, when it has been given.
 func (c Client) CloseOrder(o t.Order) (*t.Order, error) {
-	return nil, nil
+	if o.Status != t.OrderStatusFilled {
+		return nil, exerr.New("CloseOrder", 0, "the order is not filled", exerr.ErrInvalidOrder)
+	}
+	id := o.CloseOrderID
+	if id == "" {
+		id = h.GenID()
+	}
+	return c.OpenMarketOrder(t.Order{
+		ID:          id,
+		BotID:       o.BotID,
+		Exchange:    o.Exchange,
+		Symbol:      o.Symbol,
+		Side:        h.Reverse(o.Side),
+		PosSide:     o.PosSide,
+		Type:        t.OrderTypeMarket,
+		Qty:         o.Qty,
+		OpenOrderID: o.ID,
+		ReduceOnly:  true,
+	})
+}
+
+// getOrderCommission returns the sum of the commissions of the trades of the order
+func (c Client) getOrderCommission(symbol string, orderRefID string) (float64, error) {
+	var payload, url strings.Builder
+
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
+	fmt.Fprintf(&payload, "&orderId=%s", orderRefID)
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/userTrades?%s&signature=%s", c.baseURL, payload.String(), signature)
+	data, err := c.send(http.MethodGet, url.String())
+	if err != nil {
+		return 0, err
+	}
+
+	rs := gjson.ParseBytes(data)
+
+	if rs.Get("code").Int() < 0 {
+		return 0, b.NewError("getOrderCommission", rs)
+	}
+
+	var commission float64
+	for _, r := range rs.Array() {
+		commission += r.Get("commission").Float()
+	}
+	return commission, nil
 }
 
 // CancelOrder cancels an order on the Binance Futures
 func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, o.Symbol)
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
 	fmt.Fprintf(&payload, "&orderId=%s&origClientOrderId=%s", o.RefID, o.ID)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.Delete(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodDelete, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -372,7 +580,7 @@ func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
 	r := gjson.ParseBytes(data)
 
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("CancelOrder: %s", r.Get("msg").String())
+		return nil, b.NewError("CancelOrder", r)
 	}
 
 	status := r.Get("status").String()
@@ -380,6 +588,347 @@ func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
 		return nil, nil
 	}
 	o.Status = status
-	o.UpdateTime = h.Now13()
+	o.UpdateTime = c.clock.Now13()
 	return &o, nil
 }
+
+// The maximum numbers of the orders in a request of batchOrders
+const (
+	maxBatchOrders  = 5
+	maxBatchCancels = 10
+)
+
+// OpenBatchOrders opens the limit and the stop orders, up to 5 orders per request.
+// The results are in the order of the orders, a failed order has its error instead.
+func (c Client) OpenBatchOrders(orders []t.Order) ([]t.Order, []error) {
+	results := make([]t.Order, len(orders))
+	errs := make([]error, len(orders))
+
+	var indexes []int
+	for i, o := range orders {
+		if batchParams(o) == nil {
+			errs[i] = exerr.New("OpenBatchOrders", 0, o.Type+" is not supported", exerr.ErrInvalidOrder)
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	for start := 0; start < len(indexes); start += maxBatchOrders {
+		end := start + maxBatchOrders
+		if end > len(indexes) {
+			end = len(indexes)
+		}
+		c.openBatch(orders, indexes[start:end], results, errs)
+	}
+	return results, errs
+}
+
+// openBatch opens the orders of the indexes in a request, and sets their results
+func (c Client) openBatch(orders []t.Order, indexes []int, results []t.Order, errs []error) {
+	params := make([]map[string]string, 0, len(indexes))
+	for _, i := range indexes {
+		params = append(params, batchParams(orders[i]))
+	}
+	batch, _ := json.Marshal(params)
+	batchOrders := url.QueryEscape(string(batch))
+
+	var payload, url strings.Builder
+
+	fmt.Fprintf(&payload, "timestamp=%d&recvWindow=%d&batchOrders=%s", c.clock.Now13(), c.recvWindow, batchOrders)
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/batchOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
+	data, err := c.send(http.MethodPost, url.String())
+	if err == nil {
+		if r := gjson.ParseBytes(data); r.Get("code").Int() < 0 {
+			err = b.NewError("OpenBatchOrders", r)
+		}
+	}
+	if err != nil {
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return
+	}
+
+	rs := gjson.ParseBytes(data).Array()
+	for n, i := range indexes {
+		o := orders[i]
+		if n >= len(rs) {
+			errs[i] = exerr.New("OpenBatchOrders", 0, o.ID+" has no result", exerr.ErrUnknownStatus)
+			continue
+		}
+		if rs[n].Get("code").Int() < 0 {
+			errs[i] = b.NewError("OpenBatchOrders", rs[n])
+			continue
+		}
+		o.RefID = rs[n].Get("orderId").String()
+		o.Status = rs[n].Get("status").String()
+		o.OpenTime = rs[n].Get("updateTime").Int()
+		results[i] = o
+	}
+}
+
+// batchParams returns the parameters of the limit or the stop order in a batch, like OpenLimitOrder and OpenStopOrder.
+// It returns nil when the type of the order is not supported.
+func batchParams(o t.Order) map[string]string {
+	params := map[string]string{
+		"symbol":           o.Symbol,
+		"newClientOrderId": o.ID,
+		"side":             o.Side,
+		"type":             o.Type,
+	}
+	if o.PosSide != "" {
+		params["positionSide"] = o.PosSide
+	}
+	if reduceOnly(o) != "" {
+		params["reduceOnly"] = "true"
+	}
+
+	switch o.Type {
+	case t.OrderTypeLimit:
+		params["quantity"] = fmt.Sprintf("%f", o.Qty)
+		params["price"] = fmt.Sprintf("%f", o.OpenPrice)
+		params["timeInForce"] = "GTC"
+	case t.OrderTypeFSL, t.OrderTypeFTP:
+		params["stopPrice"] = fmt.Sprintf("%f", o.StopPrice)
+		params["quantity"] = fmt.Sprintf("%f", o.Qty)
+		params["price"] = fmt.Sprintf("%f", o.OpenPrice)
+		params["timeInForce"] = "GTC"
+	case t.OrderTypeFSLM, t.OrderTypeFTPM:
+		params["stopPrice"] = fmt.Sprintf("%f", o.StopPrice)
+		if o.ClosePosition {
+			params["closePosition"] = "true"
+			delete(params, "reduceOnly")
+		} else {
+			params["quantity"] = fmt.Sprintf("%f", o.Qty)
+		}
+	case t.OrderTypeFTSM:
+		params["callbackRate"] = fmt.Sprintf("%.1f", o.CallbackRate)
+		params["quantity"] = fmt.Sprintf("%f", o.Qty)
+		if o.StopPrice > 0 {
+			params["activationPrice"] = fmt.Sprintf("%f", o.StopPrice)
+		}
+	default:
+		return nil
+	}
+	return params
+}
+
+// CancelBatchOrders cancels the orders of a symbol, up to 10 orders per request.
+// The results are in the order of the orders, a failed order has its error instead.
+func (c Client) CancelBatchOrders(orders []t.Order) ([]t.Order, []error) {
+	results := make([]t.Order, len(orders))
+	errs := make([]error, len(orders))
+	for start := 0; start < len(orders); start += maxBatchCancels {
+		end := start + maxBatchCancels
+		if end > len(orders) {
+			end = len(orders)
+		}
+		c.cancelBatch(orders[start:end], results[start:end], errs[start:end])
+	}
+	return results, errs
+}
+
+// cancelBatch cancels the orders in a request, and sets their results
+func (c Client) cancelBatch(orders []t.Order, results []t.Order, errs []error) {
+	ids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
+	list, _ := json.Marshal(ids)
+	idList := url.QueryEscape(string(list))
+
+	var payload, url strings.Builder
+
+	b.BuildBaseQS(&payload, orders[0].Symbol, c.clock.Now13(), c.recvWindow)
+	fmt.Fprintf(&payload, "&origClientOrderIdList=%s", idList)
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/batchOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
+	data, err := c.send(http.MethodDelete, url.String())
+	if err == nil {
+		if r := gjson.ParseBytes(data); r.Get("code").Int() < 0 {
+			err = b.NewError("CancelBatchOrders", r)
+		}
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return
+	}
+
+	rs := gjson.ParseBytes(data).Array()
+	for i, o := range orders {
+		if i >= len(rs) {
+			errs[i] = exerr.New("CancelBatchOrders", 0, o.ID+" has no result", exerr.ErrUnknownStatus)
+			continue
+		}
+		if rs[i].Get("code").Int() < 0 {
+			errs[i] = b.NewError("CancelBatchOrders", rs[i])
+			continue
+		}
+		o.Status = rs[i].Get("status").String()
+		o.UpdateTime = rs[i].Get("updateTime").Int()
+		results[i] = o
+	}
+}
+
+// v2URL returns the base URL of the v2 endpoints
+func (c Client) v2URL() string {
+	return c.rootURL + v2Path
+}
+
+// GetPositions returns the positions of the symbol, one per position side
+func (c Client) GetPositions(symbol string) ([]t.Position, error) {
+	var payload, url strings.Builder
+
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/positionRisk?%s&signature=%s", c.v2URL(), payload.String(), signature)
+	data, err := c.send(http.MethodGet, url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	rs := gjson.ParseBytes(data)
+
+	if rs.Get("code").Int() < 0 {
+		return nil, b.NewError("GetPositions", rs)
+	}
+
+	var positions []t.Position
+	for _, r := range rs.Array() {
+		marginType := t.MarginTypeCrossed
+		if strings.EqualFold(r.Get("marginType").String(), t.MarginTypeIsolated) {
+			marginType = t.MarginTypeIsolated
+		}
+		positions = append(positions, t.Position{
+			Symbol:           r.Get("symbol").String(),
+			PosSide:          r.Get("positionSide").String(),
+			Qty:              r.Get("positionAmt").Float(),
+			EntryPrice:       r.Get("entryPrice").Float(),
+			MarkPrice:        r.Get("markPrice").Float(),
+			UnrealizedPnL:    r.Get("unRealizedProfit").Float(),
+			LiquidationPrice: r.Get("liquidationPrice").Float(),
+			Leverage:         r.Get("leverage").Int(),
+			MarginType:       marginType,
+		})
+	}
+	return positions, nil
+}
+
+// GetFuturesBalance returns the wallet and the available balances of the asset, e.g. USDT
+func (c Client) GetFuturesBalance(asset string) (*t.FuturesBalance, error) {
+	var payload, url strings.Builder
+
+	fmt.Fprintf(&payload, "timestamp=%d&recvWindow=%d", c.clock.Now13(), c.recvWindow)
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/balance?%s&signature=%s", c.v2URL(), payload.String(), signature)
+	data, err := c.send(http.MethodGet, url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	rs := gjson.ParseBytes(data)
+
+	if rs.Get("code").Int() < 0 {
+		return nil, b.NewError("GetFuturesBalance", rs)
+	}
+
+	for _, r := range rs.Array() {
+		if r.Get("asset").String() != asset {
+			continue
+		}
+		return &t.FuturesBalance{
+			Asset:         asset,
+			Wallet:        r.Get("balance").Float(),
+			Available:     r.Get("availableBalance").Float(),
+			UnrealizedPnL: r.Get("crossUnPnl").Float(),
+		}, nil
+	}
+	return nil, exerr.New("GetFuturesBalance", 0, fmt.Sprintf("no %s balance", asset), exerr.ErrInsufficientBalance)
+}
+
+// SetLeverage changes the initial leverage of the symbol
+func (c Client) SetLeverage(symbol string, leverage int64) error {
+	var payload strings.Builder
+
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
+	fmt.Fprintf(&payload, "&leverage=%d", leverage)
+
+	return c.post("SetLeverage", "/leverage", payload.String())
+}
+
+// SetMarginType changes the margin type of the symbol, ISOLATED or CROSSED
+func (c Client) SetMarginType(symbol string, marginType string) error {
+	var payload strings.Builder
+
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
+	fmt.Fprintf(&payload, "&marginType=%s", marginType)
+
+	return c.post("SetMarginType", "/marginType", payload.String())
+}
+
+// GetPositionMode returns the position mode of the account, HEDGE or ONE_WAY
+func (c Client) GetPositionMode() (string, error) {
+	var payload, url strings.Builder
+
+	fmt.Fprintf(&payload, "timestamp=%d&recvWindow=%d", c.clock.Now13(), c.recvWindow)
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/positionSide/dual?%s&signature=%s", c.baseURL, payload.String(), signature)
+	data, err := c.send(http.MethodGet, url.String())
+	if err != nil {
+		return "", err
+	}
+
+	r := gjson.ParseBytes(data)
+
+	if r.Get("code").Int() < 0 {
+		return "", b.NewError("GetPositionMode", r)
+	}
+
+	if r.Get("dualSidePosition").Bool() {
+		return t.PositionModeHedge, nil
+	}
+	return t.PositionModeOneWay, nil
+}
+
+// SetPositionMode changes the position mode of the account, HEDGE (dual-side) or ONE_WAY.
+// It is rejected while there are open orders or positions.
+func (c Client) SetPositionMode(mode string) error {
+	var payload strings.Builder
+
+	fmt.Fprintf(&payload, "timestamp=%d&recvWindow=%d&dualSidePosition=%t", c.clock.Now13(), c.recvWindow, mode == t.PositionModeHedge)
+
+	return c.post("SetPositionMode", "/positionSide/dual", payload.String())
+}
+
+// post calls the signed endpoint, the errors of no change are not errors
+func (c Client) post(name string, path string, payload string) error {
+	var url strings.Builder
+
+	signature := b.Sign(payload, c.secretKey)
+
+	fmt.Fprintf(&url, "%s%s?%s&signature=%s", c.baseURL, path, payload, signature)
+	data, err := c.send(http.MethodPost, url.String())
+	if err != nil {
+		return err
+	}
+
+	r := gjson.ParseBytes(data)
+
+	// -4046: No need to change margin type, -4059: No need to change position side
+	if code := r.Get("code").Int(); code < 0 && code != -4046 && code != -4059 {
+		return b.NewError(name, r)
+	}
+	return nil
+}
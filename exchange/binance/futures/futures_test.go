@@ -1,7 +1,20 @@
 package futures
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/exerr"
+	"github.com/tonkla/autotp/types"
 )
 
 const fsymbol = "BNBUSDT"
@@ -29,3 +42,316 @@ func TestGetHistoricalPrices(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// batchRequests counts the requests of batchOrders
+var batchRequests int32
+
+// newTestClient returns the client of a test server, which keeps the query of the last order
+func newTestClient() (Client, *httptest.Server, func() url.Values) {
+	var mu sync.Mutex
+	var last url.Values
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fapi/v1/batchOrders", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&batchRequests, 1)
+		var results []string
+		if r.Method == http.MethodDelete {
+			var ids []string
+			json.Unmarshal([]byte(r.URL.Query().Get("origClientOrderIdList")), &ids)
+			for _, id := range ids {
+				if id == "unknown" {
+					results = append(results, `{"code":-2011,"msg":"Unknown order sent."}`)
+					continue
+				}
+				results = append(results, `{"orderId":1,"clientOrderId":"`+id+`","status":"CANCELED","updateTime":1634090760000}`)
+			}
+		} else {
+			var params []map[string]string
+			json.Unmarshal([]byte(r.URL.Query().Get("batchOrders")), &params)
+			for i, p := range params {
+				if p["price"] == "1.000000" {
+					results = append(results, `{"code":-2019,"msg":"Margin is insufficient."}`)
+					continue
+				}
+				results = append(results, fmt.Sprintf(`{"orderId":%d,"clientOrderId":"%s","status":"NEW","updateTime":1634090700000}`,
+					100+i, p["newClientOrderId"]))
+			}
+		}
+		w.Write([]byte("[" + strings.Join(results, ",") + "]"))
+	})
+	mux.HandleFunc("/fapi/v1/order", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		last = r.URL.Query()
+		mu.Unlock()
+		if r.URL.Query().Get("type") == types.OrderTypeMarket {
+			w.Write([]byte(`{"orderId":22542179,"clientOrderId":"` + r.URL.Query().Get("newClientOrderId") +
+				`","status":"FILLED","avgPrice":"480.25","executedQty":"0.50","updateTime":1634090700000}`))
+			return
+		}
+		w.Write([]byte(`{"orderId":22542180,"status":"NEW","updateTime":1634090700000}`))
+	})
+	mux.HandleFunc("/fapi/v2/positionRisk", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"symbol":"BNBUSDT","positionAmt":"0.50","entryPrice":"480.1","markPrice":"482.3",
+"unRealizedProfit":"1.10","liquidationPrice":"401.5","leverage":"5","marginType":"isolated","positionSide":"LONG"},
+{"symbol":"BNBUSDT","positionAmt":"0","entryPrice":"0","markPrice":"482.3","unRealizedProfit":"0",
+"liquidationPrice":"0","leverage":"5","marginType":"isolated","positionSide":"SHORT"}]`))
+	})
+	mux.HandleFunc("/fapi/v2/balance", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"asset":"BNB","balance":"0","availableBalance":"0","crossUnPnl":"0"},
+{"asset":"USDT","balance":"1000.5","availableBalance":"880.25","crossUnPnl":"1.1"}]`))
+	})
+	mux.HandleFunc("/fapi/v1/marginType", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		last = r.URL.Query()
+		mu.Unlock()
+		w.Write([]byte(`{"code":-4046,"msg":"No need to change margin type."}`))
+	})
+	mux.HandleFunc("/fapi/v1/leverage", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		last = r.URL.Query()
+		mu.Unlock()
+		if r.URL.Query().Get("leverage") == "200" {
+			w.Write([]byte(`{"code":-4028,"msg":"Leverage 200 is not valid"}`))
+			return
+		}
+		w.Write([]byte(`{"leverage":5,"maxNotionalValue":"1000000","symbol":"BNBUSDT"}`))
+	})
+	mux.HandleFunc("/fapi/v1/positionSide/dual", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		last = r.URL.Query()
+		mu.Unlock()
+		w.Write([]byte(`{"dualSidePosition":true}`))
+	})
+	mux.HandleFunc("/fapi/v1/userTrades", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"orderId":22542179,"price":"480.2","qty":"0.2","commission":"0.0384"},
+{"orderId":22542179,"price":"480.3","qty":"0.3","commission":"0.0576"}]`))
+	})
+	srv := httptest.NewServer(mux)
+
+	c := NewFuturesClient("key", "secret").WithEndpoints(srv.URL+"/", "")
+	c.clock = clock.NewSim(1634090700000)
+	return c, srv, func() url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+}
+
+func TestOpenMarketOrder(t *testing.T) {
+	c, srv, last := newTestClient()
+	defer srv.Close()
+
+	o, err := c.OpenMarketOrder(types.Order{ID: "order1", Symbol: fsymbol, Side: types.OrderSideBuy,
+		PosSide: types.OrderPosSideLong, Type: types.OrderTypeMarket, Qty: 0.5})
+	if err != nil || o.RefID != "22542179" || o.Status != types.OrderStatusFilled || o.OpenPrice != 480.25 ||
+		o.Qty != 0.5 || o.OpenTime != 1634090700000 || o.Commission != 0.096 {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if q := last(); q.Get("newOrderRespType") != "RESULT" || q.Get("reduceOnly") != "" {
+		t.Errorf("Unexpected query: %v", q)
+	}
+}
+
+func TestCloseOrder(t *testing.T) {
+	c, srv, last := newTestClient()
+	defer srv.Close()
+
+	open := types.Order{ID: "order1", Symbol: fsymbol, Side: types.OrderSideBuy, PosSide: types.OrderPosSideBoth,
+		Type: types.OrderTypeLimit, Status: types.OrderStatusNew, Qty: 0.5, OpenPrice: 480}
+	if _, err := c.CloseOrder(open); !errors.Is(err, exerr.ErrInvalidOrder) {
+		t.Errorf("Expect the order not filled, got %v", err)
+	}
+
+	open.Status = types.OrderStatusFilled
+	open.CloseOrderID = "order9"
+	o, err := c.CloseOrder(open)
+	if err != nil || o.ID != "order9" || o.Side != types.OrderSideSell || o.Type != types.OrderTypeMarket ||
+		o.OpenOrderID != "order1" || o.OpenPrice != 480.25 {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if q := last(); q.Get("newClientOrderId") != "order9" || q.Get("side") != types.OrderSideSell ||
+		q.Get("reduceOnly") != "true" || q.Get("quantity") != "0.500000" {
+		t.Errorf("Unexpected query: %v", q)
+	}
+
+	// The hedge mode rejects reduceOnly, the position side is enough
+	open.PosSide = types.OrderPosSideLong
+	if _, err = c.CloseOrder(open); err != nil {
+		t.Fatal(err)
+	}
+	if q := last(); q.Get("positionSide") != types.OrderPosSideLong || q.Has("reduceOnly") {
+		t.Errorf("Unexpected query: %v", q)
+	}
+}
+
+func TestOpenStopMarketOrder(t *testing.T) {
+	c, srv, last := newTestClient()
+	defer srv.Close()
+
+	o, err := c.OpenStopOrder(types.Order{ID: "order2", Symbol: fsymbol, Side: types.OrderSideSell,
+		PosSide: types.OrderPosSideLong, Type: types.OrderTypeFSLM, StopPrice: 470, ClosePosition: true})
+	if err != nil || o.RefID != "22542180" {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if q := last(); q.Get("closePosition") != "true" || q.Has("quantity") || q.Has("price") || q.Get("stopPrice") != "470.000000" {
+		t.Errorf("Unexpected query: %v", q)
+	}
+
+	_, err = c.OpenStopOrder(types.Order{ID: "order3", Symbol: fsymbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeFTPM, Qty: 0.5, StopPrice: 490, ReduceOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := last(); q.Get("type") != types.OrderTypeFTPM || q.Get("quantity") != "0.500000" || q.Get("reduceOnly") != "true" {
+		t.Errorf("Unexpected query: %v", q)
+	}
+}
+
+func TestOpenTrailingStopOrder(t *testing.T) {
+	c, srv, last := newTestClient()
+	defer srv.Close()
+
+	o, err := c.OpenStopOrder(types.Order{ID: "order4", Symbol: fsymbol, Side: types.OrderSideSell,
+		PosSide: types.OrderPosSideLong, Type: types.OrderTypeFTSM, Qty: 0.5, StopPrice: 490, CallbackRate: 1.2})
+	if err != nil || o.RefID != "22542180" || o.Status != types.OrderStatusNew {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if q := last(); q.Get("type") != types.OrderTypeFTSM || q.Get("callbackRate") != "1.2" ||
+		q.Get("activationPrice") != "490.000000" || q.Get("quantity") != "0.500000" || q.Has("stopPrice") || q.Has("price") {
+		t.Errorf("Unexpected query: %v", q)
+	}
+
+	// The trailing stop without an activation price is activated at the current price
+	if _, err = c.OpenStopOrder(types.Order{ID: "order5", Symbol: fsymbol, Side: types.OrderSideBuy,
+		PosSide: types.OrderPosSideShort, Type: types.OrderTypeFTSM, Qty: 0.5, CallbackRate: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if q := last(); q.Get("callbackRate") != "0.5" || q.Has("activationPrice") {
+		t.Errorf("Unexpected query: %v", q)
+	}
+}
+
+func TestGetPositions(t *testing.T) {
+	c, srv, _ := newTestClient()
+	defer srv.Close()
+
+	positions, err := c.GetPositions(fsymbol)
+	if err != nil || len(positions) != 2 {
+		t.Fatalf("Unexpected positions: %+v, %v", positions, err)
+	}
+	if p := positions[0]; p.PosSide != types.OrderPosSideLong || p.Qty != 0.5 || p.EntryPrice != 480.1 ||
+		p.UnrealizedPnL != 1.1 || p.LiquidationPrice != 401.5 || p.Leverage != 5 || p.MarginType != types.MarginTypeIsolated {
+		t.Errorf("Unexpected position: %+v", p)
+	}
+
+	balance, err := c.GetFuturesBalance("USDT")
+	if err != nil || balance.Wallet != 1000.5 || balance.Available != 880.25 || balance.UnrealizedPnL != 1.1 {
+		t.Errorf("Unexpected balance: %+v, %v", balance, err)
+	}
+	if _, err = c.GetFuturesBalance("BUSD"); !errors.Is(err, exerr.ErrInsufficientBalance) {
+		t.Errorf("Expect no BUSD balance, got %v", err)
+	}
+}
+
+func TestSetupAccount(t *testing.T) {
+	c, srv, last := newTestClient()
+	defer srv.Close()
+
+	// No change is not an error
+	if err := c.SetMarginType(fsymbol, types.MarginTypeIsolated); err != nil {
+		t.Error(err)
+	}
+	if q := last(); q.Get("marginType") != types.MarginTypeIsolated || q.Get("symbol") != fsymbol {
+		t.Errorf("Unexpected query: %v", q)
+	}
+	if err := c.SetLeverage(fsymbol, 5); err != nil {
+		t.Error(err)
+	}
+	if err := c.SetLeverage(fsymbol, 200); err == nil || err.Error() != "SetLeverage: Leverage 200 is not valid" {
+		t.Errorf("Expect an error, got %v", err)
+	}
+
+	if mode, err := c.GetPositionMode(); err != nil || mode != types.PositionModeHedge {
+		t.Errorf("Expect the HEDGE mode, got %s, %v", mode, err)
+	}
+	if err := c.SetPositionMode(types.PositionModeOneWay); err != nil {
+		t.Error(err)
+	}
+	if q := last(); q.Get("dualSidePosition") != "false" {
+		t.Errorf("Unexpected query: %v", q)
+	}
+}
+
+func TestWithEndpoints(t *testing.T) {
+	c := NewFuturesClient("key", "secret").WithEndpoints(TestnetBaseURL, TestnetWsURL)
+	if c.baseURL != TestnetBaseURL || c.v2URL() != "https://testnet.binancefuture.com/fapi/v2" {
+		t.Errorf("Expect the testnet REST endpoints, got %s", c.baseURL)
+	}
+	if c.wsURL != TestnetWsURL {
+		t.Errorf("Expect the testnet WebSocket endpoint, got %s", c.wsURL)
+	}
+
+	// An empty URL is unchanged
+	c = c.WithEndpoints("", "ws://localhost:8080/")
+	if c.baseURL != TestnetBaseURL || c.wsURL != "ws://localhost:8080" {
+		t.Errorf("Unexpected endpoints: %s, %s", c.baseURL, c.wsURL)
+	}
+
+	// A custom base URL is the root of the host, with or without the path of the v1 endpoints
+	for _, u := range []string{"http://localhost:8080", "http://localhost:8080/", "http://localhost:8080/fapi/v1"} {
+		c = c.WithEndpoints(u, "")
+		if c.baseURL != "http://localhost:8080/fapi/v1" || c.v2URL() != "http://localhost:8080/fapi/v2" {
+			t.Errorf("Unexpected endpoints of %s: %s, %s", u, c.baseURL, c.v2URL())
+		}
+	}
+}
+
+func TestOpenBatchOrders(t *testing.T) {
+	c, srv, _ := newTestClient()
+	defer srv.Close()
+
+	var orders []types.Order
+	for i := 0; i < 6; i++ {
+		orders = append(orders, types.Order{ID: fmt.Sprintf("order%d", i), Symbol: fsymbol, Side: types.OrderSideBuy,
+			PosSide: types.OrderPosSideLong, Type: types.OrderTypeLimit, Qty: 0.5, OpenPrice: 470 - float64(i)})
+	}
+	orders[2].OpenPrice = 1
+	orders[3].Type = types.OrderTypeMarket
+
+	atomic.StoreInt32(&batchRequests, 0)
+	results, errs := c.OpenBatchOrders(orders)
+	if n := atomic.LoadInt32(&batchRequests); n != 1 {
+		t.Errorf("Expect the 5 supported orders in a request, got %d requests", n)
+	}
+	if errs[0] != nil || results[0].RefID != "100" || results[0].Status != types.OrderStatusNew || results[0].ID != "order0" {
+		t.Errorf("Unexpected result: %+v, %v", results[0], errs[0])
+	}
+	if errs[2] == nil || errs[3] == nil || results[2].RefID != "" {
+		t.Errorf("Expect the errors of the rejected and the unsupported orders, got %v, %v", errs[2], errs[3])
+	}
+	if errs[5] != nil || results[5].RefID != "104" {
+		t.Errorf("Unexpected result: %+v, %v", results[5], errs[5])
+	}
+
+	// Up to 5 orders per request
+	orders[2].OpenPrice, orders[3].Type = 468, types.OrderTypeLimit
+	atomic.StoreInt32(&batchRequests, 0)
+	c.OpenBatchOrders(orders)
+	if n := atomic.LoadInt32(&batchRequests); n != 2 {
+		t.Errorf("Expect 2 requests, got %d", n)
+	}
+}
+
+func TestCancelBatchOrders(t *testing.T) {
+	c, srv, _ := newTestClient()
+	defer srv.Close()
+
+	orders := []types.Order{{ID: "order1", Symbol: fsymbol}, {ID: "unknown", Symbol: fsymbol}}
+	results, errs := c.CancelBatchOrders(orders)
+	if errs[0] != nil || results[0].Status != types.OrderStatusCanceled || results[0].UpdateTime != 1634090760000 {
+		t.Errorf("Unexpected result: %+v, %v", results[0], errs[0])
+	}
+	if errs[1] == nil {
+		t.Error("Expect an error of the unknown order")
+	}
+}
@@ -0,0 +1,207 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+const (
+	TickerSourceAggTrade   = "aggTrade"
+	TickerSourceBookTicker = "bookTicker"
+)
+
+// MarketStream is the market data stream of a symbol: the tickers, and the k-lines of the timeframes
+type MarketStream struct {
+	// WsURL is the base URL of the WebSocket streams, e.g. wss://stream.binance.com:9443
+	WsURL  string
+	Symbol string
+	// TickerSource is aggTrade (the trade price) or bookTicker (the mid price of the best bid and ask)
+	TickerSource string
+
+	mu         sync.Mutex
+	timeframes []string
+	conn       *websocket.Conn
+	nextID     int64
+}
+
+// NewMarketStream returns the market data stream of the symbol
+func NewMarketStream(wsURL string, symbol string, tickerSource string) *MarketStream {
+	if tickerSource == "" {
+		tickerSource = TickerSourceAggTrade
+	}
+	return &MarketStream{WsURL: wsURL, Symbol: symbol, TickerSource: tickerSource}
+}
+
+func (s *MarketStream) streamName(suffix string) string {
+	return fmt.Sprintf("%s@%s", strings.ToLower(s.Symbol), suffix)
+}
+
+// Subscribe adds the k-lines of the timeframe to the stream, on the current connection and the next ones
+func (s *MarketStream) Subscribe(timeframe string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tf := range s.timeframes {
+		if tf == timeframe {
+			return nil
+		}
+	}
+	s.timeframes = append(s.timeframes, timeframe)
+	if s.conn == nil {
+		return nil
+	}
+	s.nextID++
+	return s.conn.WriteJSON(map[string]interface{}{
+		"method": "SUBSCRIBE",
+		"params": []string{s.streamName("kline_" + timeframe)},
+		"id":     s.nextID,
+	})
+}
+
+func (s *MarketStream) url() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	streams := []string{s.streamName(s.TickerSource)}
+	for _, tf := range s.timeframes {
+		streams = append(streams, s.streamName("kline_"+tf))
+	}
+	return fmt.Sprintf("%s/stream?streams=%s", s.WsURL, strings.Join(streams, "/"))
+}
+
+func (s *MarketStream) setConn(conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+}
+
+// Stream runs the stream like Run, and subscribes to the k-lines of the timeframes received from subscribe
+func (s *MarketStream) Stream(ctx context.Context, subscribe <-chan string, onTicker func(t.Ticker),
+	onKline func(string, t.HistoricalPrice), connected func(bool)) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case tf := <-subscribe:
+				if err := s.Subscribe(tf); err != nil {
+					h.Log("MarketStream", err)
+				}
+			}
+		}
+	}()
+	s.Run(ctx, onTicker, onKline, connected)
+}
+
+// Run subscribes to the market data until the context is done, and reconnects when it is disconnected.
+// The connected callback is called with true after every connection, and with false after every disconnection.
+func (s *MarketStream) Run(ctx context.Context, onTicker func(t.Ticker), onKline func(string, t.HistoricalPrice),
+	connected func(bool)) {
+	delay := time.Second
+	for {
+		start := time.Now()
+		err := s.run(ctx, onTicker, onKline, connected)
+		if ctx.Err() != nil {
+			return
+		}
+		h.Log("MarketStream", err)
+
+		if time.Since(start) > maxReconnectDelay {
+			delay = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (s *MarketStream) run(ctx context.Context, onTicker func(t.Ticker), onKline func(string, t.HistoricalPrice),
+	connected func(bool)) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.setConn(conn)
+	defer s.setConn(nil)
+	connected(true)
+	defer connected(false)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		r := gjson.ParseBytes(data).Get("data")
+		switch {
+		case r.Get("e").String() == "kline":
+			tf, p := ParseKline(r)
+			onKline(tf, p)
+		case r.Get("e").String() == "aggTrade":
+			onTicker(ParseAggTrade(r))
+		case r.Get("b").Exists() && r.Get("a").Exists():
+			onTicker(ParseBookTicker(r))
+		}
+	}
+}
+
+// ParseAggTrade returns the ticker of the aggTrade event
+func ParseAggTrade(r gjson.Result) t.Ticker {
+	return t.Ticker{
+		Exchange: t.ExcBinance,
+		Symbol:   r.Get("s").String(),
+		Price:    r.Get("p").Float(),
+		Qty:      r.Get("q").Float(),
+		Time:     r.Get("T").Int(),
+	}
+}
+
+// ParseBookTicker returns the ticker of the bookTicker event at the mid price of the best bid and ask,
+// the spot event has no time, then it is the time received
+func ParseBookTicker(r gjson.Result) t.Ticker {
+	tm := r.Get("E").Int()
+	if tm == 0 {
+		tm = h.Now13()
+	}
+	return t.Ticker{
+		Exchange: t.ExcBinance,
+		Symbol:   r.Get("s").String(),
+		Price:    (r.Get("b").Float() + r.Get("a").Float()) / 2,
+		Time:     tm,
+	}
+}
+
+// ParseKline returns the timeframe and the k-line of the kline event
+func ParseKline(r gjson.Result) (string, t.HistoricalPrice) {
+	k := r.Get("k")
+	return k.Get("i").String(), t.HistoricalPrice{
+		Symbol: k.Get("s").String(),
+		Time:   k.Get("t").Int(),
+		Open:   k.Get("o").Float(),
+		High:   k.Get("h").Float(),
+		Low:    k.Get("l").Float(),
+		Close:  k.Get("c").Float(),
+	}
+}
@@ -0,0 +1,120 @@
+package binance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+	"github.com/tonkla/autotp/types"
+)
+
+const aggTrade = `{"e":"aggTrade","E":1634083200100,"s":"BNBUSDT","a":26129,"p":"450.10","q":"2.5","f":100,"l":105,
+"T":1634083200099,"m":true}`
+
+const bookTicker = `{"u":400900217,"s":"BNBUSDT","b":"450.00","B":"31.21","a":"450.20","A":"40.66"}`
+
+const kline = `{"e":"kline","E":1634083200100,"s":"BNBUSDT","k":{"t":1634083200000,"T":1634083259999,"s":"BNBUSDT",
+"i":"1m","o":"450.00","c":"450.10","h":"451.00","l":"449.50","v":"100","x":false}}`
+
+func TestParseAggTrade(t *testing.T) {
+	tk := ParseAggTrade(gjson.Parse(aggTrade))
+	if tk.Symbol != "BNBUSDT" || tk.Price != 450.1 || tk.Qty != 2.5 || tk.Time != 1634083200099 {
+		t.Errorf("Unexpected ticker: %+v", tk)
+	}
+}
+
+func TestParseBookTicker(t *testing.T) {
+	tk := ParseBookTicker(gjson.Parse(bookTicker))
+	if tk.Symbol != "BNBUSDT" || tk.Price != 450.1 || tk.Time == 0 {
+		t.Errorf("Unexpected ticker: %+v", tk)
+	}
+}
+
+func TestParseKline(t *testing.T) {
+	tf, p := ParseKline(gjson.Parse(kline))
+	if tf != "1m" || p.Time != 1634083200000 || p.Open != 450 || p.High != 451 || p.Low != 449.5 || p.Close != 450.1 {
+		t.Errorf("Unexpected k-line: %s %+v", tf, p)
+	}
+}
+
+func TestMarketStream(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	streams := make(chan string, 10)
+	subscriptions := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streams <- r.URL.Query().Get("streams")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage,
+			[]byte(`{"stream":"bnbusdt@aggTrade","data":`+strings.ReplaceAll(aggTrade, "\n", "")+`}`))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			r := gjson.ParseBytes(data)
+			subscriptions <- r.Get("method").String() + " " + r.Get("params.0").String()
+			conn.WriteMessage(websocket.TextMessage,
+				[]byte(`{"stream":"bnbusdt@kline_1m","data":`+strings.ReplaceAll(kline, "\n", "")+`}`))
+		}
+	}))
+	defer srv.Close()
+
+	ms := NewMarketStream("ws"+strings.TrimPrefix(srv.URL, "http"), "BNBUSDT", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tickers := make(chan types.Ticker, 10)
+	klines := make(chan string, 10)
+	connections := make(chan bool, 10)
+	go ms.Run(ctx, func(tk types.Ticker) { tickers <- tk },
+		func(tf string, p types.HistoricalPrice) { klines <- tf }, func(c bool) { connections <- c })
+
+	if s := <-streams; s != "bnbusdt@aggTrade" {
+		t.Errorf("Unexpected streams: %s", s)
+	}
+	select {
+	case tk := <-tickers:
+		if tk.Price != 450.1 {
+			t.Errorf("Unexpected ticker: %+v", tk)
+		}
+	case <-ctx.Done():
+		t.Fatal("Expect a ticker")
+	}
+	if c := <-connections; !c {
+		t.Fatal("Expect a connection")
+	}
+
+	// The timeframe is subscribed on the current connection
+	if err := ms.Subscribe("1m"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-subscriptions:
+		if s != "SUBSCRIBE bnbusdt@kline_1m" {
+			t.Errorf("Unexpected subscription: %s", s)
+		}
+	case <-ctx.Done():
+		t.Fatal("Expect a subscription")
+	}
+	select {
+	case tf := <-klines:
+		if tf != "1m" {
+			t.Errorf("Unexpected timeframe: %s", tf)
+		}
+	case <-ctx.Done():
+		t.Fatal("Expect a k-line")
+	}
+
+	// The timeframes are subscribed on the next connections by the URL
+	if u := ms.url(); !strings.HasSuffix(u, "/stream?streams=bnbusdt@aggTrade/bnbusdt@kline_1m") {
+		t.Errorf("Unexpected URL: %s", u)
+	}
+}
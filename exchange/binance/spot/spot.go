@@ -1,30 +1,99 @@
 package spot
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/tidwall/gjson"
 
+	"github.com/tonkla/autotp/clock"
 	b "github.com/tonkla/autotp/exchange/binance"
+	"github.com/tonkla/autotp/exchange/exerr"
 	h "github.com/tonkla/autotp/helper"
 	t "github.com/tonkla/autotp/types"
 )
 
+// The REST and the WebSocket endpoints of the Spot production and testnet
+const (
+	BaseURL        = "https://api.binance.com/api/v3"
+	WsURL          = "wss://stream.binance.com:9443"
+	TestnetBaseURL = "https://testnet.binance.vision/api/v3"
+	TestnetWsURL   = "wss://testnet.binance.vision"
+)
+
 type Client struct {
 	baseURL   string
+	wsURL     string
 	apiKey    string
 	secretKey string
+	clock     clock.Clock
+	// recvWindow is the milliseconds that a signed request is valid for
+	recvWindow int64
+	// ctx cancels the signed requests, they are not canceled when it is nil
+	ctx context.Context
 }
 
 // NewSpotClient returns Binance Spot client
 func NewSpotClient(apiKey string, secretKey string) Client {
 	return Client{
-		baseURL:   "https://api.binance.com/api/v3",
-		apiKey:    apiKey,
-		secretKey: secretKey,
+		baseURL:    BaseURL,
+		wsURL:      WsURL,
+		apiKey:     apiKey,
+		secretKey:  secretKey,
+		clock:      b.NewServerClock(BaseURL),
+		recvWindow: b.DefaultRecvWindow,
+	}
+}
+
+// WithRecvWindow returns the client that signs the requests with the recvWindow in milliseconds
+func (c Client) WithRecvWindow(recvWindow int64) Client {
+	if recvWindow > 0 {
+		c.recvWindow = recvWindow
+	}
+	return c
+}
+
+// WithContext returns the client that its signed requests are canceled by the context
+func (c Client) WithContext(ctx context.Context) Client {
+	c.ctx = ctx
+	return c
+}
+
+// WithEndpoints returns the client of the REST and the WebSocket base URLs, e.g. of the testnet.
+// An empty URL is unchanged.
+func (c Client) WithEndpoints(baseURL string, wsURL string) Client {
+	if baseURL != "" {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+		c.clock = b.NewServerClock(c.baseURL)
+	}
+	if wsURL != "" {
+		c.wsURL = strings.TrimSuffix(wsURL, "/")
 	}
+	return c
+}
+
+// send calls the signed URL with the method
+func (c Client) send(method string, url string) ([]byte, error) {
+	return b.Send(c.ctx, method, url, c.apiKey, c.secretKey, c.clock)
+}
+
+// StreamOrders pushes the order updates of the user data stream, until the context is done
+func (c Client) StreamOrders(ctx context.Context, onUpdate func(t.OrderUpdate), connected func(bool)) {
+	us := b.UserStream{
+		ListenKeyURL: c.baseURL + "/userDataStream",
+		WsURL:        c.wsURL + "/ws",
+		ApiKey:       c.apiKey,
+		Futures:      false,
+	}
+	us.Run(ctx, onUpdate, connected)
+}
+
+// StreamMarket pushes the tickers and the k-lines of the symbol from the market data stream, until the context is done
+func (c Client) StreamMarket(ctx context.Context, symbol string, tickerSource string, subscribe <-chan string,
+	onTicker func(t.Ticker), onKline func(string, t.HistoricalPrice), connected func(bool)) {
+	b.NewMarketStream(c.wsURL, symbol, tickerSource).Stream(ctx, subscribe, onTicker, onKline, connected)
 }
 
 // Public APIs -----------------------------------------------------------------
@@ -39,11 +108,21 @@ func (c Client) GetOrderBook(symbol string, limit int) *t.OrderBook {
 	return b.GetOrderBook(c.baseURL, symbol, limit)
 }
 
+// GetSymbolInfo returns the tick size, the lot size and the other filters of the symbol
+func (c Client) GetSymbolInfo(symbol string) (*t.SymbolInfo, error) {
+	return b.GetSymbolInfo(c.baseURL, symbol)
+}
+
 // GetHistoricalPrices returns historical prices in a format of k-lines/candlesticks
 func (c Client) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
 	return b.GetHistoricalPrices(c.baseURL, symbol, timeframe, limit)
 }
 
+// GetHistoricalPricesRange returns historical prices between the start time and the end time
+func (c Client) GetHistoricalPricesRange(symbol string, timeframe string, startTime int64, endTime int64) ([]t.HistoricalPrice, error) {
+	return b.GetHistoricalPricesRange(c.baseURL, symbol, timeframe, startTime, endTime, 1000)
+}
+
 // Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
 func (c Client) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
 	return c.GetHistoricalPrices(symbol, "1w", limit)
@@ -75,12 +154,12 @@ func (c Client) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalP
 func (c Client) CountOpenOrders(symbol string) (int, error) {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/openOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return 0, err
 	}
@@ -88,8 +167,7 @@ func (c Client) CountOpenOrders(symbol string) (int, error) {
 	rs := gjson.ParseBytes(data)
 
 	if rs.Get("code").Int() < 0 {
-		h.Log("CountOpenOrders", rs)
-		return 0, errors.New("error")
+		return 0, b.NewError("CountOpenOrders", rs)
 	}
 
 	return len(rs.Array()), nil
@@ -99,12 +177,12 @@ func (c Client) CountOpenOrders(symbol string) (int, error) {
 func (c Client) GetOpenOrders(symbol string) []t.Order {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/openOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil
 	}
@@ -139,7 +217,7 @@ func (c Client) GetOpenOrders(symbol string) []t.Order {
 func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime int) ([]t.TradeOrder, error) {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	if limit > 0 {
 		fmt.Fprintf(&payload, "&limit=%d", limit)
@@ -156,7 +234,7 @@ func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime in
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/myTrades?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -165,7 +243,7 @@ func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime in
 
 	if rs.Get("code").Int() < 0 {
 		h.Log("GetTradeOrders", rs)
-		return nil, errors.New(rs.Get("msg").String())
+		return nil, b.NewError("GetTradeList", rs)
 	}
 
 	var orders []t.TradeOrder
@@ -191,7 +269,7 @@ func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime in
 func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime int) []t.Order {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	if limit > 0 {
 		fmt.Fprintf(&payload, "&limit=%d", limit)
@@ -208,7 +286,7 @@ func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime in
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/allOrders?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil
 	}
@@ -243,14 +321,14 @@ func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime in
 func (c Client) GetCommission(symbol string, orderRefID string) *float64 {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, symbol)
+	b.BuildBaseQS(&payload, symbol, c.clock.Now13(), c.recvWindow)
 
 	fmt.Fprintf(&payload, "&limit=10")
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/myTrades?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.GetH(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodGet, url.String())
 	if err != nil {
 		return nil
 	}
@@ -280,14 +358,14 @@ func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
 
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, o.Symbol)
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
 	fmt.Fprintf(&payload, "&newClientOrderId=%s&side=%s&type=%s&quantity=%f&price=%f&timeInForce=GTC",
 		o.ID, o.Side, o.Type, o.Qty, o.OpenPrice)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.Post(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodPost, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -295,7 +373,7 @@ func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
 	r := gjson.ParseBytes(data)
 
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("OpenLimitOrder: %s", r.Get("msg").String())
+		return nil, b.NewError("OpenLimitOrder", r)
 	}
 
 	status := r.Get("status").String()
@@ -316,7 +394,7 @@ func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
 
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, o.Symbol)
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
 	fmt.Fprintf(&payload,
 		"&newClientOrderId=%s&side=%s&type=%s&quantity=%f&price=%f&stopPrice=%f&timeInForce=GTC",
 		o.ID, o.Side, o.Type, o.Qty, o.OpenPrice, o.StopPrice)
@@ -324,7 +402,7 @@ func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.Post(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodPost, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -332,14 +410,69 @@ func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
 	r := gjson.ParseBytes(data)
 
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("OpenStopOrder: %s", r.Get("msg").String())
+		return nil, b.NewError("OpenStopOrder", r)
 	}
 
+	// The stop order is acknowledged without its status, it is NEW until it has been triggered
+	o.Status = t.OrderStatusNew
 	o.RefID = r.Get("orderId").String()
 	o.OpenTime = r.Get("transactTime").Int()
 	return &o, nil
 }
 
+// OpenOCOOrder opens an OCO order list of the TP (LIMIT_MAKER) and the SL (STOP_LOSS_LIMIT) orders,
+// they close the same quantity and cancel each other. The list is identified by the ListID of the orders.
+func (c Client) OpenOCOOrder(tp t.Order, sl t.Order) ([]t.Order, error) {
+	if tp.Side != sl.Side || tp.Qty != sl.Qty {
+		return nil, exerr.New("OpenOCOOrder", 0, "The orders are not of the same side and quantity", exerr.ErrInvalidOrder)
+	}
+
+	// A SELL list has the TP above the market, a BUY list has the SL above
+	tpLeg, slLeg := "above", "below"
+	if tp.Side == t.OrderSideBuy {
+		tpLeg, slLeg = "below", "above"
+	}
+
+	var payload, url strings.Builder
+
+	b.BuildBaseQS(&payload, tp.Symbol, c.clock.Now13(), c.recvWindow)
+	fmt.Fprintf(&payload, "&listClientOrderId=%s&side=%s&quantity=%f", tp.ListID, tp.Side, tp.Qty)
+	fmt.Fprintf(&payload, "&%[1]sType=LIMIT_MAKER&%[1]sClientOrderId=%[2]s&%[1]sPrice=%[3]f", tpLeg, tp.ID, tp.OpenPrice)
+	fmt.Fprintf(&payload, "&%[1]sType=STOP_LOSS_LIMIT&%[1]sClientOrderId=%[2]s&%[1]sStopPrice=%[3]f&%[1]sPrice=%[4]f"+
+		"&%[1]sTimeInForce=GTC", slLeg, sl.ID, sl.StopPrice, sl.OpenPrice)
+
+	signature := b.Sign(payload.String(), c.secretKey)
+
+	fmt.Fprintf(&url, "%s/orderList/oco?%s&signature=%s", c.baseURL, payload.String(), signature)
+	data, err := c.send(http.MethodPost, url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	r := gjson.ParseBytes(data)
+
+	if r.Get("code").Int() < 0 {
+		return nil, b.NewError("OpenOCOOrder", r)
+	}
+
+	orders := []t.Order{tp, sl}
+	for i := range orders {
+		o := &orders[i]
+		for _, report := range r.Get("orderReports").Array() {
+			if report.Get("clientOrderId").String() != o.ID {
+				continue
+			}
+			o.RefID = report.Get("orderId").String()
+			o.Status = report.Get("status").String()
+			o.OpenTime = r.Get("transactionTime").Int()
+		}
+		if o.RefID == "" {
+			return nil, exerr.New("OpenOCOOrder", 0, o.ID+" has not been reported", exerr.ErrUnknownStatus)
+		}
+	}
+	return orders, nil
+}
+
 // OpenMarketOrder opens a market order on the Binance Spot
 func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
 	if o.Type != t.OrderTypeMarket {
@@ -348,14 +481,14 @@ func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
 
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, o.Symbol)
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
 	fmt.Fprintf(&payload, "&newClientOrderId=%s&side=%s&type=%s&quantity=%f",
 		o.ID, o.Side, o.Type, o.Qty)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.Post(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodPost, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -363,18 +496,25 @@ func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
 	r := gjson.ParseBytes(data)
 
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("OpenMarketOrder: %s", r.Get("msg").String())
+		return nil, b.NewError("OpenMarketOrder", r)
 	}
 
 	o.RefID = r.Get("orderId").String()
 	o.OpenTime = r.Get("transactTime").Int()
 	o.Status = r.Get("status").String()
 
-	fills := r.Get("fills").Array()
-	if len(fills) > 0 {
-		o.OpenPrice = fills[0].Get("price").Float()
-		o.Qty = fills[0].Get("qty").Float()
-		o.Commission = fills[0].Get("commission").Float()
+	// The order may be filled at several prices, its price is the average price weighted by the quantities
+	var qty, quoteQty, commission float64
+	for _, f := range r.Get("fills").Array() {
+		q := f.Get("qty").Float()
+		qty += q
+		quoteQty += q * f.Get("price").Float()
+		commission += f.Get("commission").Float()
+	}
+	if qty > 0 {
+		o.OpenPrice = quoteQty / qty
+		o.Qty = qty
+		o.Commission = commission
 	}
 
 	return &o, nil
@@ -384,13 +524,13 @@ func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
 func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
 	var payload, url strings.Builder
 
-	b.BuildBaseQS(&payload, o.Symbol)
+	b.BuildBaseQS(&payload, o.Symbol, c.clock.Now13(), c.recvWindow)
 	fmt.Fprintf(&payload, "&orderId=%s&origClientOrderId=%s", o.RefID, o.ID)
 
 	signature := b.Sign(payload.String(), c.secretKey)
 
 	fmt.Fprintf(&url, "%s/order?%s&signature=%s", c.baseURL, payload.String(), signature)
-	data, err := h.Delete(url.String(), b.NewHeader(c.apiKey))
+	data, err := c.send(http.MethodDelete, url.String())
 	if err != nil {
 		return nil, err
 	}
@@ -398,7 +538,7 @@ func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
 	r := gjson.ParseBytes(data)
 
 	if r.Get("code").Int() < 0 {
-		return nil, fmt.Errorf("CancelOrder: %s", r.Get("msg").String())
+		return nil, b.NewError("CancelOrder", r)
 	}
 
 	status := r.Get("status").String()
@@ -406,20 +546,41 @@ func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
 		return nil, nil
 	}
 	o.Status = status
-	o.UpdateTime = h.Now13()
+	o.UpdateTime = c.clock.Now13()
 	return &o, nil
 }
 
 // GetOrder returns the order by its IDs
 func (c Client) GetOrder(o t.Order) (*t.Order, error) {
 	cc := b.Client{
-		BaseURL:   c.baseURL,
-		ApiKey:    c.apiKey,
-		SecretKey: c.secretKey,
+		Ctx:        c.ctx,
+		BaseURL:    c.baseURL,
+		ApiKey:     c.apiKey,
+		SecretKey:  c.secretKey,
+		Clock:      c.clock,
+		RecvWindow: c.recvWindow,
 	}
 	return b.GetOrder(cc, o)
 }
 
+// CloseOrder closes a filled order with an opposite market order, and returns the market order.
+// The market order is of the close order ID of the order, when it has been given.
 func (c Client) CloseOrder(o t.Order) (*t.Order, error) {
-	return nil, nil
+	if o.Status != t.OrderStatusFilled {
+		return nil, exerr.New("CloseOrder", 0, "the order is not filled", exerr.ErrInvalidOrder)
+	}
+	id := o.CloseOrderID
+	if id == "" {
+		id = h.GenID()
+	}
+	return c.OpenMarketOrder(t.Order{
+		ID:          id,
+		BotID:       o.BotID,
+		Exchange:    o.Exchange,
+		Symbol:      o.Symbol,
+		Side:        h.Reverse(o.Side),
+		Type:        t.OrderTypeMarket,
+		Qty:         o.Qty,
+		OpenOrderID: o.ID,
+	})
 }
@@ -1,7 +1,15 @@
 package spot
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/exerr"
+	"github.com/tonkla/autotp/types"
 )
 
 const ssymbol = "BNBBUSD"
@@ -29,3 +37,87 @@ func TestSpotGetHistoricalPrices(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestOpenOCOOrder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/orderList/oco" || q.Get("listClientOrderId") != "list" ||
+			q.Get("aboveType") != "LIMIT_MAKER" || q.Get("aboveClientOrderId") != "tp" || q.Get("abovePrice") != "110.000000" ||
+			q.Get("belowType") != "STOP_LOSS_LIMIT" || q.Get("belowClientOrderId") != "sl" || q.Get("belowStopPrice") != "95.000000" {
+			w.Write([]byte(`{"code":-1102,"msg":"Mandatory parameter was not sent."}`))
+			return
+		}
+		w.Write([]byte(`{"orderListId":1,"listClientOrderId":"list","transactionTime":1634090700000,"orderReports":[
+{"clientOrderId":"sl","orderId":11,"status":"NEW","type":"STOP_LOSS_LIMIT"},
+{"clientOrderId":"tp","orderId":12,"status":"NEW","type":"LIMIT_MAKER"}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewSpotClient("key", "secret").WithEndpoints(srv.URL, "")
+	c.clock = clock.NewSim(1634090700000)
+
+	tp := types.Order{ID: "tp", ListID: "list", Symbol: ssymbol, Side: types.OrderSideSell, Type: types.OrderTypeTP,
+		Qty: 1, OpenPrice: 110, StopPrice: 109}
+	sl := types.Order{ID: "sl", ListID: "list", Symbol: ssymbol, Side: types.OrderSideSell, Type: types.OrderTypeSL,
+		Qty: 1, OpenPrice: 94, StopPrice: 95}
+	orders, err := c.OpenOCOOrder(tp, sl)
+	if err != nil || len(orders) != 2 {
+		t.Fatal(err, orders)
+	}
+	if orders[0].RefID != "12" || orders[1].RefID != "11" || orders[1].Status != types.OrderStatusNew ||
+		orders[0].OpenTime != 1634090700000 {
+		t.Errorf("Unexpected orders: %+v", orders)
+	}
+}
+
+func TestOpenMarketOrder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"symbol":"BNBBUSD","orderId":28,"clientOrderId":"order1","transactTime":1634090700000,
+"status":"FILLED","type":"MARKET","side":"BUY","fills":[
+{"price":"480","qty":"0.5","commission":"0.0005","commissionAsset":"BNB"},
+{"price":"482","qty":"1.5","commission":"0.0015","commissionAsset":"BNB"}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewSpotClient("key", "secret").WithEndpoints(srv.URL, "")
+	c.clock = clock.NewSim(1634090700000)
+
+	// Every fill is counted, the price is weighted by the quantities of the fills
+	o, err := c.OpenMarketOrder(types.Order{ID: "order1", Symbol: ssymbol, Side: types.OrderSideBuy,
+		Type: types.OrderTypeMarket, Qty: 2})
+	if err != nil || o.RefID != "28" || o.Status != types.OrderStatusFilled || o.Qty != 2 || o.OpenPrice != 481.5 ||
+		o.Commission != 0.002 {
+		t.Errorf("Unexpected order: %+v, %v", o, err)
+	}
+}
+
+func TestCloseOrder(t *testing.T) {
+	var q url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q = r.URL.Query()
+		w.Write([]byte(`{"symbol":"BNBBUSD","orderId":29,"clientOrderId":"order9","transactTime":1634090760000,
+"status":"FILLED","type":"MARKET","side":"SELL","fills":[{"price":"490","qty":"2","commission":"0.49","commissionAsset":"BUSD"}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewSpotClient("key", "secret").WithEndpoints(srv.URL, "")
+	c.clock = clock.NewSim(1634090760000)
+
+	open := types.Order{ID: "order1", Symbol: ssymbol, Side: types.OrderSideBuy, Type: types.OrderTypeLimit,
+		Status: types.OrderStatusNew, Qty: 2, OpenPrice: 480}
+	if _, err := c.CloseOrder(open); !errors.Is(err, exerr.ErrInvalidOrder) {
+		t.Errorf("Expect the order not filled, got %v", err)
+	}
+
+	open.Status = types.OrderStatusFilled
+	open.CloseOrderID = "order9"
+	o, err := c.CloseOrder(open)
+	if err != nil || o.ID != "order9" || o.RefID != "29" || o.Side != types.OrderSideSell ||
+		o.Type != types.OrderTypeMarket || o.OpenOrderID != "order1" || o.OpenPrice != 490 {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if q.Get("newClientOrderId") != "order9" || q.Get("side") != types.OrderSideSell || q.Get("type") != types.OrderTypeMarket ||
+		q.Get("quantity") != "2.000000" {
+		t.Errorf("Unexpected query: %v", q)
+	}
+}
@@ -0,0 +1,183 @@
+package binance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+const (
+	keepAliveInterval = 30 * time.Minute
+	maxReconnectDelay = time.Minute
+)
+
+// UserStream is the user data stream, which pushes the order updates of the account
+type UserStream struct {
+	// ListenKeyURL is the REST endpoint of the listen key, e.g. https://api.binance.com/api/v3/userDataStream
+	ListenKeyURL string
+	// WsURL is the base URL of the WebSocket streams, e.g. wss://stream.binance.com:9443/ws
+	WsURL  string
+	ApiKey string
+	// Futures keeps the listen key alive without the listenKey parameter
+	Futures bool
+}
+
+// CreateListenKey starts a user data stream, and returns its listen key
+func (s UserStream) CreateListenKey(ctx context.Context) (string, error) {
+	data, err := h.Post(ctx, s.ListenKeyURL, NewHeader(s.ApiKey))
+	if err != nil {
+		return "", err
+	}
+	r := gjson.ParseBytes(data)
+	if r.Get("code").Int() < 0 {
+		return "", NewError("CreateListenKey", r)
+	}
+	key := r.Get("listenKey").String()
+	if key == "" {
+		return "", errors.New("CreateListenKey: no listen key")
+	}
+	return key, nil
+}
+
+// KeepAlive extends the validity of the listen key for 60 minutes
+func (s UserStream) KeepAlive(ctx context.Context, listenKey string) error {
+	url := s.ListenKeyURL
+	if !s.Futures {
+		url = fmt.Sprintf("%s?listenKey=%s", url, listenKey)
+	}
+	data, err := h.Put(ctx, url, NewHeader(s.ApiKey))
+	if err != nil {
+		return err
+	}
+	r := gjson.ParseBytes(data)
+	if r.Get("code").Int() < 0 {
+		return NewError("KeepAlive", r)
+	}
+	return nil
+}
+
+// Run subscribes to the user data stream until the context is done, and reconnects when it is disconnected.
+// The connected callback is called with true after every connection, and with false after every disconnection.
+func (s UserStream) Run(ctx context.Context, onUpdate func(t.OrderUpdate), connected func(bool)) {
+	delay := time.Second
+	for {
+		start := time.Now()
+		err := s.run(ctx, onUpdate, connected)
+		if ctx.Err() != nil {
+			return
+		}
+		h.Log("UserStream", err)
+
+		if time.Since(start) > maxReconnectDelay {
+			delay = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (s UserStream) run(ctx context.Context, onUpdate func(t.OrderUpdate), connected func(bool)) error {
+	listenKey, err := s.CreateListenKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, fmt.Sprintf("%s/%s", s.WsURL, listenKey), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	connected(true)
+	defer connected(false)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.KeepAlive(ctx, listenKey); err != nil {
+					h.Log("UserStream", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		r := gjson.ParseBytes(data)
+		switch r.Get("e").String() {
+		case "executionReport":
+			onUpdate(ParseExecutionReport(r))
+		case "ORDER_TRADE_UPDATE":
+			onUpdate(ParseOrderTradeUpdate(r))
+		case "listenKeyExpired":
+			return errors.New("listen key expired")
+		}
+	}
+}
+
+// ParseExecutionReport returns the order update of the spot executionReport event
+func ParseExecutionReport(r gjson.Result) t.OrderUpdate {
+	id := r.Get("c").String()
+	// A cancellation has its own client order ID, the original one is in C
+	if orig := r.Get("C").String(); orig != "" {
+		id = orig
+	}
+	return t.OrderUpdate{
+		Symbol:          r.Get("s").String(),
+		ID:              id,
+		RefID:           r.Get("i").String(),
+		Side:            r.Get("S").String(),
+		Status:          r.Get("X").String(),
+		LastQty:         r.Get("l").Float(),
+		LastPrice:       r.Get("L").Float(),
+		Commission:      r.Get("n").Float(),
+		CommissionAsset: r.Get("N").String(),
+		UpdateTime:      r.Get("T").Int(),
+		IsMaker:         r.Get("m").Bool(),
+	}
+}
+
+// ParseOrderTradeUpdate returns the order update of the futures ORDER_TRADE_UPDATE event
+func ParseOrderTradeUpdate(r gjson.Result) t.OrderUpdate {
+	o := r.Get("o")
+	return t.OrderUpdate{
+		Symbol:          o.Get("s").String(),
+		ID:              o.Get("c").String(),
+		RefID:           o.Get("i").String(),
+		Side:            o.Get("S").String(),
+		PosSide:         o.Get("ps").String(),
+		Status:          o.Get("X").String(),
+		LastQty:         o.Get("l").Float(),
+		LastPrice:       o.Get("L").Float(),
+		Commission:      o.Get("n").Float(),
+		CommissionAsset: o.Get("N").String(),
+		RealizedPnL:     o.Get("rp").Float(),
+		UpdateTime:      o.Get("T").Int(),
+		IsMaker:         o.Get("m").Bool(),
+	}
+}
@@ -0,0 +1,108 @@
+package binance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+	"github.com/tonkla/autotp/types"
+)
+
+const executionReport = `{"e":"executionReport","E":1634083200100,"s":"BNBUSDT","c":"cancel1","S":"BUY","o":"LIMIT",
+"q":"1.00000000","p":"450.00000000","x":"CANCELED","X":"CANCELED","i":4293153,"l":"0.00000000","z":"0.00000000",
+"L":"0.00000000","n":"0","N":null,"T":1634083200099,"m":false,"C":"order1"}`
+
+const orderTradeUpdate = `{"e":"ORDER_TRADE_UPDATE","E":1634083200100,"T":1634083200099,"o":{"s":"BNBUSDT",
+"c":"order2","S":"SELL","o":"LIMIT","q":"2","p":"460","x":"TRADE","X":"FILLED","i":8886774,"l":"2","z":"2",
+"L":"460","N":"USDT","n":"0.184","T":1634083200099,"m":true,"ps":"SHORT","rp":"1.5"}}`
+
+func TestParseExecutionReport(t *testing.T) {
+	u := ParseExecutionReport(gjson.Parse(executionReport))
+	if u.ID != "order1" || u.RefID != "4293153" || u.Status != "CANCELED" || u.Side != "BUY" || u.UpdateTime != 1634083200099 {
+		t.Errorf("Unexpected update: %+v", u)
+	}
+}
+
+func TestParseOrderTradeUpdate(t *testing.T) {
+	u := ParseOrderTradeUpdate(gjson.Parse(orderTradeUpdate))
+	if u.ID != "order2" || u.RefID != "8886774" || u.Status != "FILLED" || u.PosSide != "SHORT" ||
+		u.LastQty != 2 || u.LastPrice != 460 || u.Commission != 0.184 || u.RealizedPnL != 1.5 || !u.IsMaker {
+		t.Errorf("Unexpected update: %+v", u)
+	}
+}
+
+func TestUserStream(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var mu sync.Mutex
+	keys := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/userDataStream", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MBX-APIKEY") != "key" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		keys++
+		mu.Unlock()
+		w.Write([]byte(`{"listenKey":"lk"}`))
+	})
+	mux.HandleFunc("/ws/lk", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(strings.ReplaceAll(executionReport, "\n", "")))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"e":"listenKeyExpired","E":1634083200200}`))
+		time.Sleep(100 * time.Millisecond)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	us := UserStream{
+		ListenKeyURL: srv.URL + "/api/v3/userDataStream",
+		WsURL:        "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws",
+		ApiKey:       "key",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updates := make(chan types.OrderUpdate, 10)
+	connections := make(chan bool, 10)
+	go us.Run(ctx, func(u types.OrderUpdate) { updates <- u }, func(c bool) { connections <- c })
+
+	select {
+	case u := <-updates:
+		if u.ID != "order1" || u.Status != types.OrderStatusCanceled {
+			t.Errorf("Unexpected update: %+v", u)
+		}
+	case <-ctx.Done():
+		t.Fatal("Expect an order update")
+	}
+
+	// The expired listen key disconnects the stream, then a new listen key is created
+	expected := []bool{true, false, true}
+	for i, e := range expected {
+		select {
+		case c := <-connections:
+			if c != e {
+				t.Fatalf("Connection %d: Expect: %v, Got: %v", i, e, c)
+			}
+		case <-ctx.Done():
+			t.Fatal("Expect a reconnection")
+		}
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if keys < 2 {
+		t.Errorf("Expect a new listen key after the expiration, got %d keys", keys)
+	}
+}
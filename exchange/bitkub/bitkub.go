@@ -1,83 +1,582 @@
 package bitkub
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/tidwall/gjson"
 
-	"github.com/tonkla/autotp/helper"
-	"github.com/tonkla/autotp/types"
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/exerr"
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
 )
 
 type Client struct {
-	baseURL string
+	baseURL   string
+	apiKey    string
+	secretKey string
+	clock     clock.Clock
 }
 
-func NewClient() Client {
+// NewClient returns Bitkub client
+func NewClient(apiKey string, secretKey string) Client {
 	return Client{
-		baseURL: "https://api.bitkub.com/api",
+		baseURL:   "https://api.bitkub.com",
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		clock:     clock.Real{},
 	}
 }
 
+// WithBaseURL returns the client of the base URL, e.g. of a mock server. An empty URL is unchanged.
+func (c Client) WithBaseURL(baseURL string) Client {
+	if baseURL != "" {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	return c
+}
+
+// Sign signs a payload with a Bitkub API secret key
+func Sign(payload string, secretKey string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// pair returns the symbol of the v3 APIs, e.g. THB_BNB becomes bnb_thb
+func pair(symbol string) string {
+	s := strings.Split(strings.ToLower(symbol), "_")
+	if len(s) == 2 && s[0] == "thb" {
+		return s[1] + "_" + s[0]
+	}
+	return strings.ToLower(symbol)
+}
+
+// toMillis returns the time in milliseconds, Bitkub answers the time in seconds or in milliseconds
+func toMillis(ts int64) int64 {
+	if ts < 1e12 {
+		return ts * 1000
+	}
+	return ts
+}
+
+// toStatus returns the order status of the Bitkub order
+func toStatus(r gjson.Result) string {
+	switch r.Get("status").String() {
+	case "filled":
+		return t.OrderStatusFilled
+	case "cancelled":
+		return t.OrderStatusCanceled
+	}
+	if r.Get("partial_filled").Bool() {
+		return t.OrderStatusPartial
+	}
+	return t.OrderStatusNew
+}
+
+// resolutions are the timeframes of the TradingView history
+var resolutions = map[string]string{
+	"1m":  "1",
+	"5m":  "5",
+	"15m": "15",
+	"1h":  "60",
+	"4h":  "240",
+	"1d":  "1D",
+	"1w":  "1W",
+}
+
+// errorKind returns the kind of the error code, https://github.com/bitkub/bitkub-official-api-docs#error-codes
+func errorKind(code int64) error {
+	switch code {
+	case 2, 3, 4, 5, 6, 9, 52:
+		return exerr.ErrUnauthorized
+	case 7, 8:
+		return exerr.ErrTimestamp
+	case 10, 11, 12, 13, 14, 15, 22:
+		return exerr.ErrInvalidOrder
+	case 17, 18:
+		return exerr.ErrInsufficientBalance
+	case 21, 24:
+		return exerr.ErrOrderNotFound
+	case 90:
+		return exerr.ErrUnknownStatus
+	}
+	return exerr.ErrRejected
+}
+
+// call calls the signed API of the operation, and returns the result when there is no error.
+// The signature is of the timestamp, the method, the path with the query string, and the body.
+func (c Client) call(op string, method string, path string, query url.Values, payload map[string]interface{}) (gjson.Result, error) {
+	if len(query) > 0 {
+		path = path + "?" + query.Encode()
+	}
+	var body string
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return gjson.Result{}, fmt.Errorf("%s: %w", op, err)
+		}
+		body = string(b)
+	}
+
+	ts := strconv.FormatInt(c.clock.Now13(), 10)
+	var header http.Header = make(map[string][]string)
+	header.Set("Accept", "application/json")
+	header.Set("X-BTK-APIKEY", c.apiKey)
+	header.Set("X-BTK-TIMESTAMP", ts)
+	header.Set("X-BTK-SIGN", Sign(ts+method+path+body, c.secretKey))
+
+	var data []byte
+	var err error
+	if method == http.MethodPost {
+		data, err = h.PostD(context.Background(), c.baseURL+path, header, body)
+	} else {
+		data, err = h.GetH(context.Background(), c.baseURL+path, header)
+	}
+	if err != nil {
+		return gjson.Result{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	r := gjson.ParseBytes(data)
+	if code := r.Get("error").Int(); code != 0 {
+		return gjson.Result{}, exerr.New(op, code, fmt.Sprintf("error %d", code), errorKind(code))
+	}
+	return r.Get("result"), nil
+}
+
+// Public APIs -----------------------------------------------------------------
+
 // GetTicker returns the latest ticker
-func (c Client) GetTicker(symbol string) *types.Ticker {
+func (c Client) GetTicker(symbol string) *t.Ticker {
 	var url strings.Builder
-	fmt.Fprintf(&url, "%s/market/ticker?sym=%s", c.baseURL, symbol)
-	data, err := helper.Get(url.String())
+	fmt.Fprintf(&url, "%s/api/market/ticker?sym=%s", c.baseURL, symbol)
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
 		return nil
 	}
 	ticker := gjson.ParseBytes(data).Get(strings.ToUpper(symbol))
-	return &types.Ticker{
-		Symbol: symbol,
-		Price:  ticker.Get("last").Float(),
+	if !ticker.Exists() {
+		return nil
+	}
+	return &t.Ticker{
+		Exchange: t.ExcBitkub,
+		Symbol:   symbol,
+		Price:    ticker.Get("last").Float(),
+		Time:     c.clock.Now13(),
 	}
-}
-
-// GetHistoricalPrices returns historical prices in a format of Klines/Candlesticks
-func (c Client) GetHistoricalPrices(symbol string, interval int) []types.HistoricalPrice {
-	return []types.HistoricalPrice{}
 }
 
 // GetOrderBook returns an order book (market depth)
-func (c Client) GetOrderBook(symbol string, limit int) types.OrderBook {
+func (c Client) GetOrderBook(symbol string, limit int) *t.OrderBook {
 	var url strings.Builder
-	fmt.Fprintf(&url, "%s/market/depth?sym=%s&lmt=%d", c.baseURL, symbol, limit)
-	data, err := helper.Get(url.String())
+	fmt.Fprintf(&url, "%s/api/market/depth?sym=%s&lmt=%d", c.baseURL, symbol, limit)
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
-		log.Println(err)
-		return types.OrderBook{}
+		h.Log("GetOrderBook", err)
+		return nil
 	}
 
 	orders := gjson.ParseBytes(data)
 
-	var bids []types.ExOrder
+	var bids []t.ExOrder
 	for _, bid := range orders.Get("bids").Array() {
 		b := bid.Array()
-		ord := types.ExOrder{
-			Side:  types.OrderSideBuy,
-			Price: b[0].Float(),
-			Qty:   b[1].Float(),
+		ord := t.ExOrder{
+			Symbol: symbol,
+			Side:   t.OrderSideBuy,
+			Price:  b[0].Float(),
+			Qty:    b[1].Float(),
 		}
 		bids = append(bids, ord)
 	}
 
-	var asks []types.ExOrder
+	var asks []t.ExOrder
 	for _, ask := range orders.Get("asks").Array() {
 		a := ask.Array()
-		ord := types.ExOrder{
-			Side:  types.OrderSideSell,
-			Price: a[0].Float(),
-			Qty:   a[1].Float(),
+		ord := t.ExOrder{
+			Symbol: symbol,
+			Side:   t.OrderSideSell,
+			Price:  a[0].Float(),
+			Qty:    a[1].Float(),
 		}
 		asks = append(asks, ord)
 	}
 
-	return types.OrderBook{
+	return &t.OrderBook{
 		Symbol: symbol,
 		Bids:   bids,
 		Asks:   asks,
 	}
 }
+
+// GetHistoricalPrices returns historical prices in a format of k-lines/candlesticks
+func (c Client) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
+	dur := h.TfDuration(timeframe)
+	if dur == 0 || limit <= 0 {
+		return nil
+	}
+	now := c.clock.Now13()
+	prices, err := c.GetHistoricalPricesRange(symbol, timeframe, h.TfOpenTime(timeframe, now)-int64(limit-1)*dur, now)
+	if err != nil {
+		h.Log("GetHistoricalPrices", err)
+		return nil
+	}
+	if len(prices) > limit {
+		prices = prices[len(prices)-limit:]
+	}
+	return prices
+}
+
+// GetHistoricalPricesRange returns historical prices between the start time and the end time,
+// from the TradingView history
+func (c Client) GetHistoricalPricesRange(symbol string, timeframe string, startTime int64, endTime int64) ([]t.HistoricalPrice, error) {
+	res, ok := resolutions[timeframe]
+	if !ok {
+		return nil, fmt.Errorf("GetHistoricalPricesRange: invalid timeframe %s", timeframe)
+	}
+	var url strings.Builder
+	fmt.Fprintf(&url, "%s/tradingview/history?symbol=%s&resolution=%s&from=%d&to=%d",
+		c.baseURL, strings.ToUpper(pair(symbol)), res, startTime/1000, endTime/1000)
+	data, err := h.Get(context.Background(), url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	r := gjson.ParseBytes(data)
+	if s := r.Get("s").String(); s == "no_data" {
+		return nil, nil
+	} else if s != "ok" {
+		return nil, fmt.Errorf("GetHistoricalPricesRange: %s", s)
+	}
+
+	ts, o, hi, lo, cl := r.Get("t").Array(), r.Get("o").Array(), r.Get("h").Array(), r.Get("l").Array(), r.Get("c").Array()
+	var prices []t.HistoricalPrice
+	for i := range ts {
+		if i >= len(o) || i >= len(hi) || i >= len(lo) || i >= len(cl) {
+			break
+		}
+		prices = append(prices, t.HistoricalPrice{
+			Symbol: symbol,
+			Time:   ts[i].Int() * 1000,
+			Open:   o[i].Float(),
+			High:   hi[i].Float(),
+			Low:    lo[i].Float(),
+			Close:  cl[i].Float(),
+		})
+	}
+	return prices, nil
+}
+
+// Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1w", limit)
+}
+
+// Get1dHistoricalPrices returns '1d' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1dHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1d", limit)
+}
+
+// Get4hHistoricalPrices returns '4h' historical prices in a format of k-lines/candlesticks
+func (c Client) Get4hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "4h", limit)
+}
+
+// Get1hHistoricalPrices returns '1h' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1h", limit)
+}
+
+// Get15mHistoricalPrices returns '15m' historical prices in a format of k-lines/candlesticks
+func (c Client) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "15m", limit)
+}
+
+// Private APIs ----------------------------------------------------------------
+
+// GetBalances returns the available and the reserved balances of the assets
+func (c Client) GetBalances() ([]t.Balance, error) {
+	r, err := c.call("GetBalances", http.MethodPost, "/api/v3/market/balances", nil, map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	var balances []t.Balance
+	r.ForEach(func(asset, b gjson.Result) bool {
+		balances = append(balances, t.Balance{
+			Asset:  asset.String(),
+			Free:   b.Get("available").Float(),
+			Locked: b.Get("reserved").Float(),
+		})
+		return true
+	})
+	return balances, nil
+}
+
+// CountOpenOrders returns a number of open orders
+func (c Client) CountOpenOrders(symbol string) (int, error) {
+	r, err := c.call("CountOpenOrders", http.MethodGet, "/api/v3/market/my-open-orders", url.Values{"sym": {pair(symbol)}}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(r.Array()), nil
+}
+
+// GetOpenOrders returns open orders
+func (c Client) GetOpenOrders(symbol string) []t.Order {
+	rs, err := c.call("GetOpenOrders", http.MethodGet, "/api/v3/market/my-open-orders", url.Values{"sym": {pair(symbol)}}, nil)
+	if err != nil {
+		h.Log(err)
+		return nil
+	}
+
+	var orders []t.Order
+	for _, r := range rs.Array() {
+		side := strings.ToUpper(r.Get("side").String())
+		price := r.Get("rate").Float()
+		// The amount of a bid is in THB
+		qty := r.Get("amount").Float()
+		if side == t.OrderSideBuy && price > 0 {
+			qty = qty / price
+		}
+		order := t.Order{
+			Symbol:    symbol,
+			ID:        r.Get("client_id").String(),
+			RefID:     r.Get("id").String(),
+			Side:      side,
+			Status:    t.OrderStatusNew,
+			Type:      strings.ToUpper(r.Get("type").String()),
+			Qty:       qty,
+			OpenPrice: price,
+			OpenTime:  toMillis(r.Get("ts").Int()),
+		}
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+// getOrderHistory returns the matched orders, the latest first
+func (c Client) getOrderHistory(op string, symbol string, limit int, startTime int, endTime int) (gjson.Result, error) {
+	q := url.Values{"sym": {pair(symbol)}}
+	if limit > 0 {
+		q.Set("lmt", strconv.Itoa(limit))
+	} else {
+		q.Set("lmt", "10")
+	}
+	// The time range is in seconds
+	if startTime > 0 {
+		q.Set("start", strconv.Itoa(startTime/1000))
+	}
+	if endTime > 0 {
+		q.Set("end", strconv.Itoa(endTime/1000))
+	}
+	return c.call(op, http.MethodGet, "/api/v3/market/my-order-history", q, nil)
+}
+
+// GetTradeList returns trades list for a specified symbol
+func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime int) ([]t.TradeOrder, error) {
+	rs, err := c.getOrderHistory("GetTradeList", symbol, limit, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []t.TradeOrder
+	for _, r := range rs.Array() {
+		price := r.Get("rate").Float()
+		qty := r.Get("amount").Float()
+		order := t.TradeOrder{
+			Symbol:          symbol,
+			RefID:           r.Get("order_id").String(),
+			Price:           price,
+			Qty:             qty,
+			QuoteQty:        price * qty,
+			Commission:      r.Get("fee").Float(),
+			CommissionAsset: "THB",
+			Time:            toMillis(r.Get("ts").Int()),
+			IsBuyer:         r.Get("side").String() == "buy",
+			IsMaker:         r.Get("is_maker").Bool(),
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// GetAllOrders returns the filled orders, Bitkub keeps the history of the matched orders only
+func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime int) []t.Order {
+	rs, err := c.getOrderHistory("GetAllOrders", symbol, limit, startTime, endTime)
+	if err != nil {
+		h.Log(err)
+		return nil
+	}
+
+	var orders []t.Order
+	for _, r := range rs.Array() {
+		order := t.Order{
+			Symbol:     symbol,
+			ID:         r.Get("client_id").String(),
+			RefID:      r.Get("order_id").String(),
+			Side:       strings.ToUpper(r.Get("side").String()),
+			Status:     t.OrderStatusFilled,
+			Type:       strings.ToUpper(r.Get("type").String()),
+			Qty:        r.Get("amount").Float(),
+			OpenPrice:  r.Get("rate").Float(),
+			Commission: r.Get("fee").Float(),
+			OpenTime:   toMillis(r.Get("ts").Int()),
+			UpdateTime: toMillis(r.Get("order_closed_at").Int()),
+		}
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+// getOrderInfo returns the order info, the side of the order is required
+func (c Client) getOrderInfo(op string, symbol string, refID string, side string) (gjson.Result, error) {
+	q := url.Values{
+		"sym": {pair(symbol)},
+		"id":  {refID},
+		"sd":  {strings.ToLower(side)},
+	}
+	return c.call(op, http.MethodGet, "/api/v3/market/order-info", q, nil)
+}
+
+// GetCommission returns order commission, the order info of Bitkub requires the side,
+// then both sides are tried
+func (c Client) GetCommission(symbol string, orderRefID string) *float64 {
+	for _, side := range []string{t.OrderSideBuy, t.OrderSideSell} {
+		r, err := c.getOrderInfo("GetCommission", symbol, orderRefID, side)
+		if err != nil {
+			continue
+		}
+		fee := r.Get("fee").Float()
+		return &fee
+	}
+	return nil
+}
+
+// GetOrder returns the order by its IDs, Bitkub cannot look an order up by its client order ID
+func (c Client) GetOrder(o t.Order) (*t.Order, error) {
+	if o.RefID == "" {
+		return nil, exerr.New("GetOrder", 0, "no order ID", exerr.ErrInvalidOrder)
+	}
+	r, err := c.getOrderInfo("GetOrder", o.Symbol, o.RefID, o.Side)
+	if err != nil {
+		return nil, err
+	}
+	o.Status = toStatus(r)
+	o.Commission = r.Get("fee").Float()
+	history := r.Get("history").Array()
+	if len(history) > 0 {
+		o.UpdateTime = toMillis(history[len(history)-1].Get("timestamp").Int())
+	}
+	return &o, nil
+}
+
+// placeOrder places a bid or an ask, the amount of a bid is in THB
+func (c Client) placeOrder(op string, o t.Order, typ string) (*t.Order, error) {
+	path := "/api/v3/market/place-ask"
+	amt := o.Qty
+	if o.Side == t.OrderSideBuy {
+		path = "/api/v3/market/place-bid"
+		amt = o.Qty * o.OpenPrice
+	}
+	rat := o.OpenPrice
+	if typ == "market" {
+		rat = 0
+	}
+	payload := map[string]interface{}{
+		"sym":       pair(o.Symbol),
+		"amt":       amt,
+		"rat":       rat,
+		"typ":       typ,
+		"client_id": o.ID,
+	}
+	r, err := c.call(op, http.MethodPost, path, nil, payload)
+	if err != nil {
+		return nil, err
+	}
+	o.RefID = r.Get("id").String()
+	o.Status = t.OrderStatusNew
+	o.OpenTime = toMillis(r.Get("ts").Int())
+	if o.OpenTime == 0 {
+		o.OpenTime = c.clock.Now13()
+	}
+	return &o, nil
+}
+
+// OpenLimitOrder opens a limit order on Bitkub
+func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
+	if o.Type != t.OrderTypeLimit {
+		return nil, nil
+	}
+	return c.placeOrder("OpenLimitOrder", o, "limit")
+}
+
+// OpenStopOrder opens a take profit order on Bitkub as a limit order at its limit price,
+// Bitkub has no stop orders, then a stop loss order cannot be opened
+func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
+	if o.Type == t.OrderTypeSL {
+		return nil, exerr.New("OpenStopOrder", 0, "stop loss orders are not supported", exerr.ErrInvalidOrder)
+	}
+	if o.Type != t.OrderTypeTP {
+		return nil, nil
+	}
+	return c.placeOrder("OpenStopOrder", o, "limit")
+}
+
+// OpenMarketOrder opens a market order on Bitkub, a bid spends the quantity at the open price in THB
+func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
+	if o.Type != t.OrderTypeMarket {
+		return nil, nil
+	}
+	if o.Side == t.OrderSideBuy && o.OpenPrice <= 0 {
+		return nil, exerr.New("OpenMarketOrder", 0, "no open price", exerr.ErrInvalidOrder)
+	}
+	return c.placeOrder("OpenMarketOrder", o, "market")
+}
+
+// CancelOrder cancels an order on Bitkub
+func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
+	payload := map[string]interface{}{
+		"sym": pair(o.Symbol),
+		"id":  o.RefID,
+		"sd":  strings.ToLower(o.Side),
+	}
+	if _, err := c.call("CancelOrder", http.MethodPost, "/api/v3/market/cancel-order", nil, payload); err != nil {
+		return nil, err
+	}
+	o.Status = t.OrderStatusCanceled
+	o.UpdateTime = c.clock.Now13()
+	return &o, nil
+}
+
+// CloseOrder closes a filled order with an opposite market order, and returns the market order.
+// The market order is of the close order ID of the order, when it has been given.
+// A bid spends the quantity at the open price of the order in THB.
+func (c Client) CloseOrder(o t.Order) (*t.Order, error) {
+	if o.Status != t.OrderStatusFilled {
+		return nil, exerr.New("CloseOrder", 0, "the order is not filled", exerr.ErrInvalidOrder)
+	}
+	id := o.CloseOrderID
+	if id == "" {
+		id = h.GenID()
+	}
+	return c.OpenMarketOrder(t.Order{
+		ID:          id,
+		BotID:       o.BotID,
+		Exchange:    o.Exchange,
+		Symbol:      o.Symbol,
+		Side:        h.Reverse(o.Side),
+		Type:        t.OrderTypeMarket,
+		Qty:         o.Qty,
+		OpenPrice:   o.OpenPrice,
+		OpenOrderID: o.ID,
+	})
+}
@@ -1,30 +1,249 @@
 package bitkub
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/exerr"
+	"github.com/tonkla/autotp/types"
 )
 
 const symbol = "THB_BNB"
 
-var c = NewClient()
+// fixtureServer serves the recorded responses in testdata, by the last element of the path.
+// The signed requests are verified, and their bodies are kept by the path.
+type fixtureServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies map[string]string
+	fail   bool
+}
+
+func newFixtureServer(t *testing.T) *fixtureServer {
+	s := &fixtureServer{bodies: make(map[string]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.URL.Path, "/api/v3/") {
+			payload := r.Header.Get("X-BTK-TIMESTAMP") + r.Method + r.URL.RequestURI() + string(body)
+			if r.Header.Get("X-BTK-APIKEY") != "key" || r.Header.Get("X-BTK-SIGN") != Sign(payload, "secret") {
+				t.Errorf("Invalid signature of %s", r.URL.RequestURI())
+			}
+		}
+		s.mu.Lock()
+		s.bodies[r.URL.Path] = string(body)
+		fail := s.fail
+		s.mu.Unlock()
+
+		name := path.Base(r.URL.Path)
+		if fail {
+			name = "error"
+		}
+		data, err := os.ReadFile("testdata/" + name + ".json")
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}))
+	return s
+}
+
+func (s *fixtureServer) body(path string) gjson.Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return gjson.Parse(s.bodies[path])
+}
+
+func newTestClient(t *testing.T) (Client, *fixtureServer) {
+	s := newFixtureServer(t)
+	c := NewClient("key", "secret")
+	c.baseURL = s.URL
+	c.clock = clock.NewSim(1634090700000)
+	return c, s
+}
+
+func TestPair(t *testing.T) {
+	if p := pair(symbol); p != "bnb_thb" {
+		t.Errorf("Expect bnb_thb, got %s", p)
+	}
+	if p := pair("btc_thb"); p != "btc_thb" {
+		t.Errorf("Expect btc_thb, got %s", p)
+	}
+}
 
 func TestGetTicker(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
 	ticker := c.GetTicker(symbol)
-	if ticker.Price == 0 {
-		t.Fail()
+	if ticker == nil || ticker.Price != 15520.02 || ticker.Exchange != types.ExcBitkub {
+		t.Errorf("Unexpected ticker: %+v", ticker)
 	}
 }
 
 func TestGetHistoricalPrices(t *testing.T) {
-	prices := c.GetHistoricalPrices(symbol, 86400)
-	if len(prices) != 1 || prices[0].Open == 0 {
-		t.Fail()
+	c, s := newTestClient(t)
+	defer s.Close()
+	prices := c.GetHistoricalPrices(symbol, "1h", 2)
+	if len(prices) != 2 || prices[1].Time != 1634090400000 || prices[1].Open != 15500 || prices[1].High != 15540 ||
+		prices[1].Low != 15490 || prices[1].Close != 15520.02 {
+		t.Errorf("Unexpected prices: %+v", prices)
+	}
+	if _, err := c.GetHistoricalPricesRange(symbol, "3m", 0, 0); err == nil {
+		t.Error("Expect an invalid timeframe")
 	}
 }
 
 func TestGetOrderBook(t *testing.T) {
-	book := c.GetOrderBook(symbol, 5)
-	if len(book.Bids) != 5 || len(book.Asks) != 5 || book.Bids[0].Price == 0.0 || book.Asks[0].Price == 0 {
-		t.Error()
+	c, s := newTestClient(t)
+	defer s.Close()
+	book := c.GetOrderBook(symbol, 2)
+	if book == nil || len(book.Bids) != 2 || len(book.Asks) != 2 || book.Bids[0].Price != 15510.05 || book.Asks[1].Qty != 1.2 {
+		t.Errorf("Unexpected order book: %+v", book)
+	}
+}
+
+func TestGetBalances(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	balances, err := c.GetBalances()
+	if err != nil || len(balances) != 2 {
+		t.Fatalf("Unexpected balances: %+v, %v", balances, err)
+	}
+	if b := balances[0]; b.Asset != "THB" || b.Free != 188379.27 || b.Locked != 15500 {
+		t.Errorf("Unexpected balance: %+v", b)
+	}
+}
+
+func TestGetOpenOrders(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	orders := c.GetOpenOrders(symbol)
+	if len(orders) != 2 {
+		t.Fatalf("Expect 2 open orders, got %d", len(orders))
+	}
+	if o := orders[0]; o.ID != "order1" || o.RefID != "2" || o.Side != types.OrderSideBuy || o.Qty != 1 ||
+		o.OpenPrice != 15500 || o.OpenTime != 1634090400000 || o.Status != types.OrderStatusNew {
+		t.Errorf("Unexpected bid: %+v", o)
+	}
+	if o := orders[1]; o.Side != types.OrderSideSell || o.Qty != 0.25 {
+		t.Errorf("Unexpected ask: %+v", o)
+	}
+	if n, err := c.CountOpenOrders(symbol); err != nil || n != 2 {
+		t.Errorf("Expect 2 open orders, got %d, %v", n, err)
+	}
+}
+
+func TestGetTradeList(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	trades, err := c.GetTradeList(symbol, 2, 0, 0)
+	if err != nil || len(trades) != 2 {
+		t.Fatalf("Unexpected trades: %+v, %v", trades, err)
+	}
+	if tr := trades[1]; tr.RefID != "4" || !tr.IsBuyer || tr.IsMaker || tr.Price != 15520 || tr.Qty != 0.25 ||
+		tr.Commission != 9.7 || tr.Time != 1634090300000 {
+		t.Errorf("Unexpected trade: %+v", tr)
+	}
+	orders := c.GetAllOrders(symbol, 2, 0, 0)
+	if len(orders) != 2 || orders[0].Status != types.OrderStatusFilled || orders[0].Side != types.OrderSideSell {
+		t.Errorf("Unexpected orders: %+v", orders)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	o, err := c.GetOrder(types.Order{Symbol: symbol, ID: "order3", RefID: "4", Side: types.OrderSideBuy})
+	if err != nil || o.Status != types.OrderStatusFilled || o.UpdateTime != 1634090300000 || o.Commission != 9.7 {
+		t.Errorf("Unexpected order: %+v, %v", o, err)
+	}
+	// The order without its exchange ID is searched by the robot, not reported as not found
+	if _, err = c.GetOrder(types.Order{Symbol: symbol, ID: "order3", Side: types.OrderSideBuy}); err == nil ||
+		errors.Is(err, exerr.ErrOrderNotFound) {
+		t.Errorf("Expect an error other than not found, got %v", err)
+	}
+	if fee := c.GetCommission(symbol, "4"); fee == nil || *fee != 9.7 {
+		t.Errorf("Unexpected commission: %v", fee)
+	}
+}
+
+func TestOpenLimitOrder(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+
+	o, err := c.OpenLimitOrder(types.Order{Symbol: symbol, ID: "order4", Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Qty: 0.5, OpenPrice: 15500})
+	if err != nil || o.RefID != "6" || o.Status != types.OrderStatusNew || o.OpenTime != 1634090600000 {
+		t.Fatalf("Unexpected bid: %+v, %v", o, err)
+	}
+	// The amount of a bid is in THB
+	if b := s.body("/api/v3/market/place-bid"); b.Get("sym").String() != "bnb_thb" || b.Get("amt").Float() != 7750 ||
+		b.Get("rat").Float() != 15500 || b.Get("typ").String() != "limit" || b.Get("client_id").String() != "order4" {
+		t.Errorf("Unexpected bid payload: %s", b.Raw)
+	}
+
+	o, err = c.OpenStopOrder(types.Order{Symbol: symbol, ID: "order5", Side: types.OrderSideSell,
+		Type: types.OrderTypeTP, Qty: 0.5, OpenPrice: 15600, StopPrice: 15590})
+	if err != nil || o.RefID != "7" {
+		t.Fatalf("Unexpected ask: %+v, %v", o, err)
+	}
+	if b := s.body("/api/v3/market/place-ask"); b.Get("amt").Float() != 0.5 || b.Get("rat").Float() != 15600 {
+		t.Errorf("Unexpected ask payload: %s", b.Raw)
+	}
+
+	if _, err = c.OpenStopOrder(types.Order{Symbol: symbol, Side: types.OrderSideSell, Type: types.OrderTypeSL}); !errors.Is(err, exerr.ErrInvalidOrder) {
+		t.Errorf("Expect stop loss orders not supported, got %v", err)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	o, err := c.CancelOrder(types.Order{Symbol: symbol, RefID: "6", Side: types.OrderSideBuy})
+	if err != nil || o.Status != types.OrderStatusCanceled || o.UpdateTime != 1634090700000 {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if b := s.body("/api/v3/market/cancel-order"); b.Get("id").String() != "6" || b.Get("sd").String() != "buy" {
+		t.Errorf("Unexpected payload: %s", b.Raw)
+	}
+
+	s.mu.Lock()
+	s.fail = true
+	s.mu.Unlock()
+	if _, err = c.CancelOrder(types.Order{Symbol: symbol, RefID: "6", Side: types.OrderSideBuy}); err == nil ||
+		err.Error() != "CancelOrder: error 18" || !errors.Is(err, exerr.ErrInsufficientBalance) {
+		t.Errorf("Expect an error of an insufficient balance, got %v", err)
+	}
+}
+
+func TestCloseOrder(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+
+	open := types.Order{Symbol: symbol, ID: "order4", Side: types.OrderSideBuy, Type: types.OrderTypeLimit,
+		Status: types.OrderStatusNew, Qty: 0.5, OpenPrice: 15500, CloseOrderID: "order9"}
+	if _, err := c.CloseOrder(open); !errors.Is(err, exerr.ErrInvalidOrder) {
+		t.Errorf("Expect the order not filled, got %v", err)
+	}
+
+	open.Status = types.OrderStatusFilled
+	o, err := c.CloseOrder(open)
+	if err != nil || o.ID != "order9" || o.Side != types.OrderSideSell || o.Type != types.OrderTypeMarket ||
+		o.OpenOrderID != "order4" || o.RefID != "7" {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if b := s.body("/api/v3/market/place-ask"); b.Get("amt").Float() != 0.5 || b.Get("rat").Float() != 0 ||
+		b.Get("typ").String() != "market" || b.Get("client_id").String() != "order9" {
+		t.Errorf("Unexpected ask payload: %s", b.Raw)
 	}
 }
@@ -0,0 +1,142 @@
+package exchange
+
+import (
+	"errors"
+
+	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/rdb"
+	t "github.com/tonkla/autotp/types"
+)
+
+// candlePageSize is the number of bars fetched and saved at a time by DownloadCandles
+const candlePageSize = 1000
+
+// KlineRepository is implemented by the clients that can fetch k-lines by a time range
+type KlineRepository interface {
+	GetHistoricalPricesRange(symbol string, timeframe string, startTime int64, endTime int64) ([]t.HistoricalPrice, error)
+}
+
+// DownloadCandles fetches the k-lines between the start time and the end time, and saves them into the DB.
+// It returns the number of saved candles.
+func DownloadCandles(ex Repository, db *rdb.DB, bp *t.BotParams, timeframe string, startTime int64, endTime int64) (int, error) {
+	kr, ok := ex.(KlineRepository)
+	if !ok {
+		return 0, errors.New("DownloadCandles: the exchange does not support k-lines by a time range")
+	}
+	dur := h.TfDuration(timeframe)
+	if dur == 0 {
+		return 0, errors.New("DownloadCandles: invalid timeframe")
+	}
+	if endTime == 0 {
+		endTime = h.Now13()
+	}
+
+	count := 0
+	startTime = h.TfOpenTime(timeframe, startTime)
+	for startTime <= endTime {
+		pageEnd := startTime + candlePageSize*dur - 1
+		if pageEnd > endTime {
+			pageEnd = endTime
+		}
+		prices, err := kr.GetHistoricalPricesRange(bp.Symbol, timeframe, startTime, pageEnd)
+		if err != nil {
+			return count, err
+		}
+		if err := db.SaveCandles(toCandles(bp.Exchange, timeframe, prices)); err != nil {
+			return count, err
+		}
+		count += len(prices)
+		startTime = pageEnd + 1
+	}
+	return count, nil
+}
+
+func toCandles(exchange string, timeframe string, prices []t.HistoricalPrice) []t.Candle {
+	candles := make([]t.Candle, 0, len(prices))
+	for _, p := range prices {
+		candles = append(candles, t.Candle{
+			Exchange:  exchange,
+			Symbol:    p.Symbol,
+			Timeframe: timeframe,
+			OpenTime:  p.Time,
+			Open:      p.Open,
+			High:      p.High,
+			Low:       p.Low,
+			Close:     p.Close,
+		})
+	}
+	return candles
+}
+
+// CachedClient keeps the k-lines in the DB, and fetches only the bars since the latest stored one
+type CachedClient struct {
+	Repository
+	db       *rdb.DB
+	exchange string
+}
+
+// NewCachedClient returns the exchange client, which caches k-lines in the DB.
+// The client is returned as it is, when it cannot fetch k-lines by a time range.
+func NewCachedClient(ex Repository, db *rdb.DB, bp *t.BotParams) Repository {
+	if _, ok := ex.(KlineRepository); !ok {
+		return ex
+	}
+	return &CachedClient{Repository: ex, db: db, exchange: bp.Exchange}
+}
+
+// GetHistoricalPrices returns historical prices from the DB, after fetching the missing latest bars
+func (c *CachedClient) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
+	dur := h.TfDuration(timeframe)
+	if dur == 0 || limit <= 0 {
+		return c.Repository.GetHistoricalPrices(symbol, timeframe, limit)
+	}
+
+	now := h.Now13()
+	startTime := h.TfOpenTime(timeframe, now) - int64(limit-1)*dur
+	prices := c.db.GetLastCandles(c.exchange, symbol, timeframe, 1)
+	// The latest stored bar may have been still forming, fetch it again
+	if len(prices) > 0 && prices[0].Time > startTime {
+		startTime = prices[0].Time
+	}
+
+	prices, err := c.Repository.(KlineRepository).GetHistoricalPricesRange(symbol, timeframe, startTime, now)
+	if err != nil {
+		h.Log("GetHistoricalPrices", err)
+		return nil
+	}
+	if err := c.db.SaveCandles(toCandles(c.exchange, timeframe, prices)); err != nil {
+		h.Log("GetHistoricalPrices", err)
+		return nil
+	}
+
+	prices = c.db.GetLastCandles(c.exchange, symbol, timeframe, limit)
+	if len(prices) < limit {
+		return nil
+	}
+	return prices
+}
+
+// Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
+func (c *CachedClient) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1w", limit)
+}
+
+// Get1dHistoricalPrices returns '1d' historical prices in a format of k-lines/candlesticks
+func (c *CachedClient) Get1dHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1d", limit)
+}
+
+// Get4hHistoricalPrices returns '4h' historical prices in a format of k-lines/candlesticks
+func (c *CachedClient) Get4hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "4h", limit)
+}
+
+// Get1hHistoricalPrices returns '1h' historical prices in a format of k-lines/candlesticks
+func (c *CachedClient) Get1hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1h", limit)
+}
+
+// Get15mHistoricalPrices returns '15m' historical prices in a format of k-lines/candlesticks
+func (c *CachedClient) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "15m", limit)
+}
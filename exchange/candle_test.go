@@ -0,0 +1,64 @@
+package exchange
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tonkla/autotp/exchange/sim"
+	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/rdb"
+	"github.com/tonkla/autotp/types"
+)
+
+// klineClient serves 1h k-lines by a time range, and counts the fetched bars
+type klineClient struct {
+	*sim.Client
+	fetched int
+}
+
+func (c *klineClient) GetHistoricalPricesRange(symbol string, timeframe string, startTime int64, endTime int64) ([]types.HistoricalPrice, error) {
+	var prices []types.HistoricalPrice
+	dur := h.TfDuration(timeframe)
+	for ot := h.TfOpenTime(timeframe, startTime); ot <= endTime; ot += dur {
+		p := float64(ot / dur % 100)
+		prices = append(prices, types.HistoricalPrice{Symbol: symbol, Time: ot, Open: p, High: p + 1, Low: p - 1, Close: p})
+	}
+	c.fetched += len(prices)
+	return prices, nil
+}
+
+func TestCandles(t *testing.T) {
+	db := rdb.Connect(fmt.Sprintf("file:candle%s?mode=memory&cache=shared", h.GenID()))
+	bp := types.BotParams{Exchange: types.ExcBinance, Symbol: symbol}
+	kc := &klineClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+
+	startTime := int64(1633046400000)
+	endTime := startTime + 2500*3600000 - 1
+	n, err := DownloadCandles(kc, db, &bp, "1h", startTime, endTime)
+	if err != nil || n != 2500 {
+		t.Fatalf("Expect 2500 k-lines, got %d, %v", n, err)
+	}
+	// Downloading again updates the stored candles
+	if _, err = DownloadCandles(kc, db, &bp, "1h", startTime, endTime); err != nil {
+		t.Fatal(err)
+	}
+	prices := db.GetCandles(bp.Exchange, symbol, "1h", startTime, startTime+9*3600000)
+	if len(prices) != 10 || prices[9].Time != startTime+9*3600000 {
+		t.Errorf("Unexpected candles: %+v", prices)
+	}
+
+	ex := NewCachedClient(kc, db, &bp)
+	kc.fetched = 0
+	prices = ex.GetHistoricalPrices(symbol, "1h", 50)
+	if len(prices) != 50 || kc.fetched != 50 {
+		t.Fatalf("Expect 50 bars fetched, got %d bars, %d fetched", len(prices), kc.fetched)
+	}
+	kc.fetched = 0
+	prices = ex.Get1hHistoricalPrices(symbol, 50)
+	if len(prices) != 50 || kc.fetched > 2 {
+		t.Errorf("Expect only the latest bars fetched, got %d bars, %d fetched", len(prices), kc.fetched)
+	}
+	if prices[49].Time != h.TfOpenTime("1h", h.Now13()) {
+		t.Errorf("Expect the current bar last, got %d", prices[49].Time)
+	}
+}
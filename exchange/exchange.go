@@ -5,6 +5,9 @@ import (
 
 	bf "github.com/tonkla/autotp/exchange/binance/futures"
 	bs "github.com/tonkla/autotp/exchange/binance/spot"
+	"github.com/tonkla/autotp/exchange/bitkub"
+	"github.com/tonkla/autotp/exchange/satang"
+	"github.com/tonkla/autotp/exchange/set"
 	t "github.com/tonkla/autotp/types"
 )
 
@@ -32,11 +35,49 @@ type Repository interface {
 }
 
 func New(bp *t.BotParams) (Repository, error) {
+	ex, err := NewClient(bp)
+	if err != nil {
+		return nil, err
+	}
+	if bp.Mode == t.ModePaper {
+		return NewPaperClient(ex, bp), nil
+	}
+	return ex, nil
+}
+
+// NewClient returns the client of the exchange itself, which is not wrapped by the paper trading client
+func NewClient(bp *t.BotParams) (Repository, error) {
+	if bp.Network != "" && bp.Network != t.NetworkMainnet && bp.Network != t.NetworkTestnet {
+		return nil, errors.New("network not found")
+	}
+	testnet := bp.Network == t.NetworkTestnet
 	if bp.Exchange == t.ExcBinance {
 		if bp.Product == t.ProductSpot {
-			return bs.NewSpotClient(bp.ApiKey, bp.SecretKey), nil
+			c := bs.NewSpotClient(bp.ApiKey, bp.SecretKey).WithRecvWindow(bp.RecvWindow)
+			if testnet {
+				c = c.WithEndpoints(bs.TestnetBaseURL, bs.TestnetWsURL)
+			}
+			return c.WithEndpoints(bp.BaseURL, bp.WsURL), nil
 		} else if bp.Product == t.ProductFutures {
-			return bf.NewFuturesClient(bp.ApiKey, bp.SecretKey), nil
+			c := bf.NewFuturesClient(bp.ApiKey, bp.SecretKey).WithRecvWindow(bp.RecvWindow)
+			if testnet {
+				c = c.WithEndpoints(bf.TestnetBaseURL, bf.TestnetWsURL)
+			}
+			return c.WithEndpoints(bp.BaseURL, bp.WsURL), nil
+		}
+	} else if testnet {
+		return nil, errors.New("testnet not supported")
+	} else if bp.Exchange == t.ExcBitkub {
+		if bp.Product == t.ProductSpot {
+			return bitkub.NewClient(bp.ApiKey, bp.SecretKey), nil
+		}
+	} else if bp.Exchange == t.ExcSatang {
+		if bp.Product == t.ProductSpot {
+			return satang.NewClient(bp.ApiKey, bp.SecretKey), nil
+		}
+	} else if bp.Exchange == t.ExcSET {
+		if bp.Product == t.ProductSpot {
+			return set.NewClient(), nil
 		}
 	}
 	return nil, errors.New("exchange not found")
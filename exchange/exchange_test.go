@@ -0,0 +1,24 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/tonkla/autotp/types"
+)
+
+func TestNewNetwork(t *testing.T) {
+	bp := &types.BotParams{Exchange: types.ExcBinance, Product: types.ProductFutures, Network: types.NetworkTestnet}
+	if _, err := New(bp); err != nil {
+		t.Errorf("Expect the testnet client, got %v", err)
+	}
+
+	bp.Network = "DEVNET"
+	if _, err := New(bp); err == nil {
+		t.Error("Expect an error of the unknown network")
+	}
+
+	bp = &types.BotParams{Exchange: types.ExcBitkub, Product: types.ProductSpot, Network: types.NetworkTestnet}
+	if _, err := New(bp); err == nil {
+		t.Error("Expect an error of the exchange without a testnet")
+	}
+}
@@ -0,0 +1,81 @@
+// Package exerr is the errors of the exchanges, the adapters map the errors of their APIs into these kinds,
+// so the robot can handle a failed request by its kind, not by its message.
+package exerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	h "github.com/tonkla/autotp/helper"
+)
+
+// The kinds of the errors, errors.Is matches an *Error by its kind
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrWouldTrigger        = errors.New("order would immediately trigger")
+	ErrInvalidOrder        = errors.New("invalid order")
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrRejected            = errors.New("request rejected")
+	ErrTimestamp           = errors.New("timestamp outside of the recvWindow")
+	ErrRateLimited         = h.ErrRateLimited
+	ErrUnavailable         = errors.New("exchange unavailable")
+	ErrUnknownStatus       = errors.New("execution status unknown")
+	ErrUnauthorized        = errors.New("unauthorized")
+)
+
+// retryable are the kinds of the requests that have not been executed, they can be sent again as they are.
+// ErrTimestamp is not one of them, the client has already synchronized its clock and retried the request.
+var retryable = []error{ErrRateLimited, ErrUnavailable}
+
+// rejected are the kinds of the orders that the exchange has refused, they would be refused again as they are
+var rejected = []error{ErrInsufficientBalance, ErrWouldTrigger, ErrInvalidOrder, ErrRejected}
+
+// Error is an error of an exchange, with the code and the message of its API
+type Error struct {
+	Op   string
+	Code int64
+	Msg  string
+	Kind error
+}
+
+// New returns the error of the operation, Kind is one of the kinds of this package
+func New(op string, code int64, msg string, kind error) *Error {
+	return &Error{Op: op, Code: code, Msg: msg, Kind: kind}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Op, e.Msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// IsRetryable returns true when the request has not been executed, and it can be sent again
+func IsRetryable(err error) bool {
+	return isKind(err, retryable)
+}
+
+// IsRejected returns true when the exchange has refused the order, e.g. an insufficient balance
+func IsRejected(err error) bool {
+	return isKind(err, rejected)
+}
+
+// IsAmbiguous returns true when the request may have reached the exchange, but its result is unknown
+func IsAmbiguous(err error) bool {
+	var ue *url.Error
+	return errors.As(err, &ue) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrUnknownStatus)
+}
+
+func isKind(err error, kinds []error) bool {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
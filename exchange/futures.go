@@ -0,0 +1,65 @@
+package exchange
+
+import (
+	"fmt"
+
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+// FuturesAccount is implemented by the futures clients that can read and configure the account
+type FuturesAccount interface {
+	GetPositions(symbol string) ([]t.Position, error)
+	GetFuturesBalance(asset string) (*t.FuturesBalance, error)
+	SetLeverage(symbol string, leverage int64) error
+	SetMarginType(symbol string, marginType string) error
+	GetPositionMode() (string, error)
+	SetPositionMode(mode string) error
+}
+
+// SetupFutures configures the futures account of the bot's symbol by its leverage, margin type and position mode,
+// the settings that are not given are left unchanged
+func SetupFutures(fa FuturesAccount, bp *t.BotParams) error {
+	if bp.PositionMode != "" {
+		if bp.PositionMode != t.PositionModeHedge && bp.PositionMode != t.PositionModeOneWay {
+			return fmt.Errorf("SetupFutures: invalid position mode %s", bp.PositionMode)
+		}
+		mode, err := fa.GetPositionMode()
+		if err != nil {
+			return err
+		}
+		if mode != bp.PositionMode {
+			if err := fa.SetPositionMode(bp.PositionMode); err != nil {
+				return err
+			}
+		}
+	}
+
+	if bp.MarginType != "" {
+		if bp.MarginType != t.MarginTypeIsolated && bp.MarginType != t.MarginTypeCrossed {
+			return fmt.Errorf("SetupFutures: invalid margin type %s", bp.MarginType)
+		}
+		if err := fa.SetMarginType(bp.Symbol, bp.MarginType); err != nil {
+			return err
+		}
+	}
+
+	if bp.Leverage > 0 {
+		if err := fa.SetLeverage(bp.Symbol, bp.Leverage); err != nil {
+			return err
+		}
+	}
+
+	positions, err := fa.GetPositions(bp.Symbol)
+	if err != nil {
+		return err
+	}
+	for _, p := range positions {
+		if p.Qty == 0 {
+			continue
+		}
+		h.Logf("{Position:%s Qty:%.4f Entry:%.4f PnL:%.4f Liquidation:%.4f Leverage:%d Margin:%s}\n",
+			p.PosSide, p.Qty, p.EntryPrice, p.UnrealizedPnL, p.LiquidationPrice, p.Leverage, p.MarginType)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/tonkla/autotp/types"
+)
+
+// futuresAccount records the settings of the account
+type futuresAccount struct {
+	mode       string
+	marginType string
+	leverage   int64
+	calls      []string
+}
+
+func (a *futuresAccount) GetPositions(symbol string) ([]types.Position, error) {
+	return []types.Position{{Symbol: symbol, PosSide: types.OrderPosSideLong, Qty: 0.5, EntryPrice: 480}}, nil
+}
+
+func (a *futuresAccount) GetFuturesBalance(asset string) (*types.FuturesBalance, error) {
+	return &types.FuturesBalance{Asset: asset, Wallet: 1000, Available: 900}, nil
+}
+
+func (a *futuresAccount) SetLeverage(symbol string, leverage int64) error {
+	a.calls = append(a.calls, "leverage")
+	a.leverage = leverage
+	return nil
+}
+
+func (a *futuresAccount) SetMarginType(symbol string, marginType string) error {
+	a.calls = append(a.calls, "marginType")
+	a.marginType = marginType
+	return nil
+}
+
+func (a *futuresAccount) GetPositionMode() (string, error) {
+	return a.mode, nil
+}
+
+func (a *futuresAccount) SetPositionMode(mode string) error {
+	a.calls = append(a.calls, "positionMode")
+	a.mode = mode
+	return nil
+}
+
+func TestSetupFutures(t *testing.T) {
+	fa := &futuresAccount{mode: types.PositionModeOneWay}
+	bp := &types.BotParams{Symbol: symbol, Leverage: 5, MarginType: types.MarginTypeIsolated,
+		PositionMode: types.PositionModeHedge}
+	if err := SetupFutures(fa, bp); err != nil {
+		t.Fatal(err)
+	}
+	if fa.mode != types.PositionModeHedge || fa.marginType != types.MarginTypeIsolated || fa.leverage != 5 {
+		t.Errorf("Unexpected account: %+v", fa)
+	}
+
+	// The settings that are not given, or have not been changed, are not sent
+	fa.calls = nil
+	if err := SetupFutures(fa, &types.BotParams{Symbol: symbol, PositionMode: types.PositionModeHedge}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fa.calls) != 0 {
+		t.Errorf("Expect no changes, got %v", fa.calls)
+	}
+
+	if err := SetupFutures(fa, &types.BotParams{Symbol: symbol, MarginType: "CROSS"}); err == nil {
+		t.Error("Expect an invalid margin type")
+	}
+}
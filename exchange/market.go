@@ -0,0 +1,243 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+// maxBufferBars is the number of k-lines kept in memory per timeframe
+const maxBufferBars = 1500
+
+// maxSubscriptions is the number of timeframes that can be waiting to be subscribed to
+const maxSubscriptions = 16
+
+// MarketStreamer is implemented by the clients that can stream the market data of a symbol. The stream pushes
+// the tickers, and the k-lines of the timeframes received from subscribe, until the context is done.
+// The connected callback is called with true after every connection, and with false after every disconnection.
+type MarketStreamer interface {
+	StreamMarket(ctx context.Context, symbol string, tickerSource string, subscribe <-chan string,
+		onTicker func(t.Ticker), onKline func(string, t.HistoricalPrice), connected func(bool))
+}
+
+type candleBuffer struct {
+	prices []t.HistoricalPrice
+	// stale is true until the buffer has been backfilled after a connection
+	stale bool
+}
+
+// MarketClient answers the tickers and the k-lines from the market data stream, with rolling candle buffers.
+// It falls back to the exchange when the stream is disconnected, and backfills the buffers after reconnecting.
+type MarketClient struct {
+	Repository
+	streamer     MarketStreamer
+	klines       KlineRepository
+	symbol       string
+	tickerSource string
+	subscribe    chan string
+
+	// Tickers receives the latest ticker on every price change, only the latest one is kept
+	Tickers chan t.Ticker
+	// Connected receives the latest connection state of the stream, on every connection and disconnection
+	Connected chan bool
+
+	mu        sync.RWMutex
+	connected bool
+	ticker    *t.Ticker
+	buffers   map[string]*candleBuffer
+}
+
+// NewMarketClient returns the exchange client, which streams the market data of the symbol by the streamer
+func NewMarketClient(ex Repository, ms MarketStreamer, bp *t.BotParams) *MarketClient {
+	kr, _ := ms.(KlineRepository)
+	return &MarketClient{
+		Repository:   ex,
+		streamer:     ms,
+		klines:       kr,
+		symbol:       bp.Symbol,
+		tickerSource: bp.TickerSource,
+		subscribe:    make(chan string, maxSubscriptions),
+		Tickers:      make(chan t.Ticker, 1),
+		Connected:    make(chan bool, 1),
+		buffers:      make(map[string]*candleBuffer),
+	}
+}
+
+// Run subscribes to the market data until the context is done
+func (c *MarketClient) Run(ctx context.Context) {
+	c.streamer.StreamMarket(ctx, c.symbol, c.tickerSource, c.subscribe, c.onTicker, c.onKline, c.setConnected)
+}
+
+func (c *MarketClient) setConnected(connected bool) {
+	c.mu.Lock()
+	c.connected = connected
+	// The k-lines may have been missed while disconnected
+	for _, buf := range c.buffers {
+		buf.stale = true
+	}
+	c.mu.Unlock()
+
+	// Replace the unread state with the latest one
+	select {
+	case <-c.Connected:
+	default:
+	}
+	select {
+	case c.Connected <- connected:
+	default:
+	}
+}
+
+func (c *MarketClient) onTicker(tk t.Ticker) {
+	c.mu.Lock()
+	changed := c.ticker == nil || c.ticker.Price != tk.Price
+	c.ticker = &tk
+	c.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	// Replace the unread ticker with the latest one
+	select {
+	case <-c.Tickers:
+	default:
+	}
+	select {
+	case c.Tickers <- tk:
+	default:
+	}
+}
+
+func (c *MarketClient) onKline(timeframe string, p t.HistoricalPrice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf, ok := c.buffers[timeframe]
+	if !ok {
+		return
+	}
+	n := len(buf.prices)
+	if n > 0 && buf.prices[n-1].Time == p.Time {
+		buf.prices[n-1] = p
+		return
+	}
+	if n > 0 && p.Time < buf.prices[n-1].Time {
+		return
+	}
+	if n > 0 && p.Time > buf.prices[n-1].Time+h.TfDuration(timeframe) {
+		buf.stale = true
+	}
+	buf.prices = append(buf.prices, p)
+	if len(buf.prices) > maxBufferBars {
+		buf.prices = buf.prices[len(buf.prices)-maxBufferBars:]
+	}
+}
+
+// GetTicker returns the latest ticker from the stream, or from the exchange
+func (c *MarketClient) GetTicker(symbol string) *t.Ticker {
+	c.mu.RLock()
+	if c.connected && c.ticker != nil && symbol == c.symbol {
+		tk := *c.ticker
+		c.mu.RUnlock()
+		return &tk
+	}
+	c.mu.RUnlock()
+	return c.Repository.GetTicker(symbol)
+}
+
+// GetHistoricalPrices returns the latest k-lines from the candle buffer,
+// after subscribing to the timeframe and backfilling the buffer from the exchange when needed
+func (c *MarketClient) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
+	if symbol != c.symbol || limit <= 0 || limit > maxBufferBars || h.TfDuration(timeframe) == 0 {
+		return c.Repository.GetHistoricalPrices(symbol, timeframe, limit)
+	}
+
+	c.mu.Lock()
+	buf, ok := c.buffers[timeframe]
+	if !ok {
+		buf = &candleBuffer{stale: true}
+		c.buffers[timeframe] = buf
+	}
+	if c.connected && !buf.stale && len(buf.prices) >= limit {
+		prices := append([]t.HistoricalPrice{}, buf.prices[len(buf.prices)-limit:]...)
+		c.mu.Unlock()
+		return prices
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		select {
+		case c.subscribe <- timeframe:
+		default:
+			h.Log("MarketClient", "cannot subscribe to", timeframe)
+		}
+	}
+
+	prices := c.backfill(symbol, timeframe, limit)
+	if len(prices) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Keep the streamed k-lines newer than the backfilled ones
+	last := prices[len(prices)-1].Time
+	for _, p := range buf.prices {
+		if p.Time > last {
+			prices = append(prices, p)
+		}
+	}
+	buf.prices = prices
+	buf.stale = false
+	if len(prices) < limit {
+		return nil
+	}
+	return append([]t.HistoricalPrice{}, prices[len(prices)-limit:]...)
+}
+
+func (c *MarketClient) backfill(symbol string, timeframe string, limit int) []t.HistoricalPrice {
+	if c.klines != nil {
+		dur := h.TfDuration(timeframe)
+		now := h.Now13()
+		prices, err := c.klines.GetHistoricalPricesRange(symbol, timeframe, h.TfOpenTime(timeframe, now)-int64(limit-1)*dur, now)
+		if err != nil {
+			h.Log("MarketClient", err)
+			return nil
+		}
+		return prices
+	}
+
+	var prices []t.HistoricalPrice
+	for _, p := range c.Repository.GetHistoricalPrices(symbol, timeframe, limit) {
+		if p.Time > 0 {
+			prices = append(prices, p)
+		}
+	}
+	return prices
+}
+
+// Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
+func (c *MarketClient) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1w", limit)
+}
+
+// Get1dHistoricalPrices returns '1d' historical prices in a format of k-lines/candlesticks
+func (c *MarketClient) Get1dHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1d", limit)
+}
+
+// Get4hHistoricalPrices returns '4h' historical prices in a format of k-lines/candlesticks
+func (c *MarketClient) Get4hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "4h", limit)
+}
+
+// Get1hHistoricalPrices returns '1h' historical prices in a format of k-lines/candlesticks
+func (c *MarketClient) Get1hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1h", limit)
+}
+
+// Get15mHistoricalPrices returns '15m' historical prices in a format of k-lines/candlesticks
+func (c *MarketClient) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "15m", limit)
+}
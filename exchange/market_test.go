@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tonkla/autotp/exchange/sim"
+	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/types"
+)
+
+// marketClient streams the market data from nowhere, the events are pushed by the test
+type marketClient struct {
+	*klineClient
+}
+
+func (c *marketClient) StreamMarket(ctx context.Context, symbol string, tickerSource string, subscribe <-chan string,
+	onTicker func(types.Ticker), onKline func(string, types.HistoricalPrice), connected func(bool)) {
+	<-ctx.Done()
+}
+
+func TestMarketClient(t *testing.T) {
+	kc := &klineClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+	mc := NewMarketClient(kc, &marketClient{kc}, &types.BotParams{Symbol: symbol})
+
+	// The ticker is signaled on the price changes only
+	mc.setConnected(false)
+	mc.setConnected(true)
+	if connected := <-mc.Connected; !connected {
+		t.Error("Expect the latest connection state")
+	}
+	mc.onTicker(types.Ticker{Symbol: symbol, Price: 100, Time: 1})
+	mc.onTicker(types.Ticker{Symbol: symbol, Price: 100, Time: 2})
+	if tk := <-mc.Tickers; tk.Time != 1 {
+		t.Errorf("Expect the first ticker, got %+v", tk)
+	}
+	select {
+	case tk := <-mc.Tickers:
+		t.Errorf("Expect no ticker without a price change, got %+v", tk)
+	default:
+	}
+	mc.onTicker(types.Ticker{Symbol: symbol, Price: 101, Time: 3})
+	mc.onTicker(types.Ticker{Symbol: symbol, Price: 102, Time: 4})
+	if tk := <-mc.Tickers; tk.Price != 102 {
+		t.Errorf("Expect the latest ticker, got %+v", tk)
+	}
+	if tk := mc.GetTicker(symbol); tk == nil || tk.Price != 102 {
+		t.Errorf("Expect the streamed ticker, got %+v", tk)
+	}
+
+	// The buffer is backfilled once, then updated by the stream
+	prices := mc.Get1hHistoricalPrices(symbol, 50)
+	if len(prices) != 50 || kc.fetched != 50 {
+		t.Fatalf("Expect 50 bars fetched, got %d bars, %d fetched", len(prices), kc.fetched)
+	}
+	if tf := <-mc.subscribe; tf != "1h" {
+		t.Errorf("Expect the stream subscribed to 1h, got %s", tf)
+	}
+	last := prices[49]
+	next := types.HistoricalPrice{Symbol: symbol, Time: last.Time + 3600000, Open: 1, High: 2, Low: 0, Close: 1}
+	mc.onKline("1h", types.HistoricalPrice{Symbol: symbol, Time: last.Time, Open: 1, High: 3, Low: 0, Close: 2})
+	mc.onKline("1h", next)
+	kc.fetched = 0
+	prices = mc.GetHistoricalPrices(symbol, "1h", 50)
+	if len(prices) != 50 || kc.fetched != 0 {
+		t.Fatalf("Expect 50 bars from the buffer, got %d bars, %d fetched", len(prices), kc.fetched)
+	}
+	if prices[48].High != 3 || prices[49] != next {
+		t.Errorf("Expect the streamed k-lines last, got %+v %+v", prices[48], prices[49])
+	}
+
+	// A gap in the stream backfills the buffer again
+	mc.onKline("1h", types.HistoricalPrice{Symbol: symbol, Time: next.Time + 3*3600000})
+	mc.GetHistoricalPrices(symbol, "1h", 50)
+	if kc.fetched != 50 {
+		t.Errorf("Expect a backfill after a gap, got %d fetched", kc.fetched)
+	}
+
+	// A reconnection backfills the buffer again
+	mc.setConnected(false)
+	mc.setConnected(true)
+	kc.fetched = 0
+	prices = mc.GetHistoricalPrices(symbol, "1h", 10)
+	if len(prices) != 10 || kc.fetched != 10 {
+		t.Errorf("Expect a backfill after a reconnection, got %d bars, %d fetched", len(prices), kc.fetched)
+	}
+	if prices[9].Time < h.TfOpenTime("1h", h.Now13()) {
+		t.Errorf("Expect the current bar last, got %d", prices[9].Time)
+	}
+
+	// Disconnected, the ticker comes from the exchange
+	mc.setConnected(false)
+	if tk := mc.GetTicker(symbol); tk != nil && tk.Price == 102 {
+		t.Errorf("Expect the ticker from the exchange, got %+v", tk)
+	}
+}
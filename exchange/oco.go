@@ -0,0 +1,10 @@
+package exchange
+
+import (
+	t "github.com/tonkla/autotp/types"
+)
+
+// OCORepository is implemented by the spot clients that can place OCO (one-cancels-the-other) order lists
+type OCORepository interface {
+	OpenOCOOrder(tp t.Order, sl t.Order) ([]t.Order, error)
+}
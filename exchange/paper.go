@@ -0,0 +1,119 @@
+package exchange
+
+import (
+	"errors"
+
+	"github.com/tonkla/autotp/exchange/sim"
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+// PaperClient reads market data from the exchange, but places orders on a simulated exchange
+type PaperClient struct {
+	Repository
+	sim *sim.Client
+}
+
+// NewPaperClient returns a paper trading client of the exchange client
+func NewPaperClient(ex Repository, bp *t.BotParams) *PaperClient {
+	return &PaperClient{
+		Repository: ex,
+		sim:        sim.NewClient(bp.Symbol, "1m", bp.MakerFee, bp.TakerFee),
+	}
+}
+
+// Restore puts the active orders recorded in the DB back into the simulated exchange
+func (c *PaperClient) Restore(orders []t.Order) {
+	c.sim.Restore(orders)
+}
+
+// GetTicker returns the latest ticker from the exchange, and matches the simulated orders against it
+func (c *PaperClient) GetTicker(symbol string) *t.Ticker {
+	ticker := c.Repository.GetTicker(symbol)
+	if ticker == nil || ticker.Price <= 0 {
+		return ticker
+	}
+	if ticker.Time == 0 {
+		ticker.Time = h.Now13()
+	}
+	c.sim.Tick(*ticker)
+	return ticker
+}
+
+// GetSymbolInfo returns the trading rules of the symbol from the exchange, the orders are simulated only
+func (c *PaperClient) GetSymbolInfo(symbol string) (*t.SymbolInfo, error) {
+	if repo, ok := c.Repository.(SymbolInfoRepository); ok {
+		return repo.GetSymbolInfo(symbol)
+	}
+	return nil, errors.New("GetSymbolInfo: not supported")
+}
+
+// CountOpenOrders returns a number of open orders
+func (c *PaperClient) CountOpenOrders(symbol string) (int, error) {
+	return c.sim.CountOpenOrders(symbol)
+}
+
+// GetOrder returns the order by its IDs
+func (c *PaperClient) GetOrder(o t.Order) (*t.Order, error) {
+	return c.sim.GetOrder(o)
+}
+
+// GetOpenOrders returns open orders
+func (c *PaperClient) GetOpenOrders(symbol string) []t.Order {
+	return c.sim.GetOpenOrders(symbol)
+}
+
+// GetTradeList returns trades list for a specified symbol
+func (c *PaperClient) GetTradeList(symbol string, limit int, startTime int, endTime int) ([]t.TradeOrder, error) {
+	return c.sim.GetTradeList(symbol, limit, startTime, endTime)
+}
+
+// GetAllOrders returns all account orders; active, canceled, or filled
+func (c *PaperClient) GetAllOrders(symbol string, limit int, startTime int, endTime int) []t.Order {
+	return c.sim.GetAllOrders(symbol, limit, startTime, endTime)
+}
+
+// GetCommission returns order commission
+func (c *PaperClient) GetCommission(symbol string, orderRefID string) *float64 {
+	return c.sim.GetCommission(symbol, orderRefID)
+}
+
+// OpenLimitOrder opens a limit order
+func (c *PaperClient) OpenLimitOrder(o t.Order) (*t.Order, error) {
+	return c.sim.OpenLimitOrder(o)
+}
+
+// OpenMarketOrder opens a market order
+func (c *PaperClient) OpenMarketOrder(o t.Order) (*t.Order, error) {
+	return c.sim.OpenMarketOrder(o)
+}
+
+// OpenStopOrder opens a stop order
+func (c *PaperClient) OpenStopOrder(o t.Order) (*t.Order, error) {
+	return c.sim.OpenStopOrder(o)
+}
+
+// OpenOCOOrder opens an OCO order list of the TP and the SL orders
+func (c *PaperClient) OpenOCOOrder(tp t.Order, sl t.Order) ([]t.Order, error) {
+	return c.sim.OpenOCOOrder(tp, sl)
+}
+
+// OpenBatchOrders opens the limit and the stop orders
+func (c *PaperClient) OpenBatchOrders(orders []t.Order) ([]t.Order, []error) {
+	return c.sim.OpenBatchOrders(orders)
+}
+
+// CancelBatchOrders cancels the orders
+func (c *PaperClient) CancelBatchOrders(orders []t.Order) ([]t.Order, []error) {
+	return c.sim.CancelBatchOrders(orders)
+}
+
+// CancelOrder cancels an order
+func (c *PaperClient) CancelOrder(o t.Order) (*t.Order, error) {
+	return c.sim.CancelOrder(o)
+}
+
+// CloseOrder closes an order
+func (c *PaperClient) CloseOrder(o t.Order) (*t.Order, error) {
+	return c.sim.CloseOrder(o)
+}
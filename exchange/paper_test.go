@@ -0,0 +1,47 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/tonkla/autotp/exchange/sim"
+	"github.com/tonkla/autotp/types"
+)
+
+const symbol = "BNBUSDT"
+
+func TestPaperClient(t *testing.T) {
+	live := sim.NewClient(symbol, "1m", 0, 0)
+	live.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: 1634083200000})
+
+	bp := types.BotParams{Symbol: symbol, Mode: types.ModePaper}
+	pc := NewPaperClient(live, &bp)
+	if pc.GetTicker(symbol) == nil {
+		t.Fatal("Expect the ticker of the exchange")
+	}
+
+	o, err := pc.OpenLimitOrder(types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Qty: 1, OpenPrice: 99})
+	if err != nil || o == nil {
+		t.Fatal(err)
+	}
+	if n, _ := live.CountOpenOrders(symbol); n != 0 {
+		t.Error("The order must not be sent to the exchange")
+	}
+
+	live.Tick(types.Ticker{Symbol: symbol, Price: 98, Time: 1634083260000})
+	pc.GetTicker(symbol)
+	exo, err := pc.GetOrder(*o)
+	if err != nil || exo.Status != types.OrderStatusFilled {
+		t.Fatal("The order must be filled by the ticker of the exchange", err)
+	}
+
+	// A restarted bot gets its orders back from the DB
+	pc = NewPaperClient(live, &bp)
+	pc.Restore([]types.Order{*exo})
+	if exo, err = pc.GetOrder(*o); err != nil || exo.Status != types.OrderStatusFilled {
+		t.Error("The order must be restored", err)
+	}
+	if trades, _ := pc.GetTradeList(symbol, 5, 0, 0); len(trades) != 1 || trades[0].RefID != o.RefID {
+		t.Error("The filled order must be restored as a trade")
+	}
+}
@@ -1,59 +1,211 @@
 package satang
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tidwall/gjson"
 
-	"github.com/tonkla/autotp/helper"
-	"github.com/tonkla/autotp/types"
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/exerr"
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
 )
 
 type Client struct {
-	baseURL string
+	baseURL   string
+	apiKey    string
+	secretKey string
+	clock     clock.Clock
 }
 
-func NewClient() Client {
+// NewClient returns Satang Pro client
+func NewClient(apiKey string, secretKey string) Client {
 	return Client{
-		baseURL: "https://satangcorp.com/api/v3",
+		baseURL:   "https://satangcorp.com/api",
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		clock:     clock.Real{},
 	}
 }
 
+// Sign signs the parameters with a Satang Pro API secret key,
+// the payload is the parameters sorted by their keys, e.g. amount=1&nonce=2&pair=bnb_thb
+func Sign(params map[string]string, secretKey string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var payload strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			payload.WriteString("&")
+		}
+		fmt.Fprintf(&payload, "%s=%s", k, params[k])
+	}
+	mac := hmac.New(sha512.New, []byte(secretKey))
+	mac.Write([]byte(payload.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// toMillis returns the time in milliseconds of the ISO 8601 time
+func toMillis(s string) int64 {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0
+	}
+	return tm.UnixMilli()
+}
+
+// toStatus returns the order status of the Satang Pro order
+func toStatus(status string) string {
+	switch status {
+	case "processing":
+		return t.OrderStatusPartial
+	case "completed":
+		return t.OrderStatusFilled
+	case "cancelled":
+		return t.OrderStatusCanceled
+	}
+	return t.OrderStatusNew
+}
+
+// toOrder returns the order of the Satang Pro order
+func toOrder(symbol string, r gjson.Result) t.Order {
+	return t.Order{
+		Symbol:     symbol,
+		ID:         r.Get("client_order_id").String(),
+		RefID:      r.Get("id").String(),
+		Side:       strings.ToUpper(r.Get("side").String()),
+		Status:     toStatus(r.Get("status").String()),
+		Type:       strings.ToUpper(r.Get("type").String()),
+		Qty:        r.Get("amount").Float(),
+		OpenPrice:  r.Get("price").Float(),
+		OpenTime:   toMillis(r.Get("created_at").String()),
+		UpdateTime: toMillis(r.Get("updated_at").String()),
+	}
+}
+
+// errorKind returns the kind of the error message, Satang Pro answers an error with a message but no code
+func errorKind(msg string) error {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "not found"):
+		return exerr.ErrOrderNotFound
+	case strings.Contains(msg, "insufficient"):
+		return exerr.ErrInsufficientBalance
+	case strings.Contains(msg, "unauthorized"), strings.Contains(msg, "signature"), strings.Contains(msg, "api key"):
+		return exerr.ErrUnauthorized
+	case strings.Contains(msg, "too many"), strings.Contains(msg, "rate limit"):
+		return exerr.ErrRateLimited
+	case strings.Contains(msg, "server error"), strings.Contains(msg, "unavailable"), strings.Contains(msg, "gateway"):
+		return exerr.ErrUnknownStatus
+	case strings.Contains(msg, "invalid"):
+		return exerr.ErrInvalidOrder
+	}
+	return exerr.ErrRejected
+}
+
+// call calls the signed API of the operation with the parameters,
+// in the body of a POST or in the query string of the others
+func (c Client) call(op string, method string, path string, params map[string]string) (gjson.Result, error) {
+	params["nonce"] = strconv.FormatInt(c.clock.Now13(), 10)
+
+	var header http.Header = make(map[string][]string)
+	header.Set("Authorization", "TDAX-API "+c.apiKey)
+	header.Set("Signature", Sign(params, c.secretKey))
+
+	var data []byte
+	var err error
+	if method == http.MethodPost {
+		body, _err := json.Marshal(params)
+		if _err != nil {
+			return gjson.Result{}, fmt.Errorf("%s: %w", op, _err)
+		}
+		data, err = h.PostD(context.Background(), c.baseURL+path, header, string(body))
+	} else {
+		q := url.Values{}
+		for k, v := range params {
+			q.Set(k, v)
+		}
+		u := c.baseURL + path + "?" + q.Encode()
+		if method == http.MethodDelete {
+			data, err = h.Delete(context.Background(), u, header)
+		} else {
+			data, err = h.GetH(context.Background(), u, header)
+		}
+	}
+	if err != nil {
+		return gjson.Result{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// An error page of the server or of its proxy is not JSON, the order may have been executed
+	if !gjson.ValidBytes(data) {
+		kind := exerr.ErrUnknownStatus
+		if method == http.MethodGet {
+			kind = exerr.ErrUnavailable
+		}
+		return gjson.Result{}, exerr.New(op, 0, "invalid response", kind)
+	}
+
+	r := gjson.ParseBytes(data)
+	if msg := r.Get("message").String(); msg != "" && !r.Get("id").Exists() {
+		return gjson.Result{}, exerr.New(op, 0, msg, errorKind(msg))
+	}
+	return r, nil
+}
+
+// Public APIs -----------------------------------------------------------------
+
 // GetTicker returns the latest ticker
-func (c Client) GetTicker(symbol string) types.Ticker {
+func (c Client) GetTicker(symbol string) *t.Ticker {
 	var url strings.Builder
-	fmt.Fprintf(&url, "%s/ticker/24hr?symbol=%s", c.baseURL, symbol)
-	data, err := helper.Get(url.String())
+	fmt.Fprintf(&url, "%s/v3/ticker/24hr?symbol=%s", c.baseURL, symbol)
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
-		log.Println(err)
-		return types.Ticker{}
+		h.Log("GetTicker", err)
+		return nil
 	}
 	r := gjson.ParseBytes(data)
-	return types.Ticker{
-		Symbol: symbol,
-		Price:  r.Get("lastPrice").Float(),
-		Qty:    r.Get("lastQty").Float(),
+	return &t.Ticker{
+		Exchange: t.ExcSatang,
+		Symbol:   symbol,
+		Price:    r.Get("lastPrice").Float(),
+		Qty:      r.Get("lastQty").Float(),
+		Time:     r.Get("closeTime").Int(),
 	}
 }
 
-// GetHistoricalPrices returns historical prices in a format of Klines/Candlesticks
-func (c Client) GetHistoricalPrices(symbol string, interval string, limit int) []types.HistoricalPrice {
+// GetHistoricalPrices returns historical prices in a format of k-lines/candlesticks
+func (c Client) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
 	var url strings.Builder
-	fmt.Fprintf(&url, "%s/klines?symbol=%s&interval=%s&limit=%d", c.baseURL, symbol, interval, limit)
-	data, err := helper.Get(url.String())
+	fmt.Fprintf(&url, "%s/v3/klines?symbol=%s&interval=%s&limit=%d", c.baseURL, symbol, timeframe, limit)
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
-		log.Println(err)
+		h.Log("GetHistoricalPrices", err)
 		return nil
 	}
 
-	var hprices []types.HistoricalPrice
+	var hprices []t.HistoricalPrice
 	for _, data := range gjson.ParseBytes(data).Array() {
 		d := data.Array()
-		p := types.HistoricalPrice{
+		if len(d) < 5 {
+			continue
+		}
+		p := t.HistoricalPrice{
 			Symbol: symbol,
-			Time:   d[0].Int() / 1000,
+			Time:   d[0].Int(),
 			Open:   d[1].Float(),
 			High:   d[2].Float(),
 			Low:    d[3].Float(),
@@ -64,40 +216,325 @@ func (c Client) GetHistoricalPrices(symbol string, interval string, limit int) [
 	return hprices
 }
 
+// Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1w", limit)
+}
+
+// Get1dHistoricalPrices returns '1d' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1dHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1d", limit)
+}
+
+// Get4hHistoricalPrices returns '4h' historical prices in a format of k-lines/candlesticks
+func (c Client) Get4hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "4h", limit)
+}
+
+// Get1hHistoricalPrices returns '1h' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1h", limit)
+}
+
+// Get15mHistoricalPrices returns '15m' historical prices in a format of k-lines/candlesticks
+func (c Client) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "15m", limit)
+}
+
 // GetOrderBook returns an order book (market depth)
-func (c Client) GetOrderBook(symbol string, limit int) types.OrderBook {
+func (c Client) GetOrderBook(symbol string, limit int) *t.OrderBook {
 	var url strings.Builder
-	fmt.Fprintf(&url, "%s/depth?symbol=%s&limit=%d", c.baseURL, symbol, limit)
-	data, err := helper.Get(url.String())
+	fmt.Fprintf(&url, "%s/v3/depth?symbol=%s&limit=%d", c.baseURL, symbol, limit)
+	data, err := h.Get(context.Background(), url.String())
 	if err != nil {
-		log.Println(err)
-		return types.OrderBook{}
+		h.Log("GetOrderBook", err)
+		return nil
 	}
 
 	orders := gjson.ParseBytes(data)
 
-	var bids []types.ExOrder
+	var bids []t.ExOrder
 	for _, bid := range orders.Get("bids").Array() {
 		b := bid.Array()
-		ord := types.ExOrder{
-			Side:  types.OrderSideBuy,
-			Price: b[0].Float(),
-			Qty:   b[1].Float()}
+		ord := t.ExOrder{
+			Symbol: symbol,
+			Side:   t.OrderSideBuy,
+			Price:  b[0].Float(),
+			Qty:    b[1].Float()}
 		bids = append(bids, ord)
 	}
 
-	var asks []types.ExOrder
+	var asks []t.ExOrder
 	for _, ask := range orders.Get("asks").Array() {
 		a := ask.Array()
-		ord := types.ExOrder{
-			Side:  types.OrderSideSell,
-			Price: a[0].Float(),
-			Qty:   a[1].Float()}
+		ord := t.ExOrder{
+			Symbol: symbol,
+			Side:   t.OrderSideSell,
+			Price:  a[0].Float(),
+			Qty:    a[1].Float()}
 		asks = append(asks, ord)
 	}
 
-	return types.OrderBook{
+	return &t.OrderBook{
 		Symbol: symbol,
 		Bids:   bids,
 		Asks:   asks}
 }
+
+// Private APIs ----------------------------------------------------------------
+
+// GetBalances returns the available and the locked balances of the wallets
+func (c Client) GetBalances() ([]t.Balance, error) {
+	r, err := c.call("GetBalances", http.MethodGet, "/users/me", map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	var balances []t.Balance
+	r.Get("wallets").ForEach(func(asset, w gjson.Result) bool {
+		free := w.Get("available_balance").Float()
+		balances = append(balances, t.Balance{
+			Asset:  strings.ToUpper(asset.String()),
+			Free:   free,
+			Locked: w.Get("balance").Float() - free,
+		})
+		return true
+	})
+	return balances, nil
+}
+
+// CountOpenOrders returns a number of open orders
+func (c Client) CountOpenOrders(symbol string) (int, error) {
+	rs, err := c.call("CountOpenOrders", http.MethodGet, "/orders/user", map[string]string{"pair": symbol, "status": "open"})
+	if err != nil {
+		return 0, err
+	}
+	return len(rs.Array()), nil
+}
+
+// GetOpenOrders returns open orders
+func (c Client) GetOpenOrders(symbol string) []t.Order {
+	rs, err := c.call("GetOpenOrders", http.MethodGet, "/orders/user", map[string]string{"pair": symbol, "status": "open"})
+	if err != nil {
+		h.Log(err)
+		return nil
+	}
+
+	var orders []t.Order
+	for _, r := range rs.Array() {
+		orders = append(orders, toOrder(symbol, r))
+	}
+	return orders
+}
+
+// GetAllOrders returns all account orders; active, canceled, or filled
+func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime int) []t.Order {
+	params := map[string]string{"pair": symbol, "limit": "10"}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+	rs, err := c.call("GetAllOrders", http.MethodGet, "/orders/user", params)
+	if err != nil {
+		h.Log(err)
+		return nil
+	}
+
+	var orders []t.Order
+	for _, r := range rs.Array() {
+		o := toOrder(symbol, r)
+		if (startTime > 0 && o.OpenTime < int64(startTime)) || (endTime > 0 && o.OpenTime > int64(endTime)) {
+			continue
+		}
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// GetTradeList returns trades list for a specified symbol
+func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime int) ([]t.TradeOrder, error) {
+	params := map[string]string{"pair": symbol, "limit": "10"}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+	rs, err := c.call("GetTradeList", http.MethodGet, "/trades/user", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []t.TradeOrder
+	for _, r := range rs.Array() {
+		price := r.Get("price").Float()
+		qty := r.Get("amount").Float()
+		order := t.TradeOrder{
+			Symbol:          symbol,
+			RefID:           r.Get("order_id").String(),
+			Price:           price,
+			Qty:             qty,
+			QuoteQty:        price * qty,
+			Commission:      r.Get("fee").Float(),
+			CommissionAsset: strings.ToUpper(r.Get("fee_currency").String()),
+			Time:            toMillis(r.Get("created_at").String()),
+			IsBuyer:         r.Get("side").String() == "buy",
+			IsMaker:         r.Get("is_maker").Bool(),
+		}
+		if (startTime > 0 && order.Time < int64(startTime)) || (endTime > 0 && order.Time > int64(endTime)) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// GetCommission returns order commission, the sum of the fees of its trades
+func (c Client) GetCommission(symbol string, orderRefID string) *float64 {
+	trades, err := c.GetTradeList(symbol, 50, 0, 0)
+	if err != nil {
+		h.Log("GetCommission", err)
+		return nil
+	}
+	var commission float64
+	found := false
+	for _, tr := range trades {
+		if tr.RefID == orderRefID {
+			commission += tr.Commission
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &commission
+}
+
+// GetOrder returns the order by its IDs
+func (c Client) GetOrder(o t.Order) (*t.Order, error) {
+	if o.RefID == "" {
+		return c.getOrderByClientID(o)
+	}
+	r, err := c.call("GetOrder", http.MethodGet, "/orders/"+o.RefID, map[string]string{"pair": o.Symbol})
+	if err != nil {
+		return nil, err
+	}
+	if !r.Get("id").Exists() {
+		return nil, exerr.New("GetOrder", 0, "order not found", exerr.ErrOrderNotFound)
+	}
+	exo := toOrder(o.Symbol, r)
+	o.Status = exo.Status
+	o.UpdateTime = exo.UpdateTime
+	return &o, nil
+}
+
+// getOrderByClientID returns the order without its exchange ID, by its client order ID in the latest orders
+func (c Client) getOrderByClientID(o t.Order) (*t.Order, error) {
+	if o.ID != "" {
+		rs, err := c.call("GetOrder", http.MethodGet, "/orders/user", map[string]string{"pair": o.Symbol, "limit": "50"})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs.Array() {
+			if r.Get("client_order_id").String() != o.ID {
+				continue
+			}
+			exo := toOrder(o.Symbol, r)
+			o.RefID = exo.RefID
+			o.Status = exo.Status
+			o.UpdateTime = exo.UpdateTime
+			return &o, nil
+		}
+	}
+	return nil, exerr.New("GetOrder", 0, "order not found", exerr.ErrOrderNotFound)
+}
+
+// placeOrder places an order of the type
+func (c Client) placeOrder(op string, o t.Order, typ string) (*t.Order, error) {
+	params := map[string]string{
+		"pair":   o.Symbol,
+		"side":   strings.ToLower(o.Side),
+		"type":   typ,
+		"amount": strconv.FormatFloat(o.Qty, 'f', -1, 64),
+	}
+	if typ == "limit" {
+		params["price"] = strconv.FormatFloat(o.OpenPrice, 'f', -1, 64)
+	}
+	if o.ID != "" {
+		params["client_order_id"] = o.ID
+	}
+	r, err := c.call(op, http.MethodPost, "/orders/", params)
+	if err != nil {
+		return nil, err
+	}
+	exo := toOrder(o.Symbol, r)
+	if exo.RefID == "" {
+		return nil, exerr.New(op, 0, "no order ID", exerr.ErrUnknownStatus)
+	}
+	o.RefID = exo.RefID
+	o.Status = exo.Status
+	o.OpenTime = exo.OpenTime
+	if o.OpenTime == 0 {
+		o.OpenTime = c.clock.Now13()
+	}
+	if typ == "market" && r.Get("average_price").Float() > 0 {
+		o.OpenPrice = r.Get("average_price").Float()
+	}
+	return &o, nil
+}
+
+// OpenLimitOrder opens a limit order on Satang Pro
+func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
+	if o.Type != t.OrderTypeLimit {
+		return nil, nil
+	}
+	return c.placeOrder("OpenLimitOrder", o, "limit")
+}
+
+// OpenStopOrder opens a take profit order on Satang Pro as a limit order at its limit price,
+// Satang Pro has no stop orders, then a stop loss order cannot be opened
+func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
+	if o.Type == t.OrderTypeSL {
+		return nil, exerr.New("OpenStopOrder", 0, "stop loss orders are not supported", exerr.ErrInvalidOrder)
+	}
+	if o.Type != t.OrderTypeTP {
+		return nil, nil
+	}
+	return c.placeOrder("OpenStopOrder", o, "limit")
+}
+
+// OpenMarketOrder opens a market order on Satang Pro
+func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
+	if o.Type != t.OrderTypeMarket {
+		return nil, nil
+	}
+	return c.placeOrder("OpenMarketOrder", o, "market")
+}
+
+// CancelOrder cancels an order on Satang Pro
+func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
+	if _, err := c.call("CancelOrder", http.MethodDelete, "/orders/"+o.RefID, map[string]string{"pair": o.Symbol}); err != nil {
+		return nil, err
+	}
+	o.Status = t.OrderStatusCanceled
+	o.UpdateTime = c.clock.Now13()
+	return &o, nil
+}
+
+// CloseOrder closes a filled order with an opposite market order, and returns the market order.
+// The market order is of the close order ID of the order, when it has been given.
+func (c Client) CloseOrder(o t.Order) (*t.Order, error) {
+	if o.Status != t.OrderStatusFilled {
+		return nil, exerr.New("CloseOrder", 0, "the order is not filled", exerr.ErrInvalidOrder)
+	}
+	id := o.CloseOrderID
+	if id == "" {
+		id = h.GenID()
+	}
+	return c.OpenMarketOrder(t.Order{
+		ID:          id,
+		BotID:       o.BotID,
+		Exchange:    o.Exchange,
+		Symbol:      o.Symbol,
+		Side:        h.Reverse(o.Side),
+		Type:        t.OrderTypeMarket,
+		Qty:         o.Qty,
+		OpenPrice:   o.OpenPrice,
+		OpenOrderID: o.ID,
+	})
+}
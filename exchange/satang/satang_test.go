@@ -1,30 +1,282 @@
 package satang
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange/exerr"
+	"github.com/tonkla/autotp/types"
 )
 
 const symbol = "bnb_thb"
 
-var c = NewClient()
+const order = `{"id":1001,"type":"limit","price":"15500","amount":"0.5","remaining_amount":"0.5","average_price":"0",
+"side":"buy","cost":"0","created_at":"2021-10-13T02:00:00Z","updated_at":"2021-10-13T02:00:00Z","status":"created",
+"pair":"bnb_thb","client_order_id":"order1"}`
+
+const filledOrder = `{"id":1001,"type":"limit","price":"15500","amount":"0.5","remaining_amount":"0","average_price":"15500",
+"side":"buy","cost":"7750","created_at":"2021-10-13T02:00:00Z","updated_at":"2021-10-13T02:05:00Z","status":"completed",
+"pair":"bnb_thb","client_order_id":"order1"}`
+
+const trades = `[{"id":5001,"order_id":1001,"pair":"bnb_thb","side":"buy","price":"15500","amount":"0.3","fee":"0.0006",
+"fee_currency":"bnb","is_maker":true,"created_at":"2021-10-13T02:03:00Z"},{"id":5002,"order_id":1001,"pair":"bnb_thb",
+"side":"buy","price":"15500","amount":"0.2","fee":"0.0004","fee_currency":"bnb","is_maker":true,
+"created_at":"2021-10-13T02:05:00Z"}]`
+
+// newTestClient returns the client of a test server, which verifies the signatures and keeps the last parameters
+func newTestClient(t *testing.T) (Client, *httptest.Server, func() (string, map[string]string)) {
+	var mu sync.Mutex
+	var lastMethod string
+	var lastParams map[string]string
+
+	mux := http.NewServeMux()
+	handle := func(pattern string, handler func(w http.ResponseWriter, r *http.Request)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			params := make(map[string]string)
+			if r.Method == http.MethodPost {
+				json.NewDecoder(r.Body).Decode(&params)
+			} else {
+				for k := range r.URL.Query() {
+					params[k] = r.URL.Query().Get(k)
+				}
+			}
+			if r.Header.Get("Authorization") != "TDAX-API key" || r.Header.Get("Signature") != Sign(params, "secret") {
+				t.Errorf("Invalid signature of %s %s", r.Method, r.URL.Path)
+			}
+			mu.Lock()
+			lastMethod, lastParams = r.Method, params
+			mu.Unlock()
+			handler(w, r)
+		})
+	}
+	handle("/orders/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(order))
+	})
+	handle("/orders/1001", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.Write([]byte(`{"id":1001,"status":"cancelled"}`))
+			return
+		}
+		w.Write([]byte(filledOrder))
+	})
+	handle("/orders/404", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"order not found"}`))
+	})
+	handle("/orders/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[" + order + "]"))
+	})
+	handle("/trades/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(trades))
+	})
+	handle("/users/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":1,"wallets":{"thb":{"available_balance":"10000","balance":"17750"},
+"bnb":{"available_balance":"1.5","balance":"1.5"}}}`))
+	})
+	mux.HandleFunc("/v3/ticker/24hr", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"symbol":"bnb_thb","lastPrice":"15520","lastQty":"0.1","closeTime":1634090400000}`))
+	})
+	mux.HandleFunc("/v3/klines", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[[1634083200000,"15470","15510","15460","15480.5","12.5",1634086799999]]`))
+	})
+	mux.HandleFunc("/v3/depth", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"bids":[["15510","0.75"]],"asks":[["15530","0.5"]]}`))
+	})
+	srv := httptest.NewServer(mux)
+
+	c := NewClient("key", "secret")
+	c.baseURL = srv.URL
+	c.clock = clock.NewSim(1634090700000)
+	return c, srv, func() (string, map[string]string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return lastMethod, lastParams
+	}
+}
+
+func TestSign(t *testing.T) {
+	a := Sign(map[string]string{"pair": symbol, "nonce": "1"}, "secret")
+	b := Sign(map[string]string{"nonce": "1", "pair": symbol}, "secret")
+	if a != b || len(a) != 128 {
+		t.Errorf("Expect the signature of the sorted parameters, got %s, %s", a, b)
+	}
+}
+
+func TestPublicAPIs(t *testing.T) {
+	c, srv, _ := newTestClient(t)
+	defer srv.Close()
+
+	if tk := c.GetTicker(symbol); tk == nil || tk.Price != 15520 || tk.Time != 1634090400000 {
+		t.Errorf("Unexpected ticker: %+v", tk)
+	}
+	prices := c.GetHistoricalPrices(symbol, "1h", 1)
+	if len(prices) != 1 || prices[0].Time != 1634083200000 || prices[0].Close != 15480.5 {
+		t.Errorf("Unexpected prices: %+v", prices)
+	}
+	book := c.GetOrderBook(symbol, 1)
+	if book == nil || len(book.Bids) != 1 || len(book.Asks) != 1 || book.Bids[0].Price != 15510 {
+		t.Errorf("Unexpected order book: %+v", book)
+	}
+}
+
+func TestOpenLimitOrder(t *testing.T) {
+	c, srv, last := newTestClient(t)
+	defer srv.Close()
+
+	o, err := c.OpenLimitOrder(types.Order{Symbol: symbol, ID: "order1", Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Qty: 0.5, OpenPrice: 15500})
+	if err != nil || o.RefID != "1001" || o.Status != types.OrderStatusNew || o.OpenTime != 1634090400000 {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	method, params := last()
+	if method != http.MethodPost || params["side"] != "buy" || params["type"] != "limit" || params["amount"] != "0.5" ||
+		params["price"] != "15500" || params["client_order_id"] != "order1" || params["nonce"] != "1634090700000" {
+		t.Errorf("Unexpected parameters: %s %v", method, params)
+	}
+
+	if _, err = c.OpenStopOrder(types.Order{Symbol: symbol, Side: types.OrderSideSell, Type: types.OrderTypeSL}); !errors.Is(err, exerr.ErrInvalidOrder) {
+		t.Errorf("Expect stop loss orders not supported, got %v", err)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	c, srv, _ := newTestClient(t)
+	defer srv.Close()
+
+	o, err := c.GetOrder(types.Order{Symbol: symbol, ID: "order1", RefID: "1001"})
+	if err != nil || o.Status != types.OrderStatusFilled || o.UpdateTime != 1634090700000 {
+		t.Errorf("Unexpected order: %+v, %v", o, err)
+	}
+	if _, err = c.GetOrder(types.Order{Symbol: symbol, RefID: "404"}); err == nil || err.Error() != "GetOrder: order not found" ||
+		!errors.Is(err, exerr.ErrOrderNotFound) {
+		t.Errorf("Expect an error, got %v", err)
+	}
+
+	// The order that has not been answered with its ID is found by its client order ID
+	o, err = c.GetOrder(types.Order{Symbol: symbol, ID: "order1"})
+	if err != nil || o.RefID != "1001" || o.Status != types.OrderStatusNew {
+		t.Errorf("Unexpected order: %+v, %v", o, err)
+	}
+	if _, err = c.GetOrder(types.Order{Symbol: symbol, ID: "order2"}); !errors.Is(err, exerr.ErrOrderNotFound) {
+		t.Errorf("Expect the order not found, got %v", err)
+	}
+}
+
+func TestInvalidResponse(t *testing.T) {
+	newClient := func(body string) (Client, *httptest.Server) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(body))
+		}))
+		c := NewClient("key", "secret")
+		c.baseURL = srv.URL
+		return c, srv
+	}
+	o := types.Order{Symbol: symbol, ID: "order1", Side: types.OrderSideBuy, Type: types.OrderTypeLimit, Qty: 0.5,
+		OpenPrice: 15500}
+
+	// The order may have been placed, but a read has not been executed
+	c, srv := newClient("<html>502 Bad Gateway</html>")
+	defer srv.Close()
+	if _, err := c.OpenLimitOrder(o); !errors.Is(err, exerr.ErrUnknownStatus) {
+		t.Errorf("Expect an unknown status, got %v", err)
+	}
+	if _, err := c.GetOrder(types.Order{Symbol: symbol, RefID: "1001"}); !errors.Is(err, exerr.ErrUnavailable) {
+		t.Errorf("Expect the exchange unavailable, got %v", err)
+	}
+
+	c, srv2 := newClient("{}")
+	defer srv2.Close()
+	if _, err := c.OpenLimitOrder(o); !errors.Is(err, exerr.ErrUnknownStatus) {
+		t.Errorf("Expect an unknown status without an order ID, got %v", err)
+	}
+}
 
-func TestGetTicker(t *testing.T) {
-	ticker := c.GetTicker(symbol)
-	if ticker.Price == 0 || ticker.Qty == 0 {
-		t.Fail()
+func TestCancelOrder(t *testing.T) {
+	c, srv, last := newTestClient(t)
+	defer srv.Close()
+
+	o, err := c.CancelOrder(types.Order{Symbol: symbol, RefID: "1001"})
+	if err != nil || o.Status != types.OrderStatusCanceled || o.UpdateTime != 1634090700000 {
+		t.Errorf("Unexpected order: %+v, %v", o, err)
+	}
+	if method, params := last(); method != http.MethodDelete || params["pair"] != symbol {
+		t.Errorf("Unexpected parameters: %s %v", method, params)
 	}
 }
 
-func TestGetHistoricalPrices(t *testing.T) {
-	prices := c.GetHistoricalPrices(symbol, "1d", 1)
-	if len(prices) != 1 || prices[0].Open == 0 {
-		t.Fail()
+func TestGetOpenOrders(t *testing.T) {
+	c, srv, last := newTestClient(t)
+	defer srv.Close()
+
+	orders := c.GetOpenOrders(symbol)
+	if len(orders) != 1 || orders[0].ID != "order1" || orders[0].Side != types.OrderSideBuy || orders[0].Qty != 0.5 {
+		t.Errorf("Unexpected orders: %+v", orders)
+	}
+	if _, params := last(); params["status"] != "open" {
+		t.Errorf("Unexpected parameters: %v", params)
+	}
+	if n, err := c.CountOpenOrders(symbol); err != nil || n != 1 {
+		t.Errorf("Expect 1 open order, got %d, %v", n, err)
 	}
 }
 
-func TestGetOrderBook(t *testing.T) {
-	book := c.GetOrderBook(symbol, 5)
-	if len(book.Bids) != 5 || len(book.Asks) != 5 || book.Bids[0].Price == 0 || book.Asks[0].Price == 0 {
-		t.Fail()
+func TestGetTradeList(t *testing.T) {
+	c, srv, _ := newTestClient(t)
+	defer srv.Close()
+
+	orders, err := c.GetTradeList(symbol, 2, 0, 0)
+	if err != nil || len(orders) != 2 {
+		t.Fatalf("Unexpected trades: %+v, %v", orders, err)
+	}
+	if o := orders[0]; o.RefID != "1001" || o.Qty != 0.3 || o.Commission != 0.0006 || o.CommissionAsset != "BNB" ||
+		!o.IsBuyer || !o.IsMaker || o.Time != 1634090580000 {
+		t.Errorf("Unexpected trade: %+v", o)
+	}
+	// The commission of an order is the sum of the fees of its trades
+	if c := c.GetCommission(symbol, "1001"); c == nil || *c != 0.001 {
+		t.Errorf("Unexpected commission: %v", c)
+	}
+	if c := c.GetCommission(symbol, "404"); c != nil {
+		t.Errorf("Expect no commission, got %v", *c)
+	}
+}
+
+func TestGetBalances(t *testing.T) {
+	c, srv, _ := newTestClient(t)
+	defer srv.Close()
+
+	balances, err := c.GetBalances()
+	if err != nil || len(balances) != 2 {
+		t.Fatalf("Unexpected balances: %+v, %v", balances, err)
+	}
+	if b := balances[0]; b.Asset != "THB" || b.Free != 10000 || b.Locked != 7750 {
+		t.Errorf("Unexpected balance: %+v", b)
+	}
+}
+
+func TestCloseOrder(t *testing.T) {
+	c, srv, last := newTestClient(t)
+	defer srv.Close()
+
+	open := types.Order{Symbol: symbol, ID: "order1", Side: types.OrderSideBuy, Type: types.OrderTypeLimit,
+		Status: types.OrderStatusNew, Qty: 0.5, OpenPrice: 15500, CloseOrderID: "order9"}
+	if _, err := c.CloseOrder(open); !errors.Is(err, exerr.ErrInvalidOrder) {
+		t.Errorf("Expect the order not filled, got %v", err)
+	}
+
+	open.Status = types.OrderStatusFilled
+	o, err := c.CloseOrder(open)
+	if err != nil || o.ID != "order9" || o.Side != types.OrderSideSell || o.OpenOrderID != "order1" || o.RefID != "1001" {
+		t.Fatalf("Unexpected order: %+v, %v", o, err)
+	}
+	if _, params := last(); params["side"] != "sell" || params["type"] != "market" || params["amount"] != "0.5" ||
+		params["client_order_id"] != "order9" {
+		t.Errorf("Unexpected parameters: %v", params)
 	}
 }
@@ -1 +1,228 @@
 package set
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/tonkla/autotp/exchange/exerr"
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+// ErrReadOnly is returned by the trading methods, SET is a read-only exchange that rejects every order
+var ErrReadOnly = exerr.New("SET", 0, "read-only exchange", exerr.ErrRejected)
+
+type Client struct {
+	baseURL string
+}
+
+// NewClient returns SET client, which reads the market data of the Thai equities from the SET website
+func NewClient() Client {
+	return Client{
+		baseURL: "https://www.set.or.th/api/set",
+	}
+}
+
+func (c Client) stockURL(symbol string, path string) string {
+	return fmt.Sprintf("%s/stock/%s/%s", c.baseURL, url.PathEscape(strings.ToUpper(symbol)), path)
+}
+
+// toMillis returns the time in milliseconds of the ISO 8601 time, e.g. 2021-10-13T16:39:36+07:00
+func toMillis(s string) int64 {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0
+	}
+	return tm.UnixMilli()
+}
+
+// Public APIs -----------------------------------------------------------------
+
+// GetTicker returns the latest ticker
+func (c Client) GetTicker(symbol string) *t.Ticker {
+	data, err := h.Get(context.Background(), c.stockURL(symbol, "info"))
+	if err != nil {
+		h.Log("GetTicker", err)
+		return nil
+	}
+	return ParseTicker(gjson.ParseBytes(data))
+}
+
+// ParseTicker returns the ticker of the stock info
+func ParseTicker(r gjson.Result) *t.Ticker {
+	if !r.Get("last").Exists() {
+		return nil
+	}
+	return &t.Ticker{
+		Exchange: t.ExcSET,
+		Symbol:   r.Get("symbol").String(),
+		Price:    r.Get("last").Float(),
+		Qty:      r.Get("totalVolume").Float(),
+		Time:     toMillis(r.Get("marketDateTime").String()),
+	}
+}
+
+// GetOrderBook returns an order book (market depth), the best bids and offers
+func (c Client) GetOrderBook(symbol string, limit int) *t.OrderBook {
+	data, err := h.Get(context.Background(), c.stockURL(symbol, "bid-offer"))
+	if err != nil {
+		h.Log("GetOrderBook", err)
+		return nil
+	}
+	return ParseOrderBook(gjson.ParseBytes(data), strings.ToUpper(symbol), limit)
+}
+
+// ParseOrderBook returns the order book of the bids and offers, up to the limit of each side
+func ParseOrderBook(r gjson.Result, symbol string, limit int) *t.OrderBook {
+	parse := func(rs []gjson.Result, side string) []t.ExOrder {
+		var orders []t.ExOrder
+		for _, o := range rs {
+			if limit > 0 && len(orders) == limit {
+				break
+			}
+			orders = append(orders, t.ExOrder{
+				Symbol: symbol,
+				Side:   side,
+				Price:  o.Get("price").Float(),
+				Qty:    o.Get("volume").Float(),
+			})
+		}
+		return orders
+	}
+	return &t.OrderBook{
+		Symbol: symbol,
+		Bids:   parse(r.Get("bids").Array(), t.OrderSideBuy),
+		Asks:   parse(r.Get("offers").Array(), t.OrderSideSell),
+	}
+}
+
+// GetHistoricalPrices returns daily historical prices in a format of k-lines/candlesticks,
+// the other timeframes are not available
+func (c Client) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
+	if timeframe != "1d" {
+		h.Log("GetHistoricalPrices", fmt.Sprintf("SET: no %s prices", timeframe))
+		return nil
+	}
+	data, err := h.Get(context.Background(), c.stockURL(symbol, "historical-trading"))
+	if err != nil {
+		h.Log("GetHistoricalPrices", err)
+		return nil
+	}
+	prices := ParseHistoricalPrices(gjson.ParseBytes(data), strings.ToUpper(symbol))
+	if limit > 0 && len(prices) > limit {
+		prices = prices[len(prices)-limit:]
+	}
+	return prices
+}
+
+// ParseHistoricalPrices returns the daily prices of the historical trading, the oldest first
+func ParseHistoricalPrices(r gjson.Result, symbol string) []t.HistoricalPrice {
+	var prices []t.HistoricalPrice
+	for _, d := range r.Array() {
+		p := t.HistoricalPrice{
+			Symbol: symbol,
+			Time:   toMillis(d.Get("date").String()),
+			Open:   d.Get("open").Float(),
+			High:   d.Get("high").Float(),
+			Low:    d.Get("low").Float(),
+			Close:  d.Get("close").Float(),
+		}
+		// No trade on the day
+		if p.Time == 0 || p.Close == 0 {
+			continue
+		}
+		prices = append(prices, p)
+	}
+	// The latest day comes first
+	for i, j := 0, len(prices)-1; i < j; i, j = i+1, j-1 {
+		prices[i], prices[j] = prices[j], prices[i]
+	}
+	return prices
+}
+
+// Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1w", limit)
+}
+
+// Get1dHistoricalPrices returns '1d' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1dHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1d", limit)
+}
+
+// Get4hHistoricalPrices returns '4h' historical prices in a format of k-lines/candlesticks
+func (c Client) Get4hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "4h", limit)
+}
+
+// Get1hHistoricalPrices returns '1h' historical prices in a format of k-lines/candlesticks
+func (c Client) Get1hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1h", limit)
+}
+
+// Get15mHistoricalPrices returns '15m' historical prices in a format of k-lines/candlesticks
+func (c Client) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "15m", limit)
+}
+
+// Private APIs ----------------------------------------------------------------
+
+// CountOpenOrders returns ErrReadOnly
+func (c Client) CountOpenOrders(symbol string) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// GetOrder returns ErrReadOnly
+func (c Client) GetOrder(o t.Order) (*t.Order, error) {
+	return nil, ErrReadOnly
+}
+
+// GetOpenOrders returns no order
+func (c Client) GetOpenOrders(symbol string) []t.Order {
+	return nil
+}
+
+// GetTradeList returns ErrReadOnly
+func (c Client) GetTradeList(symbol string, limit int, startTime int, endTime int) ([]t.TradeOrder, error) {
+	return nil, ErrReadOnly
+}
+
+// GetAllOrders returns no order
+func (c Client) GetAllOrders(symbol string, limit int, startTime int, endTime int) []t.Order {
+	return nil
+}
+
+// GetCommission returns no commission
+func (c Client) GetCommission(symbol string, orderRefID string) *float64 {
+	return nil
+}
+
+// OpenLimitOrder returns ErrReadOnly
+func (c Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
+	return nil, ErrReadOnly
+}
+
+// OpenMarketOrder returns ErrReadOnly
+func (c Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
+	return nil, ErrReadOnly
+}
+
+// OpenStopOrder returns ErrReadOnly
+func (c Client) OpenStopOrder(o t.Order) (*t.Order, error) {
+	return nil, ErrReadOnly
+}
+
+// CancelOrder returns ErrReadOnly
+func (c Client) CancelOrder(o t.Order) (*t.Order, error) {
+	return nil, ErrReadOnly
+}
+
+// CloseOrder returns ErrReadOnly
+func (c Client) CloseOrder(o t.Order) (*t.Order, error) {
+	return nil, ErrReadOnly
+}
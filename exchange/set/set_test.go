@@ -0,0 +1,92 @@
+package set
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/tonkla/autotp/exchange/exerr"
+	"github.com/tonkla/autotp/types"
+)
+
+const symbol = "PTT"
+
+// newTestClient returns the client of a test server, which serves the saved pages in testdata
+func newTestClient() (Client, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Dir(r.URL.Path) != "/stock/"+symbol {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, err := os.ReadFile("testdata/" + path.Base(r.URL.Path) + ".json")
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}))
+	c := NewClient()
+	c.baseURL = srv.URL
+	return c, srv
+}
+
+func TestGetTicker(t *testing.T) {
+	c, srv := newTestClient()
+	defer srv.Close()
+	ticker := c.GetTicker("ptt")
+	if ticker == nil || ticker.Exchange != types.ExcSET || ticker.Symbol != symbol || ticker.Price != 37 ||
+		ticker.Time != 1634117976000 {
+		t.Errorf("Unexpected ticker: %+v", ticker)
+	}
+}
+
+func TestGetHistoricalPrices(t *testing.T) {
+	c, srv := newTestClient()
+	defer srv.Close()
+	prices := c.Get1dHistoricalPrices(symbol, 2)
+	if len(prices) != 2 {
+		t.Fatalf("Expect 2 days, got %d", len(prices))
+	}
+	// The oldest day first, at the midnight of Bangkok
+	if p := prices[1]; p.Time != 1634058000000 || p.Open != 36.75 || p.High != 37.25 || p.Low != 36.5 || p.Close != 37 {
+		t.Errorf("Unexpected price: %+v", p)
+	}
+	if prices[0].Close != 36.75 {
+		t.Errorf("Unexpected price: %+v", prices[0])
+	}
+	if prices := c.Get1hHistoricalPrices(symbol, 2); prices != nil {
+		t.Errorf("Expect no hourly prices, got %+v", prices)
+	}
+}
+
+func TestGetOrderBook(t *testing.T) {
+	c, srv := newTestClient()
+	defer srv.Close()
+	book := c.GetOrderBook(symbol, 2)
+	if book == nil || len(book.Bids) != 2 || len(book.Asks) != 2 {
+		t.Fatalf("Unexpected order book: %+v", book)
+	}
+	if book.Bids[0].Price != 37 || book.Bids[0].Qty != 1894300 || book.Asks[1].Price != 37.5 ||
+		book.Asks[1].Side != types.OrderSideSell {
+		t.Errorf("Unexpected order book: %+v", book)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	c := NewClient()
+	o := types.Order{Symbol: symbol, Side: types.OrderSideBuy, Type: types.OrderTypeLimit, Qty: 100, OpenPrice: 37}
+	if _, err := c.OpenLimitOrder(o); err != ErrReadOnly {
+		t.Errorf("Expect ErrReadOnly, got %v", err)
+	}
+	if _, err := c.OpenMarketOrder(o); err != ErrReadOnly {
+		t.Errorf("Expect ErrReadOnly, got %v", err)
+	}
+	if _, err := c.CancelOrder(o); err != ErrReadOnly {
+		t.Errorf("Expect ErrReadOnly, got %v", err)
+	}
+	if !exerr.IsRejected(ErrReadOnly) {
+		t.Error("Expect the orders rejected")
+	}
+}
@@ -0,0 +1,640 @@
+package sim
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/tonkla/autotp/exchange/exerr"
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+type order struct {
+	t.Order
+	triggered bool
+	// extreme is the best price since the trailing stop order has been activated
+	extreme float64
+}
+
+type position struct {
+	qty   float64
+	entry float64
+}
+
+// Client is a simulated exchange that matches orders against a price feed
+type Client struct {
+	symbol    string
+	timeframe string
+	makerFee  float64
+	takerFee  float64
+
+	price  float64
+	time   int64
+	series map[string][]t.HistoricalPrice
+
+	orders []*order
+	open   []*order
+	byID   map[string]*order
+	byRef  map[string]*order
+	trades []t.TradeOrder
+	lastID int64
+
+	positions map[string]*position
+}
+
+// NewClient returns a simulated exchange of the symbol, its price feed is made of ticks and bars
+// of the base timeframe. Fees are rates of the quote value, e.g. 0.001 is 0.1%.
+func NewClient(symbol string, timeframe string, makerFee float64, takerFee float64) *Client {
+	return &Client{
+		symbol:    symbol,
+		timeframe: timeframe,
+		makerFee:  makerFee,
+		takerFee:  takerFee,
+		series:    map[string][]t.HistoricalPrice{timeframe: nil},
+		byID:      make(map[string]*order),
+		byRef:     make(map[string]*order),
+		positions: make(map[string]*position),
+	}
+}
+
+// LoadHistory preloads closed bars of the base timeframe, used as historical prices before the first tick
+func (c *Client) LoadHistory(prices []t.HistoricalPrice) {
+	bars := make([]t.HistoricalPrice, 0, len(prices))
+	for _, p := range prices {
+		p.Symbol = c.symbol
+		bars = append(bars, p)
+	}
+	c.series = map[string][]t.HistoricalPrice{c.timeframe: bars}
+	if len(bars) > 0 {
+		c.price = bars[len(bars)-1].Close
+	}
+}
+
+// Restore puts the orders that were placed on the simulated exchange before back into it,
+// filled orders are recorded as trades
+func (c *Client) Restore(orders []t.Order) {
+	for _, o := range orders {
+		if o.RefID == "" || c.byRef[o.RefID] != nil {
+			continue
+		}
+		if id, err := strconv.ParseInt(o.RefID, 10, 64); err == nil && id > c.lastID {
+			c.lastID = id
+		}
+
+		so := &order{Order: o}
+		c.orders = append(c.orders, so)
+		c.byRef[so.RefID] = so
+		if so.ID != "" {
+			c.byID[so.ID] = so
+		}
+
+		if o.Status == t.OrderStatusNew {
+			c.open = append(c.open, so)
+		} else if o.Status == t.OrderStatusFilled {
+			c.trades = append(c.trades, t.TradeOrder{
+				Symbol:     o.Symbol,
+				RefID:      o.RefID,
+				Price:      o.OpenPrice,
+				Qty:        o.Qty,
+				QuoteQty:   o.OpenPrice * o.Qty,
+				Commission: o.Commission,
+				Time:       o.UpdateTime,
+				IsBuyer:    o.Side == t.OrderSideBuy,
+			})
+		}
+	}
+}
+
+// Tick moves the market to the ticker price, then matches the open orders
+// against the price path from the previous ticker price
+func (c *Client) Tick(ticker t.Ticker) {
+	if ticker.Price <= 0 {
+		return
+	}
+
+	for tf := range c.series {
+		c.series[tf] = appendTick(c.series[tf], c.symbol, tf, ticker)
+	}
+
+	prev := c.price
+	if prev == 0 {
+		prev = ticker.Price
+	}
+	c.price = ticker.Price
+	c.time = ticker.Time
+
+	open := c.open[:0]
+	for _, o := range c.open {
+		if o.Status == t.OrderStatusNew {
+			c.match(o, prev, ticker.Price)
+		}
+		if o.Status == t.OrderStatusNew {
+			open = append(open, o)
+		}
+	}
+	c.open = open
+}
+
+func appendTick(bars []t.HistoricalPrice, symbol string, tf string, ticker t.Ticker) []t.HistoricalPrice {
+	openTime := h.TfOpenTime(tf, ticker.Time)
+	if n := len(bars); n > 0 && bars[n-1].Time == openTime {
+		b := &bars[n-1]
+		b.High = math.Max(b.High, ticker.Price)
+		b.Low = math.Min(b.Low, ticker.Price)
+		b.Close = ticker.Price
+		return bars
+	}
+	return append(bars, t.HistoricalPrice{
+		Symbol: symbol,
+		Time:   openTime,
+		Open:   ticker.Price,
+		High:   ticker.Price,
+		Low:    ticker.Price,
+		Close:  ticker.Price,
+	})
+}
+
+// resample aggregates bars of the base timeframe into bars of the timeframe
+func resample(bars []t.HistoricalPrice, tf string) []t.HistoricalPrice {
+	var result []t.HistoricalPrice
+	for _, b := range bars {
+		openTime := h.TfOpenTime(tf, b.Time)
+		if n := len(result); n > 0 && result[n-1].Time == openTime {
+			r := &result[n-1]
+			r.High = math.Max(r.High, b.High)
+			r.Low = math.Min(r.Low, b.Low)
+			r.Close = b.Close
+			continue
+		}
+		b.Time = openTime
+		result = append(result, b)
+	}
+	return result
+}
+
+// match fills the order when its price lies on the path between the two prices
+func (c *Client) match(o *order, from float64, to float64) {
+	low, high := math.Min(from, to), math.Max(from, to)
+
+	if o.Type == t.OrderTypeFTSM {
+		c.trail(o, from, to)
+		return
+	}
+
+	if o.Type != t.OrderTypeLimit && !o.triggered {
+		if !isTriggered(o.Order, low, high) {
+			return
+		}
+		o.triggered = true
+		if o.Type == t.OrderTypeFSLM || o.Type == t.OrderTypeFTPM {
+			o.OpenPrice = o.StopPrice
+			c.fill(o, o.StopPrice, false)
+			return
+		}
+		// The rest of the path starts at the stop price
+		low, high = math.Min(o.StopPrice, to), math.Max(o.StopPrice, to)
+	}
+
+	if (o.Side == t.OrderSideBuy && low <= o.OpenPrice) || (o.Side == t.OrderSideSell && high >= o.OpenPrice) {
+		c.fill(o, o.OpenPrice, true)
+	}
+}
+
+// trail activates the trailing stop order at its stop price, then follows the best price of the path,
+// and fills the order at market when the price has moved back by the callback rate
+func (c *Client) trail(o *order, from float64, to float64) {
+	if !o.triggered {
+		if !isTriggered(o.Order, math.Min(from, to), math.Max(from, to)) {
+			return
+		}
+		o.triggered = true
+		o.extreme = o.StopPrice
+		// The rest of the path starts at the activation price
+		from = o.StopPrice
+	}
+
+	// The path is straight, its best price is at one of its ends
+	var stop float64
+	if o.Side == t.OrderSideSell {
+		o.extreme = math.Max(o.extreme, math.Max(from, to))
+		stop = o.extreme * (1 - o.CallbackRate/100)
+		if to > stop {
+			return
+		}
+	} else {
+		o.extreme = math.Min(o.extreme, math.Min(from, to))
+		stop = o.extreme * (1 + o.CallbackRate/100)
+		if to < stop {
+			return
+		}
+	}
+	o.OpenPrice = stop
+	c.fill(o, stop, false)
+}
+
+// isTriggered checks the stop price of the stop order has been reached
+func isTriggered(o t.Order, low float64, high float64) bool {
+	switch o.Type {
+	case t.OrderTypeSL, t.OrderTypeFSL, t.OrderTypeFSLM:
+		if o.Side == t.OrderSideBuy {
+			return high >= o.StopPrice
+		}
+		return low <= o.StopPrice
+	case t.OrderTypeTP, t.OrderTypeFTP, t.OrderTypeFTPM, t.OrderTypeFTSM:
+		// The trailing stop is activated in profit, like a TP order
+		if o.Side == t.OrderSideBuy {
+			return low <= o.StopPrice
+		}
+		return high >= o.StopPrice
+	}
+	return false
+}
+
+func (c *Client) fill(o *order, price float64, isMaker bool) {
+	fee := c.takerFee
+	if isMaker {
+		fee = c.makerFee
+	}
+	o.Status = t.OrderStatusFilled
+	o.UpdateTime = c.time
+	o.Commission = price * o.Qty * fee
+	c.closeList(o, t.OrderStatusExpired)
+	c.trades = append(c.trades, t.TradeOrder{
+		Symbol:      o.Symbol,
+		RefID:       o.RefID,
+		Price:       price,
+		Qty:         o.Qty,
+		QuoteQty:    price * o.Qty,
+		Commission:  o.Commission,
+		RealizedPnL: c.updatePosition(o.Order, price),
+		Time:        c.time,
+		IsBuyer:     o.Side == t.OrderSideBuy,
+		IsMaker:     isMaker,
+	})
+}
+
+// updatePosition adds the filled futures order into its position, and returns the realized PnL
+func (c *Client) updatePosition(o t.Order, price float64) float64 {
+	if o.PosSide == "" {
+		return 0
+	}
+	pos, ok := c.positions[o.PosSide]
+	if !ok {
+		pos = &position{}
+		c.positions[o.PosSide] = pos
+	}
+
+	isOpening := (o.PosSide == t.OrderPosSideLong && o.Side == t.OrderSideBuy) ||
+		(o.PosSide == t.OrderPosSideShort && o.Side == t.OrderSideSell)
+	if isOpening {
+		pos.entry = (pos.entry*pos.qty + price*o.Qty) / (pos.qty + o.Qty)
+		pos.qty += o.Qty
+		return 0
+	}
+
+	qty := math.Min(o.Qty, pos.qty)
+	pos.qty -= qty
+	if o.PosSide == t.OrderPosSideLong {
+		return (price - pos.entry) * qty
+	}
+	return (pos.entry - price) * qty
+}
+
+// closeList closes the other orders of the order list of the order, with the status
+func (c *Client) closeList(o *order, status string) {
+	if o.ListID == "" {
+		return
+	}
+	for _, lo := range c.open {
+		if lo != o && lo.ListID == o.ListID && lo.Status == t.OrderStatusNew {
+			lo.Status = status
+			lo.UpdateTime = c.time
+		}
+	}
+}
+
+func (c *Client) place(o t.Order) *order {
+	c.lastID++
+	o.RefID = strconv.FormatInt(c.lastID, 10)
+	o.Status = t.OrderStatusNew
+	o.OpenTime = c.time
+	o.UpdateTime = c.time
+	so := &order{Order: o}
+	c.orders = append(c.orders, so)
+	c.open = append(c.open, so)
+	c.byRef[so.RefID] = so
+	if so.ID != "" {
+		c.byID[so.ID] = so
+	}
+	return so
+}
+
+func (c *Client) find(o t.Order) *order {
+	if o.RefID != "" {
+		return c.byRef[o.RefID]
+	}
+	return c.byID[o.ID]
+}
+
+// GetTicker returns the latest ticker
+func (c *Client) GetTicker(symbol string) *t.Ticker {
+	if symbol != c.symbol || c.price == 0 {
+		return nil
+	}
+	return &t.Ticker{
+		Symbol: c.symbol,
+		Price:  c.price,
+		Time:   c.time,
+	}
+}
+
+// GetOrderBook returns an order book (market depth), the simulation has only one level at the latest price
+func (c *Client) GetOrderBook(symbol string, limit int) *t.OrderBook {
+	if symbol != c.symbol || c.price == 0 {
+		return nil
+	}
+	return &t.OrderBook{
+		Symbol: symbol,
+		Bids:   []t.ExOrder{{Symbol: symbol, Side: t.OrderSideBuy, Price: c.price}},
+		Asks:   []t.ExOrder{{Symbol: symbol, Side: t.OrderSideSell, Price: c.price}},
+	}
+}
+
+// GetHistoricalPrices returns historical prices in a format of k-lines/candlesticks,
+// it returns nil until there are enough bars, like strategies expect from a live exchange
+func (c *Client) GetHistoricalPrices(symbol string, timeframe string, limit int) []t.HistoricalPrice {
+	if symbol != c.symbol || h.TfDuration(timeframe) < h.TfDuration(c.timeframe) {
+		return nil
+	}
+	bars, ok := c.series[timeframe]
+	if !ok {
+		bars = resample(c.series[c.timeframe], timeframe)
+		c.series[timeframe] = bars
+	}
+	if len(bars) < limit {
+		return nil
+	}
+	prices := make([]t.HistoricalPrice, limit)
+	copy(prices, bars[len(bars)-limit:])
+	return prices
+}
+
+// Get1wHistoricalPrices returns '1w' historical prices in a format of k-lines/candlesticks
+func (c *Client) Get1wHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1w", limit)
+}
+
+// Get1dHistoricalPrices returns '1d' historical prices in a format of k-lines/candlesticks
+func (c *Client) Get1dHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1d", limit)
+}
+
+// Get4hHistoricalPrices returns '4h' historical prices in a format of k-lines/candlesticks
+func (c *Client) Get4hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "4h", limit)
+}
+
+// Get1hHistoricalPrices returns '1h' historical prices in a format of k-lines/candlesticks
+func (c *Client) Get1hHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "1h", limit)
+}
+
+// Get15mHistoricalPrices returns '15m' historical prices in a format of k-lines/candlesticks
+func (c *Client) Get15mHistoricalPrices(symbol string, limit int) []t.HistoricalPrice {
+	return c.GetHistoricalPrices(symbol, "15m", limit)
+}
+
+// CountOpenOrders returns a number of open orders
+func (c *Client) CountOpenOrders(symbol string) (int, error) {
+	return len(c.GetOpenOrders(symbol)), nil
+}
+
+// GetOpenOrders returns open orders
+func (c *Client) GetOpenOrders(symbol string) []t.Order {
+	var orders []t.Order
+	for _, o := range c.open {
+		if o.Symbol == symbol && o.Status == t.OrderStatusNew {
+			orders = append(orders, o.Order)
+		}
+	}
+	return orders
+}
+
+// GetAllOrders returns all account orders; active, canceled, or filled
+func (c *Client) GetAllOrders(symbol string, limit int, startTime int, endTime int) []t.Order {
+	var orders []t.Order
+	for _, o := range c.orders {
+		if o.Symbol == symbol && inTimeRange(o.OpenTime, startTime, endTime) {
+			orders = append(orders, o.Order)
+		}
+	}
+	if limit > 0 && len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders
+}
+
+// GetTradeList returns trades list for a specified symbol
+func (c *Client) GetTradeList(symbol string, limit int, startTime int, endTime int) ([]t.TradeOrder, error) {
+	var trades []t.TradeOrder
+	for _, tr := range c.trades {
+		if tr.Symbol == symbol && inTimeRange(tr.Time, startTime, endTime) {
+			trades = append(trades, tr)
+		}
+	}
+	if limit > 0 && len(trades) > limit {
+		trades = trades[len(trades)-limit:]
+	}
+	return trades, nil
+}
+
+func inTimeRange(tm int64, startTime int, endTime int) bool {
+	return (startTime <= 0 || tm >= int64(startTime)) && (endTime <= 0 || tm <= int64(endTime))
+}
+
+// GetCommission returns order commission
+func (c *Client) GetCommission(symbol string, orderRefID string) *float64 {
+	so := c.byRef[orderRefID]
+	if so == nil || so.Symbol != symbol || so.Status != t.OrderStatusFilled {
+		return nil
+	}
+	commission := so.Commission
+	return &commission
+}
+
+// GetOrder returns the order by its IDs
+func (c *Client) GetOrder(o t.Order) (*t.Order, error) {
+	so := c.find(o)
+	if so == nil {
+		return nil, exerr.New("GetOrder", 0, "Order does not exist", exerr.ErrOrderNotFound)
+	}
+	o.RefID = so.RefID
+	o.Status = so.Status
+	o.UpdateTime = so.UpdateTime
+	if so.Status == t.OrderStatusFilled {
+		o.OpenPrice = so.OpenPrice
+	}
+	return &o, nil
+}
+
+// OpenLimitOrder opens a limit order, it will be matched from the next tick. A marketable order,
+// e.g. a BUY at or above the latest price, is filled immediately at the latest price as a taker.
+func (c *Client) OpenLimitOrder(o t.Order) (*t.Order, error) {
+	if o.Type != t.OrderTypeLimit {
+		return nil, nil
+	}
+	if o.Qty <= 0 || o.OpenPrice <= 0 {
+		return nil, exerr.New("OpenLimitOrder", 0, "Invalid quantity or price", exerr.ErrInvalidOrder)
+	}
+	isMarketable := c.price > 0 &&
+		((o.Side == t.OrderSideBuy && o.OpenPrice >= c.price) || (o.Side == t.OrderSideSell && o.OpenPrice <= c.price))
+	if isMarketable {
+		o.OpenPrice = c.price
+	}
+	so := c.place(o)
+	if isMarketable {
+		c.fill(so, c.price, false)
+	}
+	return &so.Order, nil
+}
+
+// OpenMarketOrder opens a market order, it is filled immediately at the latest price
+func (c *Client) OpenMarketOrder(o t.Order) (*t.Order, error) {
+	if o.Type != t.OrderTypeMarket {
+		return nil, nil
+	}
+	if o.Qty <= 0 || c.price == 0 {
+		return nil, exerr.New("OpenMarketOrder", 0, "Invalid quantity or no market price", exerr.ErrInvalidOrder)
+	}
+	o.OpenPrice = c.price
+	so := c.place(o)
+	c.fill(so, c.price, false)
+	return &so.Order, nil
+}
+
+// OpenStopOrder opens a stop order, it will be triggered from the next tick
+func (c *Client) OpenStopOrder(o t.Order) (*t.Order, error) {
+	if o.Type == t.OrderTypeLimit || o.Type == t.OrderTypeMarket {
+		return nil, nil
+	}
+	if o.Type == t.OrderTypeFTSM {
+		return c.openTrailingStop(o)
+	}
+	isMarket := o.Type == t.OrderTypeFSLM || o.Type == t.OrderTypeFTPM
+	if o.Qty <= 0 || (o.OpenPrice <= 0 && !isMarket) || o.StopPrice <= 0 {
+		return nil, exerr.New("OpenStopOrder", 0, "Invalid quantity or price", exerr.ErrInvalidOrder)
+	}
+	if isTriggered(o, c.price, c.price) {
+		return nil, exerr.New("OpenStopOrder", 0, "Order would immediately trigger", exerr.ErrWouldTrigger)
+	}
+	so := c.place(o)
+	return &so.Order, nil
+}
+
+// openTrailingStop opens a trailing stop order, it is activated at the latest price when it has no activation price
+func (c *Client) openTrailingStop(o t.Order) (*t.Order, error) {
+	if o.Qty <= 0 || o.CallbackRate < 0.1 || o.CallbackRate > 5 {
+		return nil, exerr.New("OpenStopOrder", 0, "Invalid quantity or callback rate", exerr.ErrInvalidOrder)
+	}
+	if o.StopPrice > 0 && isTriggered(o, c.price, c.price) {
+		return nil, exerr.New("OpenStopOrder", 0, "Order would immediately trigger", exerr.ErrWouldTrigger)
+	}
+	so := c.place(o)
+	if o.StopPrice <= 0 {
+		so.triggered = true
+		so.extreme = c.price
+	}
+	return &so.Order, nil
+}
+
+// OpenOCOOrder opens an OCO order list of the TP and the SL orders, when one of them is filled the other expires
+func (c *Client) OpenOCOOrder(tp t.Order, sl t.Order) ([]t.Order, error) {
+	if tp.ListID == "" || tp.Side != sl.Side || tp.Qty != sl.Qty {
+		return nil, exerr.New("OpenOCOOrder", 0, "Invalid order list", exerr.ErrInvalidOrder)
+	}
+	if tp.Qty <= 0 || tp.OpenPrice <= 0 || sl.OpenPrice <= 0 || sl.StopPrice <= 0 {
+		return nil, exerr.New("OpenOCOOrder", 0, "Invalid quantity or price", exerr.ErrInvalidOrder)
+	}
+	if isTriggered(sl, c.price, c.price) || (tp.Side == t.OrderSideSell && tp.OpenPrice <= c.price) ||
+		(tp.Side == t.OrderSideBuy && tp.OpenPrice >= c.price) {
+		return nil, exerr.New("OpenOCOOrder", 0, "The relationship of the prices for the orders is not correct",
+			exerr.ErrInvalidOrder)
+	}
+	sl.ListID = tp.ListID
+	tpo, slo := c.place(tp), c.place(sl)
+	// The TP order is a limit maker order, it is matched at its price without a stop price
+	tpo.triggered = true
+	return []t.Order{tpo.Order, slo.Order}, nil
+}
+
+// CancelOrder cancels an order
+func (c *Client) CancelOrder(o t.Order) (*t.Order, error) {
+	so := c.find(o)
+	if so == nil || so.Status != t.OrderStatusNew {
+		return nil, exerr.New("CancelOrder", 0, "Unknown order sent", exerr.ErrOrderNotFound)
+	}
+	so.Status = t.OrderStatusCanceled
+	so.UpdateTime = c.time
+	// Canceling an order of a list cancels the whole list
+	c.closeList(so, t.OrderStatusCanceled)
+	o.Status = so.Status
+	o.UpdateTime = so.UpdateTime
+	return &o, nil
+}
+
+// OpenBatchOrders opens the limit and the stop orders one by one, the results are in the order of the orders
+func (c *Client) OpenBatchOrders(orders []t.Order) ([]t.Order, []error) {
+	results := make([]t.Order, len(orders))
+	errs := make([]error, len(orders))
+	for i, o := range orders {
+		var exo *t.Order
+		if o.Type == t.OrderTypeLimit {
+			exo, errs[i] = c.OpenLimitOrder(o)
+		} else if o.Type != t.OrderTypeMarket {
+			exo, errs[i] = c.OpenStopOrder(o)
+		}
+		if exo != nil {
+			results[i] = *exo
+		} else if errs[i] == nil {
+			errs[i] = exerr.New("OpenBatchOrders", 0, o.Type+" is not supported", exerr.ErrInvalidOrder)
+		}
+	}
+	return results, errs
+}
+
+// CancelBatchOrders cancels the orders one by one, the results are in the order of the orders
+func (c *Client) CancelBatchOrders(orders []t.Order) ([]t.Order, []error) {
+	results := make([]t.Order, len(orders))
+	errs := make([]error, len(orders))
+	for i, o := range orders {
+		var exo *t.Order
+		if exo, errs[i] = c.CancelOrder(o); exo != nil {
+			results[i] = *exo
+		}
+	}
+	return results, errs
+}
+
+// CloseOrder closes a filled order with an opposite market order, and returns the market order.
+// The market order is of the close order ID of the order, when it has been given.
+func (c *Client) CloseOrder(o t.Order) (*t.Order, error) {
+	so := c.find(o)
+	if so == nil || so.Status != t.OrderStatusFilled {
+		return nil, exerr.New("CloseOrder", 0, "Unknown order sent", exerr.ErrOrderNotFound)
+	}
+	id := o.CloseOrderID
+	if id == "" {
+		id = h.GenID()
+	}
+	return c.OpenMarketOrder(t.Order{
+		ID:          id,
+		BotID:       o.BotID,
+		Exchange:    o.Exchange,
+		Symbol:      o.Symbol,
+		Side:        h.Reverse(so.Side),
+		PosSide:     so.PosSide,
+		Type:        t.OrderTypeMarket,
+		Qty:         so.Qty,
+		OpenOrderID: so.ID,
+	})
+}
@@ -0,0 +1,310 @@
+package sim
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tonkla/autotp/types"
+)
+
+const (
+	symbol = "BNBUSDT"
+	t0     = 1634083200000
+)
+
+func newClient(price float64) *Client {
+	c := NewClient(symbol, "1m", 0.001, 0.002)
+	c.Tick(types.Ticker{Symbol: symbol, Price: price, Time: t0})
+	return c
+}
+
+func tick(c *Client, price float64, sec int64) {
+	c.Tick(types.Ticker{Symbol: symbol, Price: price, Time: t0 + sec*1000})
+}
+
+func status(c *Client, o *types.Order) string {
+	exo, err := c.GetOrder(*o)
+	if err != nil {
+		return ""
+	}
+	return exo.Status
+}
+
+func TestLimitOrder(t *testing.T) {
+	c := newClient(100)
+
+	buy, err := c.OpenLimitOrder(types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Qty: 2, OpenPrice: 95})
+	if err != nil || buy.RefID == "" || buy.Status != types.OrderStatusNew {
+		t.Fatal(err, buy)
+	}
+
+	tick(c, 96, 1)
+	if status(c, buy) != types.OrderStatusNew {
+		t.Error("The BUY order must not be filled above its price")
+	}
+
+	// The path 96 -> 98 does not touch 95, but 98 -> 94 does
+	tick(c, 98, 2)
+	tick(c, 94, 3)
+	if status(c, buy) != types.OrderStatusFilled {
+		t.Error("The BUY order must be filled when the price passes through it")
+	}
+
+	trades, _ := c.GetTradeList(symbol, 10, 0, 0)
+	if len(trades) != 1 || trades[0].Price != 95 || !trades[0].IsMaker || !trades[0].IsBuyer {
+		t.Errorf("Unexpected trades: %+v", trades)
+	}
+	commission := c.GetCommission(symbol, buy.RefID)
+	if commission == nil || math.Abs(*commission-95*2*0.001) > 1e-9 {
+		t.Errorf("Unexpected maker commission: %v", commission)
+	}
+	if n, _ := c.CountOpenOrders(symbol); n != 0 {
+		t.Errorf("Expect no open orders, got %d", n)
+	}
+}
+
+func TestMarketableLimitOrder(t *testing.T) {
+	c := newClient(100)
+
+	// A BUY above the market is filled at once at the market price, as a taker
+	buy, err := c.OpenLimitOrder(types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Qty: 2, OpenPrice: 105})
+	if err != nil || buy.Status != types.OrderStatusFilled || buy.OpenPrice != 100 {
+		t.Fatal(err, buy)
+	}
+	if math.Abs(buy.Commission-100*2*0.002) > 1e-9 {
+		t.Errorf("Unexpected taker commission: %f", buy.Commission)
+	}
+
+	// A SELL at the market price is marketable too
+	sell, _ := c.OpenLimitOrder(types.Order{ID: "2", Symbol: symbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeLimit, Qty: 1, OpenPrice: 100})
+	trades, _ := c.GetTradeList(symbol, 10, 0, 0)
+	if sell.Status != types.OrderStatusFilled || len(trades) != 2 || trades[0].IsMaker || trades[1].IsMaker {
+		t.Errorf("Unexpected trades: %+v", trades)
+	}
+	if n, _ := c.CountOpenOrders(symbol); n != 0 {
+		t.Errorf("Expect no open orders, got %d", n)
+	}
+}
+
+func TestStopOrder(t *testing.T) {
+	c := newClient(100)
+
+	// The SL SELL of a LONG triggers below the market
+	sl := types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideSell, Type: types.OrderTypeSL,
+		Qty: 1, StopPrice: 97, OpenPrice: 96}
+	// The TP SELL of a LONG triggers above the market
+	tp := types.Order{ID: "2", Symbol: symbol, Side: types.OrderSideSell, Type: types.OrderTypeTP,
+		Qty: 1, StopPrice: 103, OpenPrice: 102}
+
+	slo, err := c.OpenStopOrder(sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpo, err := c.OpenStopOrder(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A gap through both the stop and the limit prices still fills at the limit price
+	tick(c, 95, 1)
+	if status(c, slo) != types.OrderStatusFilled {
+		t.Error("The SL order must be triggered and filled")
+	}
+	if status(c, tpo) != types.OrderStatusNew {
+		t.Error("The TP order must not be triggered")
+	}
+
+	tick(c, 102.5, 2)
+	if status(c, tpo) != types.OrderStatusNew {
+		t.Error("The TP order must not be triggered below its stop price")
+	}
+	tick(c, 103, 3)
+	if status(c, tpo) != types.OrderStatusFilled {
+		t.Error("The TP order must be triggered and filled")
+	}
+
+	// The futures STOP BUY of a SHORT would trigger immediately below the market
+	_, err = c.OpenStopOrder(types.Order{ID: "3", Symbol: symbol, Side: types.OrderSideBuy,
+		PosSide: types.OrderPosSideShort, Type: types.OrderTypeFSL, Qty: 1, StopPrice: 100, OpenPrice: 101})
+	if err == nil {
+		t.Error("The order would immediately trigger")
+	}
+}
+
+func TestStopMarketOrder(t *testing.T) {
+	c := newClient(100)
+
+	slm := types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideSell, PosSide: types.OrderPosSideLong,
+		Type: types.OrderTypeFSLM, Qty: 1, StopPrice: 97}
+	slo, err := c.OpenStopOrder(slm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A market stop order fills at its stop price as a taker, even through a gap
+	tick(c, 94, 1)
+	exo, err := c.GetOrder(*slo)
+	if err != nil || exo.Status != types.OrderStatusFilled || exo.OpenPrice != 97 {
+		t.Fatal(err, exo)
+	}
+	trades, _ := c.GetTradeList(symbol, 10, 0, 0)
+	if len(trades) != 1 || trades[0].Price != 97 || trades[0].IsMaker {
+		t.Errorf("Unexpected trades: %+v", trades)
+	}
+}
+
+func TestTrailingStopOrder(t *testing.T) {
+	c := newClient(100)
+
+	if _, err := c.OpenStopOrder(types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeFTSM, Qty: 1, StopPrice: 99, CallbackRate: 2}); err == nil {
+		t.Error("Expect the trailing stop to be rejected, it would be activated immediately")
+	}
+
+	tso, err := c.OpenStopOrder(types.Order{ID: "2", Symbol: symbol, Side: types.OrderSideSell,
+		PosSide: types.OrderPosSideLong, Type: types.OrderTypeFTSM, Qty: 1, StopPrice: 105, CallbackRate: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not activated, the price has not reached the activation price
+	tick(c, 103, 1)
+	tick(c, 101, 2)
+	if status(c, tso) != types.OrderStatusNew {
+		t.Fatal("The trailing stop must not be activated below its activation price")
+	}
+
+	// Activated at 105, it follows the highest price of 110, then it is filled at 110 - 2%
+	tick(c, 110, 3)
+	tick(c, 108, 4)
+	if status(c, tso) != types.OrderStatusNew {
+		t.Fatal("The trailing stop must not be filled within its callback rate")
+	}
+	tick(c, 107, 5)
+	exo, err := c.GetOrder(*tso)
+	if err != nil || exo.Status != types.OrderStatusFilled || math.Abs(exo.OpenPrice-107.8) > 1e-9 {
+		t.Fatal(err, exo)
+	}
+
+	// Without an activation price, a BUY trailing stop follows the lowest price from the latest price
+	bso, err := c.OpenStopOrder(types.Order{ID: "3", Symbol: symbol, Side: types.OrderSideBuy,
+		PosSide: types.OrderPosSideShort, Type: types.OrderTypeFTSM, Qty: 1, CallbackRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tick(c, 100, 6)
+	tick(c, 101.5, 7)
+	if exo, _ = c.GetOrder(*bso); exo.Status != types.OrderStatusFilled || math.Abs(exo.OpenPrice-101) > 1e-9 {
+		t.Errorf("Unexpected order: %+v", exo)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	c := newClient(100)
+
+	o, _ := c.OpenLimitOrder(types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeLimit, Qty: 1, OpenPrice: 110})
+	exo, err := c.CancelOrder(*o)
+	if err != nil || exo.Status != types.OrderStatusCanceled {
+		t.Fatal(err, exo)
+	}
+
+	tick(c, 111, 1)
+	if status(c, o) != types.OrderStatusCanceled {
+		t.Error("The canceled order must not be filled")
+	}
+	if _, err = c.CancelOrder(*o); err == nil {
+		t.Error("The order has been canceled")
+	}
+}
+
+func TestOCOOrder(t *testing.T) {
+	c := newClient(100)
+
+	tp := types.Order{ID: "tp", ListID: "list", Symbol: symbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeTP, Qty: 1, OpenPrice: 110, StopPrice: 109}
+	sl := types.Order{ID: "sl", ListID: "list", Symbol: symbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeSL, Qty: 1, OpenPrice: 94, StopPrice: 95}
+	if _, err := c.OpenOCOOrder(types.Order{ID: "tp", ListID: "list", Symbol: symbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeTP, Qty: 1, OpenPrice: 99}, sl); err == nil {
+		t.Error("The TP price must be above the market price")
+	}
+	orders, err := c.OpenOCOOrder(tp, sl)
+	if err != nil || len(orders) != 2 || orders[0].RefID == "" || orders[1].Status != types.OrderStatusNew {
+		t.Fatal(err, orders)
+	}
+
+	// The TP order is filled at its price, the SL order expires
+	tick(c, 110.5, 1)
+	if status(c, &orders[0]) != types.OrderStatusFilled || status(c, &orders[1]) != types.OrderStatusExpired {
+		t.Errorf("Expect TP filled and SL expired, got %s and %s", status(c, &orders[0]), status(c, &orders[1]))
+	}
+
+	// Canceling an order cancels the whole list
+	tp.ID, sl.ID, tp.ListID, sl.ListID, tp.OpenPrice = "tp2", "sl2", "list2", "list2", 120
+	orders, _ = c.OpenOCOOrder(tp, sl)
+	if _, err = c.CancelOrder(orders[1]); err != nil || status(c, &orders[0]) != types.OrderStatusCanceled {
+		t.Errorf("Expect the list canceled, got %v", err)
+	}
+	if n, _ := c.CountOpenOrders(symbol); n != 0 {
+		t.Errorf("Expect no open orders, got %d", n)
+	}
+}
+
+func TestFuturesPosition(t *testing.T) {
+	c := newClient(100)
+
+	o, err := c.OpenMarketOrder(types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideSell,
+		PosSide: types.OrderPosSideShort, Type: types.OrderTypeMarket, Qty: 2})
+	if err != nil || o.Status != types.OrderStatusFilled || o.OpenPrice != 100 {
+		t.Fatal(err, o)
+	}
+	if math.Abs(o.Commission-100*2*0.002) > 1e-9 {
+		t.Errorf("Unexpected taker commission: %f", o.Commission)
+	}
+
+	tick(c, 90, 1)
+	co, err := c.CloseOrder(*o)
+	if err != nil || co.Side != types.OrderSideBuy || co.PosSide != types.OrderPosSideShort || co.OpenPrice != 90 {
+		t.Fatal(err, co)
+	}
+
+	trades, _ := c.GetTradeList(symbol, 1, 0, 0)
+	if len(trades) != 1 || trades[0].RealizedPnL != 20 {
+		t.Errorf("Unexpected realized PnL: %+v", trades)
+	}
+}
+
+func TestGetHistoricalPrices(t *testing.T) {
+	c := NewClient(symbol, "1m", 0, 0)
+
+	var history []types.HistoricalPrice
+	for i := int64(0); i < 120; i++ {
+		p := float64(100 + i)
+		history = append(history, types.HistoricalPrice{Time: t0 + i*60000, Open: p, High: p + 1, Low: p - 1, Close: p})
+	}
+	c.LoadHistory(history)
+
+	if prices := c.GetHistoricalPrices(symbol, "1h", 3); prices != nil {
+		t.Error("Expect nil when there are not enough bars")
+	}
+
+	prices := c.GetHistoricalPrices(symbol, "1h", 2)
+	if len(prices) != 2 || prices[0].Open != 100 || prices[0].High != 160 || prices[0].Low != 99 ||
+		prices[1].Time != t0+3600000 || prices[1].Close != 219 {
+		t.Fatalf("Unexpected 1h prices: %+v", prices)
+	}
+
+	// A new tick opens the third 1h bar
+	tick(c, 300, 7200)
+	prices = c.GetHistoricalPrices(symbol, "1h", 3)
+	if len(prices) != 3 || prices[2].Open != 300 || prices[2].Time != t0+7200000 {
+		t.Errorf("Unexpected 1h prices: %+v", prices)
+	}
+	if c.GetHistoricalPrices(symbol, "1s", 1) != nil {
+		t.Error("Expect nil for a timeframe smaller than the base timeframe")
+	}
+}
@@ -0,0 +1,161 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+
+	t "github.com/tonkla/autotp/types"
+)
+
+// maxStreamOrders is the number of tracked orders, before the completed orders are pruned
+const maxStreamOrders = 1000
+
+// OrderStreamer is implemented by the clients that can push the order updates of the account
+type OrderStreamer interface {
+	StreamOrders(ctx context.Context, onUpdate func(t.OrderUpdate), connected func(bool))
+}
+
+// TradeTracker is implemented by the clients that know whether an order has been traded,
+// ok is false when the client cannot tell it
+type TradeTracker interface {
+	IsTraded(symbol string, orderRefID string) (traded bool, ok bool)
+}
+
+// streamOrder is an order tracked by its pushed updates
+type streamOrder struct {
+	t.Order
+	// complete is true when the order has been tracked since it was NEW, so none of its fills were missed
+	complete  bool
+	filledQty float64
+	// filledQuote is the sum of the prices times the quantities of the fills
+	filledQuote float64
+}
+
+// StreamClient answers the order statuses and the commissions from the pushed order updates,
+// and falls back to the exchange when the stream is disconnected or the order has not been updated
+type StreamClient struct {
+	Repository
+	streamer OrderStreamer
+
+	// Updates receives a signal on every order update, without blocking the stream
+	Updates chan struct{}
+
+	mu        sync.RWMutex
+	connected bool
+	orders    map[string]*streamOrder
+}
+
+// NewStreamClient returns the exchange client, which tracks the orders by the user data stream of the streamer
+func NewStreamClient(ex Repository, s OrderStreamer) *StreamClient {
+	return &StreamClient{
+		Repository: ex,
+		streamer:   s,
+		Updates:    make(chan struct{}, 1),
+		orders:     make(map[string]*streamOrder),
+	}
+}
+
+// Run subscribes to the order updates until the context is done
+func (c *StreamClient) Run(ctx context.Context) {
+	c.streamer.StreamOrders(ctx, c.onUpdate, c.setConnected)
+}
+
+// IsConnected returns true when the order updates are being pushed
+func (c *StreamClient) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected
+}
+
+func (c *StreamClient) setConnected(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = connected
+	// The updates may have been missed while disconnected
+	c.orders = make(map[string]*streamOrder)
+}
+
+func (c *StreamClient) onUpdate(u t.OrderUpdate) {
+	c.mu.Lock()
+	o, ok := c.orders[u.RefID]
+	if !ok {
+		o = &streamOrder{
+			Order:    t.Order{ID: u.ID, RefID: u.RefID, Symbol: u.Symbol},
+			complete: u.Status == t.OrderStatusNew,
+		}
+		c.orders[u.RefID] = o
+	}
+	o.Status = u.Status
+	o.UpdateTime = u.UpdateTime
+	o.Commission += u.Commission
+	if u.LastQty > 0 {
+		o.filledQty += u.LastQty
+		o.filledQuote += u.LastPrice * u.LastQty
+		o.OpenPrice = o.filledQuote / o.filledQty
+	}
+	c.prune(u.UpdateTime)
+	c.mu.Unlock()
+
+	select {
+	case c.Updates <- struct{}{}:
+	default:
+	}
+}
+
+// prune removes the completed orders older than a day, when there are too many orders
+func (c *StreamClient) prune(now int64) {
+	if len(c.orders) < maxStreamOrders {
+		return
+	}
+	for refID, o := range c.orders {
+		if o.Status != t.OrderStatusNew && o.Status != t.OrderStatusPartial && now-o.UpdateTime > 86400000 {
+			delete(c.orders, refID)
+		}
+	}
+}
+
+func (c *StreamClient) getOrder(refID string) *streamOrder {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.connected || refID == "" {
+		return nil
+	}
+	o, ok := c.orders[refID]
+	if !ok {
+		return nil
+	}
+	_o := *o
+	return &_o
+}
+
+// GetOrder returns the order status and the average fill price from the stream, or from the exchange
+func (c *StreamClient) GetOrder(o t.Order) (*t.Order, error) {
+	so := c.getOrder(o.RefID)
+	// The average price of an order with the missed fills is unknown
+	if so == nil || (so.Status == t.OrderStatusFilled && !so.complete) {
+		return c.Repository.GetOrder(o)
+	}
+	o.Status = so.Status
+	o.UpdateTime = so.UpdateTime
+	if so.filledQty > 0 {
+		o.OpenPrice = so.OpenPrice
+	}
+	return &o, nil
+}
+
+// GetCommission returns the commission of the filled order from the stream, or from the exchange
+func (c *StreamClient) GetCommission(symbol string, orderRefID string) *float64 {
+	if so := c.getOrder(orderRefID); so != nil && so.complete && so.Status == t.OrderStatusFilled {
+		return &so.Commission
+	}
+	return c.Repository.GetCommission(symbol, orderRefID)
+}
+
+// IsTraded returns true when the stream has pushed a fill of the order, ok is false when the order is not tracked
+func (c *StreamClient) IsTraded(symbol string, orderRefID string) (bool, bool) {
+	so := c.getOrder(orderRefID)
+	if so == nil {
+		return false, false
+	}
+	return so.filledQty > 0, true
+}
@@ -0,0 +1,101 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tonkla/autotp/exchange/sim"
+	"github.com/tonkla/autotp/types"
+)
+
+// streamClient pushes the order updates given by the test, and counts the polled orders and commissions
+type streamClient struct {
+	*sim.Client
+	onUpdate    func(types.OrderUpdate)
+	connected   func(bool)
+	polled      int
+	commissions int
+}
+
+func (c *streamClient) StreamOrders(ctx context.Context, onUpdate func(types.OrderUpdate), connected func(bool)) {
+	c.onUpdate = onUpdate
+	c.connected = connected
+}
+
+func (c *streamClient) GetOrder(o types.Order) (*types.Order, error) {
+	c.polled++
+	return c.Client.GetOrder(o)
+}
+
+func (c *streamClient) GetCommission(symbol string, orderRefID string) *float64 {
+	c.commissions++
+	return c.Client.GetCommission(symbol, orderRefID)
+}
+
+func TestStreamClient(t *testing.T) {
+	live := &streamClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+	live.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: 1634083200000})
+
+	sc := NewStreamClient(live, live)
+	sc.Run(context.Background())
+
+	o, err := sc.OpenLimitOrder(types.Order{ID: "1", Symbol: symbol, Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Qty: 1, OpenPrice: 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Disconnected, poll the exchange
+	if exo, _ := sc.GetOrder(*o); exo == nil || exo.Status != types.OrderStatusNew || live.polled != 1 {
+		t.Fatalf("Expect the order polled from the exchange, got %+v", exo)
+	}
+
+	live.connected(true)
+	if _, ok := sc.IsTraded(symbol, o.RefID); ok {
+		t.Error("Expect an untracked order to be unknown")
+	}
+	live.onUpdate(types.OrderUpdate{ID: "1", RefID: o.RefID, Status: types.OrderStatusNew})
+	if traded, ok := sc.IsTraded(symbol, o.RefID); traded || !ok {
+		t.Error("Expect a NEW order not to be traded")
+	}
+	live.onUpdate(types.OrderUpdate{ID: "1", RefID: o.RefID, Status: types.OrderStatusPartial,
+		LastQty: 0.25, LastPrice: 99, Commission: 0.1, UpdateTime: 1})
+	live.onUpdate(types.OrderUpdate{ID: "1", RefID: o.RefID, Status: types.OrderStatusFilled,
+		LastQty: 0.75, LastPrice: 98, Commission: 0.2, UpdateTime: 2})
+	select {
+	case <-sc.Updates:
+	default:
+		t.Error("Expect a signal of the order updates")
+	}
+
+	exo, err := sc.GetOrder(*o)
+	if err != nil || exo.Status != types.OrderStatusFilled || exo.UpdateTime != 2 || live.polled != 1 {
+		t.Errorf("Expect the order from the stream, got %+v", exo)
+	}
+	if exo.OpenPrice < 98.25-1e-9 || exo.OpenPrice > 98.25+1e-9 {
+		t.Errorf("Expect the average fill price 98.25, got %v", exo.OpenPrice)
+	}
+	if c := sc.GetCommission(symbol, o.RefID); c == nil || *c < 0.3-1e-9 || *c > 0.3+1e-9 || live.commissions != 0 {
+		t.Errorf("Expect the commissions of both fills, got %v", c)
+	}
+	if traded, ok := sc.IsTraded(symbol, o.RefID); !traded || !ok {
+		t.Error("Expect the filled order to be traded")
+	}
+
+	// The updates may have been missed while reconnecting
+	live.connected(false)
+	live.connected(true)
+	if exo, _ = sc.GetOrder(*o); live.polled != 2 {
+		t.Error("Expect the order polled from the exchange after reconnecting")
+	}
+
+	// The earlier fills of an order first seen after reconnecting are unknown
+	live.onUpdate(types.OrderUpdate{ID: "1", RefID: o.RefID, Status: types.OrderStatusFilled,
+		LastQty: 0.5, LastPrice: 97, Commission: 0.2, UpdateTime: 3})
+	if exo, _ = sc.GetOrder(*o); live.polled != 3 {
+		t.Error("Expect the partly tracked order polled from the exchange")
+	}
+	if sc.GetCommission(symbol, o.RefID); live.commissions != 1 {
+		t.Error("Expect the commission of the partly tracked order from the exchange")
+	}
+}
@@ -0,0 +1,63 @@
+package exchange
+
+import (
+	"sync"
+	"time"
+
+	h "github.com/tonkla/autotp/helper"
+	t "github.com/tonkla/autotp/types"
+)
+
+// SymbolInfoRepository is implemented by the clients that can read the trading rules of a symbol
+type SymbolInfoRepository interface {
+	GetSymbolInfo(symbol string) (*t.SymbolInfo, error)
+}
+
+// SymbolInfoCache keeps the trading rules of the symbols, they are reloaded from the exchange
+// when they are older than the TTL. The cached rules are kept while the exchange fails.
+type SymbolInfoCache struct {
+	repo SymbolInfoRepository
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu    sync.Mutex
+	infos map[string]symbolInfo
+}
+
+type symbolInfo struct {
+	info     *t.SymbolInfo
+	loadedAt time.Time
+}
+
+// NewSymbolInfoCache returns the cache of the trading rules of the repository
+func NewSymbolInfoCache(repo SymbolInfoRepository, ttl time.Duration) *SymbolInfoCache {
+	return &SymbolInfoCache{
+		repo:  repo,
+		ttl:   ttl,
+		now:   time.Now,
+		infos: make(map[string]symbolInfo),
+	}
+}
+
+// Get returns the trading rules of the symbol, or nil when they have never been loaded
+func (c *SymbolInfoCache) Get(symbol string) *t.SymbolInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.infos[symbol]
+	if ok && c.now().Sub(cached.loadedAt) < c.ttl {
+		return cached.info
+	}
+
+	info, err := c.repo.GetSymbolInfo(symbol)
+	if err != nil || info == nil {
+		h.Log("SymbolInfoCache", err)
+		if ok {
+			// Retry on the next TTL
+			c.infos[symbol] = symbolInfo{info: cached.info, loadedAt: c.now()}
+		}
+		return cached.info
+	}
+	c.infos[symbol] = symbolInfo{info: info, loadedAt: c.now()}
+	return info
+}
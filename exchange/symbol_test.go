@@ -0,0 +1,48 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tonkla/autotp/types"
+)
+
+// symbolInfoRepo counts the loads of the trading rules, it fails when asked
+type symbolInfoRepo struct {
+	loads int
+	fail  bool
+}
+
+func (r *symbolInfoRepo) GetSymbolInfo(symbol string) (*types.SymbolInfo, error) {
+	r.loads++
+	if r.fail {
+		return nil, errors.New("unavailable")
+	}
+	return &types.SymbolInfo{Symbol: symbol, TickSize: 0.01, StepSize: 0.001}, nil
+}
+
+func TestSymbolInfoCache(t *testing.T) {
+	repo := &symbolInfoRepo{}
+	now := time.Unix(1634083200, 0)
+	c := NewSymbolInfoCache(repo, time.Hour)
+	c.now = func() time.Time { return now }
+
+	if si := c.Get(symbol); si == nil || si.TickSize != 0.01 || repo.loads != 1 {
+		t.Fatalf("Unexpected info: %+v, %d loads", si, repo.loads)
+	}
+	now = now.Add(30 * time.Minute)
+	if c.Get(symbol); repo.loads != 1 {
+		t.Errorf("Expect the cached info, got %d loads", repo.loads)
+	}
+
+	// The stale info is kept while the exchange fails
+	repo.fail = true
+	now = now.Add(time.Hour)
+	if si := c.Get(symbol); si == nil || repo.loads != 2 {
+		t.Errorf("Expect the stale info, got %+v, %d loads", si, repo.loads)
+	}
+	if c.Get(symbol); repo.loads != 2 {
+		t.Errorf("Expect no retry until the next TTL, got %d loads", repo.loads)
+	}
+}
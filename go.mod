@@ -3,6 +3,7 @@ module github.com/tonkla/autotp
 go 1.17
 
 require (
+	github.com/gorilla/websocket v1.5.0
 	github.com/matoous/go-nanoid/v2 v2.0.0
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.9.0
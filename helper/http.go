@@ -2,15 +2,75 @@ package helper
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
-func call(resp *http.Response, err error) ([]byte, error) {
+// RequestTimeout is the timeout of a request, including the waits of the rate limits
+var RequestTimeout = 10 * time.Second
+
+// client is shared by all exchanges, its transport keeps the connections of every host alive
+var client = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// Do calls the URL with the method, header and data attached. The request waits for the rate limits of the host,
+// and it is canceled by the context or after RequestTimeout. A GET request is retried once after being rate limited.
+func Do(ctx context.Context, method string, rawURL string, header http.Header, data string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
+	defer cancel()
+
+	u, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, err
 	}
+
+	for attempt := 0; ; attempt++ {
+		if err := limiter.wait(ctx, u.Host, method); err != nil {
+			return nil, err
+		}
+
+		var body io.Reader
+		if !strings.EqualFold(data, "") {
+			body = bytes.NewBufferString(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			req.Header = header
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		err = limiter.update(u.Host, resp)
+		if err != nil && method == http.MethodGet && attempt == 0 {
+			resp.Body.Close()
+			continue
+		}
+		data, readErr := call(resp)
+		if err != nil {
+			return nil, err
+		}
+		return data, readErr
+	}
+}
+
+func call(resp *http.Response) ([]byte, error) {
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -19,52 +79,32 @@ func call(resp *http.Response, err error) ([]byte, error) {
 	return body, nil
 }
 
-// Get calls the URL with HTTP GET
-func Get(url string) ([]byte, error) {
-	return call(http.Get(url))
+// Get calls the URL with HTTP GET, it is canceled by the context
+func Get(ctx context.Context, url string) ([]byte, error) {
+	return Do(ctx, http.MethodGet, url, nil, "")
 }
 
 // GetH calls the URL with header attached, with HTTP GET
-func GetH(url string, header http.Header) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header = header
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	return call(client.Do(req))
+func GetH(ctx context.Context, url string, header http.Header) ([]byte, error) {
+	return Do(ctx, http.MethodGet, url, header, "")
 }
 
 // Post calls the URL with header attached, with HTTP POST
-func Post(url string, header http.Header) ([]byte, error) {
-	return PostD(url, header, "")
+func Post(ctx context.Context, url string, header http.Header) ([]byte, error) {
+	return PostD(ctx, url, header, "")
 }
 
 // PostD calls the URL with header and data attached, with HTTP POST
-func PostD(url string, header http.Header, data string) ([]byte, error) {
-	body := bytes.NewBuffer([]byte{})
-	if !strings.EqualFold(data, "") {
-		body = bytes.NewBuffer([]byte(data))
-	}
-	req, err := http.NewRequest("POST", url, body)
-	if err != nil {
-		return nil, err
-	}
-	req.Header = header
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	return call(client.Do(req))
+func PostD(ctx context.Context, url string, header http.Header, data string) ([]byte, error) {
+	return Do(ctx, http.MethodPost, url, header, data)
+}
+
+// Put calls the URL with header attached, with HTTP PUT
+func Put(ctx context.Context, url string, header http.Header) ([]byte, error) {
+	return Do(ctx, http.MethodPut, url, header, "")
 }
 
 // Delete calls the URL with HTTP DELETE
-func Delete(url string, header http.Header) ([]byte, error) {
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header = header
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	return call(client.Do(req))
+func Delete(ctx context.Context, url string, header http.Header) ([]byte, error) {
+	return Do(ctx, http.MethodDelete, url, header, "")
 }
@@ -0,0 +1,175 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits are the limits of a host by the intervals of the Binance headers,
+// e.g. "WEIGHT-1M" of X-MBX-USED-WEIGHT-1M and "ORDER-10S" of X-MBX-ORDER-COUNT-10S
+type RateLimits map[string]int
+
+// rateLimitUsage is the ratio of a limit, at which the requests wait for the next interval
+const rateLimitUsage = 0.9
+
+// ErrRateLimited is wrapped by the errors of the requests that have not been sent, or have been refused, by the rate limits
+var ErrRateLimited = errors.New("rate limited")
+
+// defaultBackoff is the backoff of a 429/418 response without Retry-After
+const defaultBackoff = time.Minute
+
+type rateUsage struct {
+	count   int
+	resetAt time.Time
+}
+
+type hostLimit struct {
+	limits RateLimits
+	usages map[string]rateUsage
+	// until is the end of the backoff of a 429/418 response
+	until time.Time
+}
+
+type rateLimiter struct {
+	mu    sync.Mutex
+	hosts map[string]*hostLimit
+	now   func() time.Time
+}
+
+var limiter = &rateLimiter{
+	hosts: map[string]*hostLimit{
+		"api.binance.com":  {limits: RateLimits{"WEIGHT-1M": 6000, "ORDER-10S": 100, "ORDER-1D": 200000}},
+		"fapi.binance.com": {limits: RateLimits{"WEIGHT-1M": 2400, "ORDER-10S": 300, "ORDER-1M": 1200}},
+		// The testnets have the limits of the production
+		"testnet.binance.vision":    {limits: RateLimits{"WEIGHT-1M": 6000, "ORDER-10S": 100, "ORDER-1D": 200000}},
+		"testnet.binancefuture.com": {limits: RateLimits{"WEIGHT-1M": 2400, "ORDER-10S": 300, "ORDER-1M": 1200}},
+	},
+	now: time.Now,
+}
+
+// SetRateLimits sets the limits of the host, e.g. of the testnet
+func SetRateLimits(host string, limits RateLimits) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.host(host).limits = limits
+}
+
+func (l *rateLimiter) host(host string) *hostLimit {
+	hl, ok := l.hosts[host]
+	if !ok {
+		hl = &hostLimit{}
+		l.hosts[host] = hl
+	}
+	if hl.usages == nil {
+		hl.usages = make(map[string]rateUsage)
+	}
+	return hl
+}
+
+// waitUntil returns the time that the request of the method has to wait for
+func (l *rateLimiter) waitUntil(host string, method string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	hl := l.host(host)
+	until := hl.until
+	for key, u := range hl.usages {
+		limit := hl.limits[key]
+		// The orders are counted on placing only
+		if limit <= 0 || !now.Before(u.resetAt) || (strings.HasPrefix(key, "ORDER-") && method != http.MethodPost) {
+			continue
+		}
+		if float64(u.count) >= float64(limit)*rateLimitUsage && u.resetAt.After(until) {
+			until = u.resetAt
+		}
+	}
+	return until
+}
+
+// wait waits for the rate limits of the host, it fails immediately when the wait exceeds the deadline
+func (l *rateLimiter) wait(ctx context.Context, host string, method string) error {
+	until := l.waitUntil(host, method)
+	d := until.Sub(l.now())
+	if d <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && until.After(deadline) {
+		return fmt.Errorf("%s is %w until %s", host, ErrRateLimited, until.Format(time.RFC3339))
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// update records the usages of the response headers, and returns an error of a 429/418 response
+func (l *rateLimiter) update(host string, resp *http.Response) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	hl := l.host(host)
+	for name, values := range resp.Header {
+		name = strings.ToUpper(name)
+		var key string
+		if strings.HasPrefix(name, "X-MBX-USED-WEIGHT-") {
+			key = "WEIGHT-" + strings.TrimPrefix(name, "X-MBX-USED-WEIGHT-")
+		} else if strings.HasPrefix(name, "X-MBX-ORDER-COUNT-") {
+			key = "ORDER-" + strings.TrimPrefix(name, "X-MBX-ORDER-COUNT-")
+		} else {
+			continue
+		}
+		interval := parseInterval(key[strings.Index(key, "-")+1:])
+		count, err := strconv.Atoi(values[0])
+		if interval <= 0 || err != nil {
+			continue
+		}
+		// The intervals of Binance are fixed windows
+		hl.usages[key] = rateUsage{count: count, resetAt: now.Truncate(interval).Add(interval)}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusTeapot {
+		return nil
+	}
+	backoff := defaultBackoff
+	if sec, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && sec > 0 {
+		backoff = time.Duration(sec) * time.Second
+	}
+	if until := now.Add(backoff); until.After(hl.until) {
+		hl.until = until
+	}
+	return fmt.Errorf("%s responded %d, %w until %s", host, resp.StatusCode, ErrRateLimited, hl.until.Format(time.RFC3339))
+}
+
+// parseInterval returns the duration of the interval of a header, e.g. 10S, 1M, 1H or 1D
+func parseInterval(s string) time.Duration {
+	if len(s) < 2 {
+		return 0
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0
+	}
+	switch s[len(s)-1] {
+	case 'S':
+		return time.Duration(n) * time.Second
+	case 'M':
+		return time.Duration(n) * time.Minute
+	case 'H':
+		return time.Duration(n) * time.Hour
+	case 'D':
+		return time.Duration(n) * 24 * time.Hour
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2021, 10, 13, 2, 0, 30, 0, time.UTC)
+	l := &rateLimiter{hosts: make(map[string]*hostLimit), now: func() time.Time { return now }}
+	l.host("fapi").limits = RateLimits{"WEIGHT-1M": 2400, "ORDER-10S": 300}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	resp.Header.Set("X-MBX-USED-WEIGHT-1M", "1000")
+	resp.Header.Set("X-MBX-ORDER-COUNT-10S", "280")
+	if err := l.update("fapi", resp); err != nil {
+		t.Fatal(err)
+	}
+	if until := l.waitUntil("fapi", http.MethodGet); until.After(now) {
+		t.Errorf("Expect no wait of GET, got %s", until)
+	}
+	// The order count is over 90% of its limit, the next order waits for the next 10 seconds
+	if until := l.waitUntil("fapi", http.MethodPost); !until.Equal(now.Add(10 * time.Second).Truncate(10 * time.Second)) {
+		t.Errorf("Expect the order to wait, got %s", until)
+	}
+
+	resp.Header.Set("X-MBX-USED-WEIGHT-1M", "2200")
+	l.update("fapi", resp)
+	if until := l.waitUntil("fapi", http.MethodGet); !until.Equal(now.Truncate(time.Minute).Add(time.Minute)) {
+		t.Errorf("Expect the request to wait for the next minute, got %s", until)
+	}
+
+	resp = &http.Response{StatusCode: http.StatusTeapot, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "120")
+	if err := l.update("fapi", resp); err == nil {
+		t.Error("Expect an error of the ban")
+	}
+	if until := l.waitUntil("fapi", http.MethodGet); !until.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("Expect the backoff of Retry-After, got %s", until)
+	}
+}
+
+func TestDoRetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"price":"480.1"}`))
+	}))
+	defer srv.Close()
+
+	// A GET request is retried after the backoff
+	data, err := Get(context.Background(), srv.URL)
+	if err != nil || string(data) != `{"price":"480.1"}` || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("Unexpected response: %s, %v, %d calls", data, err, calls)
+	}
+
+	// A POST request is not retried
+	atomic.StoreInt32(&calls, 0)
+	u, _ := url.Parse(srv.URL)
+	limiter.mu.Lock()
+	limiter.host(u.Host).until = time.Time{}
+	limiter.mu.Unlock()
+	if _, err = Post(context.Background(), srv.URL, http.Header{}); err == nil || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expect an error without a retry, got %v, %d calls", err, calls)
+	}
+
+	// The backoff longer than the timeout fails immediately
+	timeout := RequestTimeout
+	RequestTimeout = 200 * time.Millisecond
+	defer func() { RequestTimeout = timeout }()
+	start := time.Now()
+	if _, err = Get(context.Background(), srv.URL); err == nil || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Expect an immediate error, got %v", err)
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer srv.Close()
+
+	// A canceled context aborts the request without waiting for the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := Get(ctx, srv.URL); !errors.Is(err, context.Canceled) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expect a canceled error, got %v", err)
+	}
+}
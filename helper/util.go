@@ -3,6 +3,8 @@ package helper
 import (
 	"math"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	gonanoid "github.com/matoous/go-nanoid/v2"
@@ -14,9 +16,21 @@ func Now13() int64 {
 	return time.Now().UnixNano() / 1e6
 }
 
-// GenID returns a string of a millisecond Unix timestamp
+var lastID int64
+
+// GenID returns a string of a millisecond Unix timestamp,
+// bumped by one millisecond when it would collide with the last generated ID
 func GenID() string {
-	return strconv.FormatInt(Now13(), 10)
+	for {
+		last := atomic.LoadInt64(&lastID)
+		id := Now13()
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastID, last, id) {
+			return strconv.FormatInt(id, 10)
+		}
+	}
 }
 
 // RandomStr returns a random string, generated by NanoID
@@ -75,6 +89,33 @@ func NormalizeDouble(number float64, digits int64) float64 {
 	return math.Round(number*pow) / pow
 }
 
+// StepDigits returns a number of decimal digits of the step size, e.g. 2 of 0.01
+func StepDigits(step float64) int64 {
+	for d := int64(0); d < 16; d++ {
+		v := step * math.Pow(10, float64(d))
+		if math.Abs(v-math.Round(v)) < 1e-9 {
+			return d
+		}
+	}
+	return 16
+}
+
+// RoundToStep rounds a number to the nearest multiple of the step size, e.g. a price of the tick size
+func RoundToStep(number float64, step float64) float64 {
+	if step <= 0 {
+		return number
+	}
+	return NormalizeDouble(math.Round(number/step)*step, StepDigits(step))
+}
+
+// FloorToStep rounds a number down to a multiple of the step size, e.g. a quantity of the lot size
+func FloorToStep(number float64, step float64) float64 {
+	if step <= 0 {
+		return number
+	}
+	return NormalizeDouble(math.Floor(number/step+1e-9)*step, StepDigits(step))
+}
+
 // Round extends math.Round for special usecase
 func Round(number float64, pow float64) float64 {
 	return math.Round(number*pow) / pow
@@ -111,3 +152,48 @@ func ConvertTfString(timeframe string) int {
 		return 1
 	}
 }
+
+// TfDuration returns a duration of the timeframe in milliseconds, a month is counted as 30 days
+func TfDuration(timeframe string) int64 {
+	if len(timeframe) < 2 {
+		return 0
+	}
+	n, err := strconv.ParseInt(timeframe[:len(timeframe)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	const minute = 60 * 1000
+	switch timeframe[len(timeframe)-1] {
+	case 'm':
+		return n * minute
+	case 'h':
+		return n * 60 * minute
+	case 'd':
+		return n * 1440 * minute
+	case 'w':
+		return n * 7 * 1440 * minute
+	case 'M':
+		return n * 30 * 1440 * minute
+	default:
+		return 0
+	}
+}
+
+// TfOpenTime returns the open time of the timeframe bar that the millisecond timestamp belongs to,
+// weeks start on Monday and months start on the first day, both in UTC
+func TfOpenTime(timeframe string, ms int64) int64 {
+	if strings.HasSuffix(timeframe, "M") {
+		tm := time.UnixMilli(ms).UTC()
+		return time.Date(tm.Year(), tm.Month(), 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	}
+	dur := TfDuration(timeframe)
+	if dur == 0 {
+		return ms
+	}
+	// The Unix epoch is on Thursday, weekly bars open on Monday
+	var offset int64
+	if strings.HasSuffix(timeframe, "w") {
+		offset = 4 * TfDuration("1d")
+	}
+	return (ms-offset)/dur*dur + offset
+}
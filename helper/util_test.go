@@ -103,3 +103,79 @@ func TestNormalizeDouble(t *testing.T) {
 		}
 	}
 }
+
+func TestStepSize(t *testing.T) {
+	if d := StepDigits(0.01); d != 2 {
+		t.Errorf("Expect 2 digits, got %d", d)
+	}
+	if d := StepDigits(1); d != 0 {
+		t.Errorf("Expect 0 digits, got %d", d)
+	}
+	if d := StepDigits(0.00001); d != 5 {
+		t.Errorf("Expect 5 digits, got %d", d)
+	}
+
+	type test struct {
+		number float64
+		step   float64
+		round  float64
+		floor  float64
+	}
+
+	data := []test{
+		{480.1234, 0.01, 480.12, 480.12},
+		{480.1267, 0.01, 480.13, 480.12},
+		{480.1267, 0.05, 480.15, 480.1},
+		{0.3, 0.1, 0.3, 0.3},
+		{0.0299, 0.001, 0.03, 0.029},
+		{12.7, 1, 13, 12},
+		{12.7, 0, 12.7, 12.7},
+	}
+
+	for _, d := range data {
+		if r := RoundToStep(d.number, d.step); r != d.round {
+			t.Errorf("RoundToStep(%v, %v): expect %v, got %v", d.number, d.step, d.round, r)
+		}
+		if f := FloorToStep(d.number, d.step); f != d.floor {
+			t.Errorf("FloorToStep(%v, %v): expect %v, got %v", d.number, d.step, d.floor, f)
+		}
+	}
+}
+
+func TestGenID(t *testing.T) {
+	ids := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := GenID()
+		if ids[id] {
+			t.Fatalf("Duplicated ID: %s", id)
+		}
+		ids[id] = true
+	}
+}
+
+func TestTfOpenTime(t *testing.T) {
+	type test struct {
+		timeframe string
+		ms        int64
+		expected  int64
+	}
+
+	// 2021-10-13 (Wednesday) 10:37:21.500 UTC
+	const ms = 1634121441500
+
+	data := []test{
+		{"1m", ms, 1634121420000},
+		{"15m", ms, 1634121000000},
+		{"4h", ms, 1634112000000},
+		{"1d", ms, 1634083200000},
+		{"1w", ms, 1633910400000},
+		{"1M", ms, 1633046400000},
+		{"1m", 1634121420000, 1634121420000},
+	}
+
+	for _, d := range data {
+		if r := TfOpenTime(d.timeframe, d.ms); r != d.expected {
+			t.Errorf("%s: Expect: %d, Got: %d", d.timeframe, d.expected, r)
+		}
+	}
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log"
 	"os"
-	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"github.com/tonkla/autotp/app"
+	"github.com/tonkla/autotp/backtest"
+	"github.com/tonkla/autotp/clock"
 	"github.com/tonkla/autotp/exchange"
 	h "github.com/tonkla/autotp/helper"
 	"github.com/tonkla/autotp/rdb"
+	"github.com/tonkla/autotp/report"
 	"github.com/tonkla/autotp/robot"
 	"github.com/tonkla/autotp/strategy"
 	t "github.com/tonkla/autotp/types"
@@ -21,112 +27,219 @@ var rootCmd = &cobra.Command{
 	Use:   "autotp",
 	Short: "AutoTP: Auto Take Profit",
 	Long:  "AutoTP: Auto Trading Platform",
-	Run:   func(cmd *cobra.Command, args []string) {},
+	Run:   func(cmd *cobra.Command, args []string) { run() },
+}
+
+var backtestCmd = &cobra.Command{
+	Use:   "backtest",
+	Short: "Replay historical prices through the strategies on a simulated exchange",
+	Run:   func(cmd *cobra.Command, args []string) { runBacktest(cmd) },
+}
+
+var downloadCmd = &cobra.Command{
+	Use:   "download",
+	Short: "Download k-lines of the bot's symbol into the DB",
+	Run:   func(cmd *cobra.Command, args []string) { runDownload() },
+}
+
+var optimizeCmd = &cobra.Command{
+	Use:   "optimize",
+	Short: "Backtest a bot over ranges of its parameters, then rank the results",
+	Run:   func(cmd *cobra.Command, args []string) { runOptimize(cmd) },
+}
+
+var walkForwardCmd = &cobra.Command{
+	Use:   "walkforward",
+	Short: "Optimize a bot on rolling in-sample windows, then backtest it on the next out-of-sample windows",
+	Run:   func(cmd *cobra.Command, args []string) { runWalkForward(cmd) },
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Write the performance report of a bot from its closed orders",
+	Run:   func(cmd *cobra.Command, args []string) { runReport() },
 }
 
 var (
 	configFile string
+
+	rpDbName string
+	rpOutput string
+
+	wfInSample  string
+	wfOutSample string
+
+	opRanges  []string
+	opSortBy  string
+	opWorkers int
+	opTop     int
+
+	dlTimeframes []string
+	dlStart      string
+	dlEnd        string
+	dlDbName     string
+
+	btConfigFiles []string
+	btDataFile    string
+	btCandleDb    string
+	btTimeframe   string
+	btStart       string
+	btEnd         string
+	btDbName      string
+	btMakerFee    float64
+	btTakerFee    float64
+	btVerbose     bool
+	btReportDir   string
+	btPath        string
+	btSeed        int64
+	btSubDataFile string
+	btSubTf       string
 )
 
 func init() {
 	rootCmd.Flags().StringVarP(&configFile, "configFile", "c", "", "Configuration File (required)")
 	rootCmd.MarkFlagRequired("configFile")
+
+	backtestCmd.Flags().StringSliceVarP(&btConfigFiles, "configFile", "c", nil, "Configuration Files, one bot per file (required)")
+	backtestCmd.Flags().StringVarP(&btDataFile, "data", "d", "", "CSV File of k-lines")
+	backtestCmd.Flags().StringVar(&btCandleDb, "candleDb", "", "SQLite database of downloaded k-lines, used when there is no CSV file")
+	backtestCmd.Flags().StringVarP(&btTimeframe, "timeframe", "t", "1m", "Timeframe of the k-lines")
+	backtestCmd.Flags().StringVar(&btStart, "start", "", "Start date (YYYY-MM-DD), earlier k-lines are used as historical prices")
+	backtestCmd.Flags().StringVar(&btEnd, "end", "", "End date (YYYY-MM-DD)")
+	backtestCmd.Flags().StringVar(&btDbName, "dbName", "", "SQLite database name, in-memory when empty")
+	backtestCmd.Flags().Float64Var(&btMakerFee, "makerFee", 0, "Maker fee rate of the simulated exchange, overrides makerFee of the configuration")
+	backtestCmd.Flags().Float64Var(&btTakerFee, "takerFee", 0, "Taker fee rate of the simulated exchange, overrides takerFee of the configuration")
+	backtestCmd.Flags().BoolVarP(&btVerbose, "verbose", "v", false, "Log every order")
+	backtestCmd.Flags().StringVar(&btReportDir, "report", "", "Directory to write the JSON and the HTML reports of the bots")
+	backtestCmd.MarkFlagRequired("configFile")
+	addReplayFlags(backtestCmd)
+	rootCmd.AddCommand(backtestCmd)
+
+	optimizeCmd.Flags().StringVarP(&configFile, "configFile", "c", "", "Configuration File (required)")
+	optimizeCmd.Flags().StringVarP(&btDataFile, "data", "d", "", "CSV File of k-lines")
+	optimizeCmd.Flags().StringVar(&btCandleDb, "candleDb", "", "SQLite database of downloaded k-lines, used when there is no CSV file")
+	optimizeCmd.Flags().StringVarP(&btTimeframe, "timeframe", "t", "1m", "Timeframe of the k-lines")
+	optimizeCmd.Flags().StringVar(&btStart, "start", "", "Start date (YYYY-MM-DD), earlier k-lines are used as historical prices")
+	optimizeCmd.Flags().StringVar(&btEnd, "end", "", "End date (YYYY-MM-DD)")
+	optimizeCmd.Flags().Float64Var(&btMakerFee, "makerFee", 0, "Maker fee rate of the simulated exchange, overrides makerFee of the configuration")
+	optimizeCmd.Flags().Float64Var(&btTakerFee, "takerFee", 0, "Taker fee rate of the simulated exchange, overrides takerFee of the configuration")
+	optimizeCmd.Flags().StringArrayVarP(&opRanges, "range", "r", nil, "Range of a parameter as field=min:max:step, e.g. atrSL=1:3:0.5 (required)")
+	optimizeCmd.Flags().StringVar(&opSortBy, "sort", backtest.SortByNetPL, "Rank by pnl, drawdown or pf (profit factor)")
+	optimizeCmd.Flags().IntVar(&opWorkers, "workers", 0, "Number of parallel backtests, the number of CPUs when 0")
+	optimizeCmd.Flags().IntVar(&opTop, "top", 20, "Number of the best results to print, all when 0")
+	optimizeCmd.MarkFlagRequired("configFile")
+	optimizeCmd.MarkFlagRequired("range")
+	addReplayFlags(optimizeCmd)
+	rootCmd.AddCommand(optimizeCmd)
+
+	walkForwardCmd.Flags().StringVarP(&configFile, "configFile", "c", "", "Configuration File (required)")
+	walkForwardCmd.Flags().StringVarP(&btDataFile, "data", "d", "", "CSV File of k-lines")
+	walkForwardCmd.Flags().StringVar(&btCandleDb, "candleDb", "", "SQLite database of downloaded k-lines, used when there is no CSV file")
+	walkForwardCmd.Flags().StringVarP(&btTimeframe, "timeframe", "t", "1m", "Timeframe of the k-lines")
+	walkForwardCmd.Flags().StringVar(&btStart, "start", "", "Start date (YYYY-MM-DD) of the first in-sample window, earlier k-lines are used as historical prices")
+	walkForwardCmd.Flags().StringVar(&btEnd, "end", "", "End date (YYYY-MM-DD)")
+	walkForwardCmd.Flags().Float64Var(&btMakerFee, "makerFee", 0, "Maker fee rate of the simulated exchange, overrides makerFee of the configuration")
+	walkForwardCmd.Flags().Float64Var(&btTakerFee, "takerFee", 0, "Taker fee rate of the simulated exchange, overrides takerFee of the configuration")
+	walkForwardCmd.Flags().StringArrayVarP(&opRanges, "range", "r", nil, "Range of a parameter as field=min:max:step, e.g. atrSL=1:3:0.5 (required)")
+	walkForwardCmd.Flags().StringVar(&opSortBy, "sort", backtest.SortByNetPL, "Pick the in-sample best by pnl, drawdown or pf (profit factor)")
+	walkForwardCmd.Flags().IntVar(&opWorkers, "workers", 0, "Number of parallel backtests, the number of CPUs when 0")
+	walkForwardCmd.Flags().StringVar(&wfInSample, "inSample", "30d", "Length of the in-sample windows, e.g. 12h, 30d, 4w")
+	walkForwardCmd.Flags().StringVar(&wfOutSample, "outSample", "7d", "Length of the out-of-sample windows, e.g. 12h, 7d, 1w")
+	walkForwardCmd.MarkFlagRequired("configFile")
+	walkForwardCmd.MarkFlagRequired("range")
+	addReplayFlags(walkForwardCmd)
+	rootCmd.AddCommand(walkForwardCmd)
+
+	reportCmd.Flags().StringVarP(&configFile, "configFile", "c", "", "Configuration File (required)")
+	reportCmd.Flags().StringVar(&rpDbName, "dbName", "", "SQLite database name, dbName of the configuration when empty")
+	reportCmd.Flags().StringVarP(&rpOutput, "output", "o", "", "Output file name without extension, report-<botID> when empty")
+	reportCmd.MarkFlagRequired("configFile")
+	rootCmd.AddCommand(reportCmd)
+
+	downloadCmd.Flags().StringVarP(&configFile, "configFile", "c", "", "Configuration File (required)")
+	downloadCmd.Flags().StringSliceVarP(&dlTimeframes, "timeframe", "t", []string{"1m"}, "Timeframes of the k-lines")
+	downloadCmd.Flags().StringVar(&dlStart, "start", "", "Start date (YYYY-MM-DD) (required)")
+	downloadCmd.Flags().StringVar(&dlEnd, "end", "", "End date (YYYY-MM-DD), now when empty")
+	downloadCmd.Flags().StringVar(&dlDbName, "dbName", "", "SQLite database name, dbName of the configuration when empty")
+	downloadCmd.MarkFlagRequired("configFile")
+	downloadCmd.MarkFlagRequired("start")
+	rootCmd.AddCommand(downloadCmd)
+}
+
+// addReplayFlags adds the flags of the intrabar price paths
+func addReplayFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&btPath, "path", backtest.PathAuto, "Intrabar path model: AUTO, OHLC, OLHC, NEAREST or RANDOM")
+	cmd.Flags().Int64Var(&btSeed, "seed", 1, "Seed of the RANDOM path model")
+	cmd.Flags().StringVar(&btSubDataFile, "subData", "", "CSV File of k-lines of a smaller timeframe, replayed inside the bars")
+	cmd.Flags().StringVar(&btSubTf, "subTimeframe", "", "Timeframe of the smaller k-lines, from --subData or --candleDb")
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(0)
-	} else if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(0)
-	} else if ext := path.Ext(configFile); ext != ".yml" && ext != ".yaml" {
-		fmt.Fprintln(os.Stderr, "Accept only YAML file")
-		os.Exit(0)
 	}
+}
 
-	viper.SetConfigFile(configFile)
-	err := viper.ReadInConfig()
+func loadBotParams(configFile string) *t.BotParams {
+	bp, err := app.LoadBotParams(configFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(0)
 	}
+	return bp
+}
 
-	bp := t.BotParams{
-		ApiKey:    viper.GetString("apiKey"),
-		SecretKey: viper.GetString("secretKey"),
-		DbName:    viper.GetString("dbName"),
-		OrderType: viper.GetString("orderType"),
-		View:      viper.GetString("view"),
-
-		IntervalSec: viper.GetInt64("intervalSec"),
-
-		Exchange:    viper.GetString("exchange"),
-		Symbol:      viper.GetString("symbol"),
-		BotID:       viper.GetInt64("botID"),
-		Product:     viper.GetString("product"),
-		Strategy:    viper.GetString("strategy"),
-		PriceDigits: viper.GetInt64("priceDigits"),
-		QtyDigits:   viper.GetInt64("qtyDigits"),
-		BaseQty:     viper.GetFloat64("baseQty"),
-		QuoteQty:    viper.GetFloat64("quoteQty"),
-
-		StartPrice: viper.GetFloat64("startPrice"),
-		UpperPrice: viper.GetFloat64("upperPrice"),
-		LowerPrice: viper.GetFloat64("lowerPrice"),
-		GridSize:   viper.GetFloat64("gridSize"),
-		GridTP:     viper.GetFloat64("gridTP"),
-		OpenZones:  viper.GetInt64("openZones"),
-		ApplyTA:    viper.GetBool("applyTA"),
-		Slippage:   viper.GetFloat64("slippage"),
-
-		MATf1st:     viper.GetString("maTf1st"),
-		MAPeriod1st: viper.GetInt64("maPeriod1st"),
-		MATf2nd:     viper.GetString("maTf2nd"),
-		MAPeriod2nd: viper.GetInt64("maPeriod2nd"),
-		MATf3rd:     viper.GetString("maTf3rd"),
-		MAPeriod3rd: viper.GetInt64("maPeriod3rd"),
-		OrderGap:    viper.GetFloat64("orderGap"),
-		OrderGapATR: viper.GetFloat64("orderGapATR"),
-		MoS:         viper.GetFloat64("mos"),
-
-		ForceClose: viper.GetBool("forceClose"),
-		AutoSL:     viper.GetBool("autoSL"),
-		AutoTP:     viper.GetBool("autoTP"),
-		QuoteSL:    viper.GetFloat64("quoteSL"),
-		QuoteTP:    viper.GetFloat64("quoteTP"),
-		AtrSL:      viper.GetFloat64("atrSL"),
-		AtrTP:      viper.GetFloat64("atrTP"),
-		TimeSecSL:  viper.GetInt64("timeSecSL"),
-		TimeSecTP:  viper.GetInt64("timeSecTP"),
-
-		TimeSecCancel: viper.GetInt64("timeSecCancel"),
-
-		CloseLong:  viper.GetBool("closeLong"),
-		CloseShort: viper.GetBool("closeShort"),
-
-		Gap: t.StopLimit{
-			SLStop:    viper.GetInt64("slStop"),
-			SLLimit:   viper.GetInt64("slLimit"),
-			TPStop:    viper.GetInt64("tpStop"),
-			TPLimit:   viper.GetInt64("tpLimit"),
-			OpenLimit: viper.GetInt64("openLimit"),
-		},
-	}
-
-	db := rdb.Connect(bp.DbName)
-
-	ex, err := exchange.New(&bp)
+func parseDate(date string) int64 {
+	if date == "" {
+		return 0
+	}
+	tm, err := time.Parse("2006-01-02", date)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	return tm.UnixMilli()
+}
+
+// botDbName returns the DB name of the bot, the orders on the TESTNET or of the PAPER mode are recorded separately,
+// e.g. autotp.db becomes autotp.testnet.db or autotp.paper.db
+func botDbName(bp *t.BotParams) string {
+	dbName := bp.DbName
+	if dbName == "" {
+		dbName = "autotp.db"
+	}
+	ext := filepath.Ext(dbName)
+	name := strings.TrimSuffix(dbName, ext)
+	if bp.Network == t.NetworkTestnet {
+		name += ".testnet"
+	}
+	if bp.Mode == t.ModePaper {
+		name += ".paper"
+	}
+	return name + ext
+}
+
+func run() {
+	bp := loadBotParams(configFile)
+
+	db := rdb.Connect(botDbName(bp))
 
-	st, err := strategy.New(db, &bp, ex)
+	// The streams are subscribed on the exchange client itself, the wrapping clients do not expose them
+	base, err := exchange.NewClient(bp)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ex := base
+
+	// The k-lines are cached below the wrapping clients, which cannot fetch them by a time range
+	if bp.CacheKlines {
+		ex = exchange.NewCachedClient(ex, db, bp)
+		if ex == base {
+			h.Logf("K-lines are not cached, %s %s cannot fetch them by a time range\n", bp.Exchange, bp.Product)
+		}
+	}
 
 	qo := t.QueryOrder{
 		BotID:    bp.BotID,
@@ -134,22 +247,110 @@ func main() {
 		Symbol:   bp.Symbol,
 	}
 
+	if bp.Mode == t.ModePaper {
+		pc := exchange.NewPaperClient(ex, bp)
+		pc.Restore(db.GetActiveOrders(qo))
+		ex = pc
+	}
+
+	// The orders are snapped to the trading rules of the symbol, which are reloaded every hour
+	var symbols *exchange.SymbolInfoCache
+	if repo, ok := base.(exchange.SymbolInfoRepository); ok {
+		symbols = exchange.NewSymbolInfoCache(repo, time.Hour)
+		bp.SymbolInfo = symbols.Get(bp.Symbol)
+		if si := bp.SymbolInfo; si != nil {
+			if bp.PriceDigits == 0 {
+				bp.PriceDigits = h.StepDigits(si.TickSize)
+			}
+			if bp.QtyDigits == 0 {
+				bp.QtyDigits = h.StepDigits(si.StepSize)
+			}
+		}
+	}
+
+	if fa, ok := base.(exchange.FuturesAccount); ok && bp.Product == t.ProductFutures && bp.Mode != t.ModePaper {
+		if err := exchange.SetupFutures(fa, bp); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// Without the user data stream, the order updates are polled on every tick only
+	var updates <-chan struct{}
+	if bp.UserStream && bp.Mode != t.ModePaper {
+		if s, ok := base.(exchange.OrderStreamer); ok {
+			sc := exchange.NewStreamClient(ex, s)
+			go sc.Run(context.Background())
+			updates = sc.Updates
+			ex = sc
+		}
+	}
+
+	// Without the market data stream, or while it is disconnected, the ticker is polled every intervalSec seconds
+	poll := time.Tick(time.Duration(bp.IntervalSec) * time.Second)
+	tick := poll
+	var tickers <-chan t.Ticker
+	var streamConnected <-chan bool
+	if ms, ok := base.(exchange.MarketStreamer); ok && bp.MarketData == t.MarketDataStream {
+		mc := exchange.NewMarketClient(ex, ms, bp)
+		go mc.Run(context.Background())
+		tickers = mc.Tickers
+		streamConnected = mc.Connected
+		ex = mc
+	}
+
+	cl := clock.Real{}
+	st, err := strategy.New(db, bp, ex, cl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	ap := app.AppParams{
 		EX: ex,
 		ST: st,
 		DB: db,
-		BP: &bp,
+		BP: bp,
 		QO: qo,
+		CL: cl,
 	}
 
-	h.Logf("{Exchange:%s Product:%s Symbol:%s Strategy:%s BotID:%d}\n",
-		bp.Exchange, bp.Product, bp.Symbol, bp.Strategy, bp.BotID)
+	h.Logf("{Exchange:%s Product:%s Symbol:%s Strategy:%s BotID:%d Mode:%s Network:%s}\n",
+		bp.Exchange, bp.Product, bp.Symbol, bp.Strategy, bp.BotID, bp.Mode, bp.Network)
+
+	minInterval := time.Duration(bp.MinIntervalMs) * time.Millisecond
+	if minInterval <= 0 {
+		minInterval = time.Second
+	}
+	var lastTick time.Time
+	for {
+		var ticker *t.Ticker
+		select {
+		case <-updates:
+			robot.Sync(&ap)
+			continue
+		case connected := <-streamConnected:
+			tick = poll
+			if connected {
+				tick = nil
+			}
+			continue
+		case tk := <-tickers:
+			if time.Since(lastTick) < minInterval {
+				continue
+			}
+			ticker = &tk
+		case <-tick:
+			ticker = ex.GetTicker(bp.Symbol)
+		}
 
-	for range time.Tick(time.Duration(bp.IntervalSec) * time.Second) {
-		ticker := ex.GetTicker(bp.Symbol)
 		if ticker == nil || ticker.Price <= 0 {
 			continue
 		}
+		lastTick = time.Now()
+		if symbols != nil {
+			bp.SymbolInfo = symbols.Get(bp.Symbol)
+		}
 		ap.TK = *ticker
 		tradeOrders := ap.ST.OnTick(*ticker)
 		if tradeOrders != nil {
@@ -158,3 +359,212 @@ func main() {
 		}
 	}
 }
+
+func runDownload() {
+	bp := loadBotParams(configFile)
+
+	dbName := dlDbName
+	if dbName == "" {
+		dbName = bp.DbName
+	}
+	db := rdb.Connect(dbName)
+
+	ex, err := exchange.New(bp)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	startTime := parseDate(dlStart)
+	endTime := parseDate(dlEnd)
+	for _, tf := range dlTimeframes {
+		n, err := exchange.DownloadCandles(ex, db, bp, tf, startTime, endTime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s %s %s: %d k-lines\n", bp.Exchange, bp.Symbol, tf, n)
+	}
+}
+
+// loadBacktestBotParams reads the bot parameters, then overrides the fees by the flags
+func loadBacktestBotParams(cmd *cobra.Command, configFile string) *t.BotParams {
+	bp := loadBotParams(configFile)
+	if cmd.Flags().Changed("makerFee") {
+		bp.MakerFee = btMakerFee
+	}
+	if cmd.Flags().Changed("takerFee") {
+		bp.TakerFee = btTakerFee
+	}
+	return bp
+}
+
+// loadPrices reads the k-lines of the bot from the CSV file or the candle DB
+func loadPrices(bp *t.BotParams, endTime int64) []t.HistoricalPrice {
+	if btDataFile == "" && btCandleDb == "" {
+		fmt.Fprintln(os.Stderr, "Either --data or --candleDb is required")
+		os.Exit(1)
+	}
+	if btDataFile == "" {
+		return rdb.Connect(btCandleDb).GetCandles(bp.Exchange, bp.Symbol, btTimeframe, 0, endTime)
+	}
+	prices, err := backtest.LoadCSV(btDataFile, bp.Symbol)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return prices
+}
+
+// replayParams returns the backtest parameters of the flags
+func replayParams(bp *t.BotParams) backtest.Params {
+	endTime := parseDate(btEnd)
+	p := backtest.Params{
+		BP:           bp,
+		Prices:       loadPrices(bp, endTime),
+		Timeframe:    btTimeframe,
+		StartTime:    parseDate(btStart),
+		EndTime:      endTime,
+		PathModel:    btPath,
+		Seed:         btSeed,
+		SubTimeframe: btSubTf,
+	}
+	if btSubDataFile != "" {
+		prices, err := backtest.LoadCSV(btSubDataFile, bp.Symbol)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		p.SubPrices = prices
+	} else if btSubTf != "" && btCandleDb != "" {
+		// The sub-bars of the last bar end after the open time of the last bar
+		subEnd := endTime
+		if subEnd > 0 {
+			subEnd += h.TfDuration(btTimeframe) - 1
+		}
+		p.SubPrices = rdb.Connect(btCandleDb).GetCandles(bp.Exchange, bp.Symbol, btSubTf, p.StartTime, subEnd)
+	}
+	return p
+}
+
+func runBacktest(cmd *cobra.Command) {
+	if !btVerbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var results []backtest.Result
+	for _, configFile := range btConfigFiles {
+		bp := loadBacktestBotParams(cmd, configFile)
+		p := replayParams(bp)
+		p.DbName = btDbName
+		p.Report = btReportDir != ""
+		r, err := backtest.Run(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if r.Report != nil {
+			writeReport(r.Report, filepath.Join(btReportDir, fmt.Sprintf("report-%d", bp.BotID)))
+		}
+		results = append(results, *r)
+	}
+
+	backtest.PrintSummary(os.Stdout, results)
+}
+
+// parseRanges parses the parameter ranges of the flags
+func parseRanges() []backtest.Range {
+	var ranges []backtest.Range
+	for _, s := range opRanges {
+		r, err := backtest.ParseRange(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		ranges = append(ranges, *r)
+	}
+	return ranges
+}
+
+func runOptimize(cmd *cobra.Command) {
+	log.SetOutput(io.Discard)
+
+	ranges := parseRanges()
+
+	bp := loadBacktestBotParams(cmd, configFile)
+	trials, err := backtest.Optimize(replayParams(bp), ranges, opSortBy, opWorkers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	backtest.PrintTrials(os.Stdout, ranges, trials, opTop)
+}
+
+func runWalkForward(cmd *cobra.Command) {
+	log.SetOutput(io.Discard)
+
+	inSample := h.TfDuration(wfInSample)
+	outSample := h.TfDuration(wfOutSample)
+	if inSample == 0 || outSample == 0 {
+		fmt.Fprintln(os.Stderr, "Invalid length of the in-sample or the out-of-sample windows")
+		os.Exit(1)
+	}
+
+	ranges := parseRanges()
+	bp := loadBacktestBotParams(cmd, configFile)
+	wf, err := backtest.WalkForward(backtest.WalkForwardParams{
+		Params:    replayParams(bp),
+		Ranges:    ranges,
+		SortBy:    opSortBy,
+		Workers:   opWorkers,
+		InSample:  inSample,
+		OutSample: outSample,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	backtest.PrintWalkForward(os.Stdout, ranges, wf)
+}
+
+// writeReport writes the report into the JSON and the HTML files
+func writeReport(r *report.Report, name string) {
+	for ext, write := range map[string]func(io.Writer) error{".json": r.WriteJSON, ".html": r.WriteHTML} {
+		f, err := os.Create(name + ext)
+		if err == nil {
+			err = write(f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(name + ext)
+	}
+}
+
+func runReport() {
+	bp := loadBotParams(configFile)
+
+	dbName := rpDbName
+	if dbName == "" {
+		dbName = botDbName(bp)
+	}
+	db := rdb.Connect(dbName)
+
+	qo := t.QueryOrder{
+		BotID:    bp.BotID,
+		Exchange: bp.Exchange,
+		Symbol:   bp.Symbol,
+	}
+
+	output := rpOutput
+	if output == "" {
+		output = fmt.Sprintf("report-%d", bp.BotID)
+	}
+	writeReport(report.New(bp, report.Trades(db, qo)), output)
+}
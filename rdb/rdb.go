@@ -6,11 +6,25 @@ import (
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 
 	t "github.com/tonkla/autotp/types"
 )
 
+// The order types of the queries, the futures stop orders are at the limit price or at market
+var (
+	openTypes = []string{t.OrderTypeLimit, t.OrderTypeMarket}
+	slTypes   = []string{t.OrderTypeSL, t.OrderTypeFSL, t.OrderTypeFSLM}
+	tpTypes   = []string{t.OrderTypeTP, t.OrderTypeFTP, t.OrderTypeFTPM}
+	fslTypes  = []string{t.OrderTypeFSL, t.OrderTypeFSLM}
+	ftpTypes  = []string{t.OrderTypeFTP, t.OrderTypeFTPM}
+	stopTypes = []string{t.OrderTypeFSL, t.OrderTypeFTP, t.OrderTypeFSLM, t.OrderTypeFTPM}
+)
+
+// unplacedStatuses are the statuses of the orders that are not on the exchange, they have been canceled or rejected
+var unplacedStatuses = []string{t.OrderStatusCanceled, t.OrderStatusRejected}
+
 type DB struct {
 	db *gorm.DB
 }
@@ -20,14 +34,26 @@ func Connect(dbName string) *DB {
 	if dbName == "" {
 		dbName = "autotp.db"
 	}
-	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
+		Logger:      logger.Default.LogMode(logger.Silent),
+		PrepareStmt: true,
+	})
 	if err != nil {
 		log.Fatalln(err)
 	}
-	db.AutoMigrate(&t.Order{})
+	db.AutoMigrate(&t.Order{}, &t.Candle{}, &t.TrailingStop{})
 	return &DB{db: db}
 }
 
+// Close closes the connections of the DB
+func (d DB) Close() error {
+	db, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
 // IsEmptyZone checks zone availability
 func (d DB) IsEmptyZone(o t.QueryOrder) bool {
 	var order t.Order
@@ -163,22 +189,22 @@ func (d DB) GetFilledLimitOrders(o t.QueryOrder) []t.Order {
 	return orders
 }
 
-// GetFilledLimitLongOrders returns the LIMIT LONG orders that their status is FILLED
+// GetFilledLimitLongOrders returns the LIMIT and MARKET LONG orders that their status is FILLED
 func (d DB) GetFilledLimitLongOrders(o t.QueryOrder) []t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND type = ? AND pos_side = ? AND status = ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderTypeLimit, t.OrderPosSideLong, t.OrderStatusFilled).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND type IN ? AND pos_side = ? AND status = ? AND open_order_id = '' AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, openTypes, t.OrderPosSideLong, t.OrderStatusFilled).
 		Order("open_time desc").Find(&orders)
 	return orders
 }
 
-// GetFilledLimitShortOrders returns the LIMIT SHORT orders that their status is FILLED
+// GetFilledLimitShortOrders returns the LIMIT and MARKET SHORT orders that their status is FILLED
 func (d DB) GetFilledLimitShortOrders(o t.QueryOrder) []t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND type = ? AND pos_side = ? AND status = ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderTypeLimit, t.OrderPosSideShort, t.OrderStatusFilled).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND type IN ? AND pos_side = ? AND status = ? AND open_order_id = '' AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, openTypes, t.OrderPosSideShort, t.OrderStatusFilled).
 		Order("open_time desc").Find(&orders)
 	return orders
 }
@@ -217,8 +243,8 @@ func (d DB) GetNewLimitShortOrders(o t.QueryOrder) []t.Order {
 func (d DB) GetNewStopLongOrders(o t.QueryOrder) []t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND (type = ? OR type = ?) AND pos_side = ? AND status = ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderTypeFSL, t.OrderTypeFTP, t.OrderPosSideLong, t.OrderStatusNew).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND type IN ? AND pos_side = ? AND status = ? AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, stopTypes, t.OrderPosSideLong, t.OrderStatusNew).
 		Order("open_time desc").Find(&orders)
 	return orders
 }
@@ -227,8 +253,8 @@ func (d DB) GetNewStopLongOrders(o t.QueryOrder) []t.Order {
 func (d DB) GetNewStopShortOrders(o t.QueryOrder) []t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND (type = ? OR type = ?) AND pos_side = ? AND status = ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderTypeFSL, t.OrderTypeFTP, t.OrderPosSideShort, t.OrderStatusNew).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND type IN ? AND pos_side = ? AND status = ? AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, stopTypes, t.OrderPosSideShort, t.OrderStatusNew).
 		Order("open_time desc").Find(&orders)
 	return orders
 }
@@ -241,6 +267,14 @@ func (d DB) GetNewOrders(o t.QueryOrder) []t.Order {
 	return orders
 }
 
+// GetPendingOrders returns the orders that their submissions have not been confirmed
+func (d DB) GetPendingOrders(o t.QueryOrder) []t.Order {
+	var orders []t.Order
+	d.db.Where("bot_id = ? AND exchange = ? AND symbol = ? AND status = ?",
+		o.BotID, o.Exchange, o.Symbol, t.OrderStatusPending).Order("open_time asc").Find(&orders)
+	return orders
+}
+
 // GetNewBuyOrders returns the BUY orders that their status is NEW
 func (d DB) GetNewBuyOrders(o t.QueryOrder) []t.Order {
 	var orders []t.Order
@@ -278,8 +312,8 @@ func (d DB) GetLowestNewShortOrder(o t.QueryOrder) *t.Order {
 // GetSLOrder returns the Stop Loss order of the order
 func (d DB) GetSLOrder(openOrderID string) *t.Order {
 	var order t.Order
-	d.db.Where("open_order_id = ? AND (type = ? OR type = ?) AND status <> ?",
-		openOrderID, t.OrderTypeSL, t.OrderTypeFSL, t.OrderStatusCanceled).First(&order)
+	d.db.Where("open_order_id = ? AND type IN ? AND status NOT IN ?",
+		openOrderID, slTypes, unplacedStatuses).First(&order)
 	if order.ID == "" {
 		return nil
 	}
@@ -289,14 +323,43 @@ func (d DB) GetSLOrder(openOrderID string) *t.Order {
 // GetTPOrder returns the Take Profit order of the order
 func (d DB) GetTPOrder(openOrderID string) *t.Order {
 	var order t.Order
-	d.db.Where("open_order_id = ? AND (type = ? OR type =?) AND status <> ?",
-		openOrderID, t.OrderTypeTP, t.OrderTypeFTP, t.OrderStatusCanceled).First(&order)
+	d.db.Where("open_order_id = ? AND type IN ? AND status NOT IN ?",
+		openOrderID, tpTypes, unplacedStatuses).First(&order)
 	if order.ID == "" {
 		return nil
 	}
 	return &order
 }
 
+// GetTrailOrder returns the trailing stop order of the order
+func (d DB) GetTrailOrder(openOrderID string) *t.Order {
+	var order t.Order
+	d.db.Where("open_order_id = ? AND type = ? AND status NOT IN ?",
+		openOrderID, t.OrderTypeFTSM, unplacedStatuses).First(&order)
+	if order.ID == "" {
+		return nil
+	}
+	return &order
+}
+
+// GetNewTrailOrders returns the trailing stop orders that their status is NEW
+func (d DB) GetNewTrailOrders(o t.QueryOrder) []t.Order {
+	var orders []t.Order
+	d.db.Where("bot_id = ? AND exchange = ? AND symbol = ? AND type = ? AND status = ?",
+		o.BotID, o.Exchange, o.Symbol, t.OrderTypeFTSM, t.OrderStatusNew).Order("open_time asc").Find(&orders)
+	return orders
+}
+
+// GetListOrders returns the orders of the order list
+func (d DB) GetListOrders(listID string) []t.Order {
+	var orders []t.Order
+	if listID == "" {
+		return orders
+	}
+	d.db.Where("list_id = ?", listID).Find(&orders)
+	return orders
+}
+
 // GetTPOrders returns the TAKE_PROFIT_LIMIT orders that are not canceled
 func (d DB) GetTPOrders(o t.QueryOrder) []t.Order {
 	var orders []t.Order
@@ -323,12 +386,24 @@ func (d DB) GetHighestTPOrder(o t.QueryOrder) *t.Order {
 	return &orders[len(orders)-1]
 }
 
+// GetHighestSLOrder returns the highest price STOP_LOSS_LIMIT order that is active
+func (d DB) GetHighestSLOrder(o t.QueryOrder) *t.Order {
+	var orders []t.Order
+	d.db.Where("bot_id = ? AND exchange = ? AND symbol = ? AND type = ? AND status <> ? AND close_time = 0",
+		o.BotID, o.Exchange, o.Symbol, t.OrderTypeSL, t.OrderStatusCanceled).
+		Order("open_price desc").Limit(1).Find(&orders)
+	if len(orders) == 0 {
+		return nil
+	}
+	return &orders[0]
+}
+
 // GetHighestSLLongOrder returns the highest price SL LONG order that is active
 func (d DB) GetHighestSLLongOrder(o t.QueryOrder) *t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type = ? AND status <> ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideLong, t.OrderTypeFSL, t.OrderStatusCanceled).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type IN ? AND status <> ? AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideLong, fslTypes, t.OrderStatusCanceled).
 		Order("open_price desc").Limit(1).Find(&orders)
 	if len(orders) == 0 {
 		return nil
@@ -340,8 +415,8 @@ func (d DB) GetHighestSLLongOrder(o t.QueryOrder) *t.Order {
 func (d DB) GetLowestSLShortOrder(o t.QueryOrder) *t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type = ? AND status <> ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideShort, t.OrderTypeFSL, t.OrderStatusCanceled).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type IN ? AND status <> ? AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideShort, fslTypes, t.OrderStatusCanceled).
 		Order("open_price asc").Limit(1).Find(&orders)
 	if len(orders) == 0 {
 		return nil
@@ -353,8 +428,8 @@ func (d DB) GetLowestSLShortOrder(o t.QueryOrder) *t.Order {
 func (d DB) GetLowestTPLongOrder(o t.QueryOrder) *t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type = ? AND status <> ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideLong, t.OrderTypeFTP, t.OrderStatusCanceled).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type IN ? AND status <> ? AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideLong, ftpTypes, t.OrderStatusCanceled).
 		Order("open_price asc").Limit(1).Find(&orders)
 	if len(orders) == 0 {
 		return nil
@@ -366,8 +441,8 @@ func (d DB) GetLowestTPLongOrder(o t.QueryOrder) *t.Order {
 func (d DB) GetHighestTPShortOrder(o t.QueryOrder) *t.Order {
 	var orders []t.Order
 	d.db.Where(
-		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type = ? AND status <> ? AND close_time = 0`,
-		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideShort, t.OrderTypeFTP, t.OrderStatusCanceled).
+		`bot_id = ? AND exchange = ? AND symbol = ? AND pos_side = ? AND type IN ? AND status <> ? AND close_time = 0`,
+		o.BotID, o.Exchange, o.Symbol, t.OrderPosSideShort, ftpTypes, t.OrderStatusCanceled).
 		Order("open_price desc").Limit(1).Find(&orders)
 	if len(orders) == 0 {
 		return nil
@@ -409,6 +484,14 @@ func (d DB) GetNearestOrder(o t.QueryOrder) *t.Order {
 	return &norder
 }
 
+// GetClosedOrders returns the opening orders that have been closed by their SL/TP orders
+func (d DB) GetClosedOrders(o t.QueryOrder) []t.Order {
+	var orders []t.Order
+	d.db.Where("bot_id = ? AND exchange = ? AND symbol = ? AND close_order_id <> '' AND close_time > 0",
+		o.BotID, o.Exchange, o.Symbol).Order("close_time asc").Find(&orders)
+	return orders
+}
+
 // CreateOrder performs SQL insert on the table orders
 func (d DB) CreateOrder(order t.Order) error {
 	return d.db.Create(&order).Error
@@ -418,3 +501,64 @@ func (d DB) CreateOrder(order t.Order) error {
 func (d DB) UpdateOrder(order t.Order) error {
 	return d.db.Updates(&order).Error
 }
+
+// GetTrailingStop returns the client-side trailing stop of the order
+func (d DB) GetTrailingStop(openOrderID string) *t.TrailingStop {
+	var ts t.TrailingStop
+	d.db.Where("open_order_id = ?", openOrderID).First(&ts)
+	if ts.OpenOrderID == "" {
+		return nil
+	}
+	return &ts
+}
+
+// SaveTrailingStop performs SQL upsert on the table trailing_stops
+func (d DB) SaveTrailingStop(ts t.TrailingStop) error {
+	return d.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&ts).Error
+}
+
+// SaveCandles performs SQL upsert on the table candles
+func (d DB) SaveCandles(candles []t.Candle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+	return d.db.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(candles, 500).Error
+}
+
+// GetCandles returns the candles between the start time and the end time, sorted by the open time
+func (d DB) GetCandles(exchange string, symbol string, timeframe string, startTime int64, endTime int64) []t.HistoricalPrice {
+	var candles []t.Candle
+	q := d.db.Where("exchange = ? AND symbol = ? AND timeframe = ? AND open_time >= ?",
+		exchange, symbol, timeframe, startTime)
+	if endTime > 0 {
+		q = q.Where("open_time <= ?", endTime)
+	}
+	q.Order("open_time asc").Find(&candles)
+	return toHistoricalPrices(candles)
+}
+
+// GetLastCandles returns the latest candles, sorted by the open time
+func (d DB) GetLastCandles(exchange string, symbol string, timeframe string, limit int) []t.HistoricalPrice {
+	var candles []t.Candle
+	d.db.Where("exchange = ? AND symbol = ? AND timeframe = ?", exchange, symbol, timeframe).
+		Order("open_time desc").Limit(limit).Find(&candles)
+	for i, j := 0, len(candles)-1; i < j; i, j = i+1, j-1 {
+		candles[i], candles[j] = candles[j], candles[i]
+	}
+	return toHistoricalPrices(candles)
+}
+
+func toHistoricalPrices(candles []t.Candle) []t.HistoricalPrice {
+	prices := make([]t.HistoricalPrice, 0, len(candles))
+	for _, c := range candles {
+		prices = append(prices, t.HistoricalPrice{
+			Symbol: c.Symbol,
+			Time:   c.OpenTime,
+			Open:   c.Open,
+			High:   c.High,
+			Low:    c.Low,
+			Close:  c.Close,
+		})
+	}
+	return prices
+}
@@ -0,0 +1,108 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+const (
+	chartWidth  = 960
+	chartHeight = 320
+)
+
+var tmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":     formatTime,
+	"duration": formatDuration,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>AutoTP Report: {{.R.Symbol}} #{{.R.BotID}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { border: 1px solid #ddd; padding: 4px 10px; text-align: right; }
+th { background: #f4f4f4; }
+td.l, th.l { text-align: left; }
+.win { color: #0a7d32; }
+.loss { color: #c62828; }
+svg { border: 1px solid #ddd; margin-bottom: 24px; }
+</style>
+</head>
+<body>
+<h1>{{.R.Exchange}} {{.R.Symbol}} {{.R.Strategy}} #{{.R.BotID}}</h1>
+<p>{{time .R.From}} &ndash; {{time .R.To}} (UTC)</p>
+
+<h2>Equity Curve</h2>
+<svg width="{{.W}}" height="{{.H}}" viewBox="0 0 {{.W}} {{.H}}">
+<line x1="0" y1="{{.Zero}}" x2="{{.W}}" y2="{{.Zero}}" stroke="#999" stroke-dasharray="4"/>
+<polyline fill="none" stroke="#1565c0" stroke-width="1.5" points="{{.Points}}"/>
+</svg>
+
+<h2>Summary</h2>
+<table>
+<tr><th class="l">Max Drawdown</th><td>{{.R.MaxDrawdown}}</td></tr>
+<tr><th class="l">Sharpe Ratio</th><td>{{.R.Sharpe}}</td></tr>
+<tr><th class="l">Sortino Ratio</th><td>{{.R.Sortino}}</td></tr>
+</table>
+<table>
+<tr><th class="l"></th><th>Trades</th><th>Wins</th><th>Losses</th><th>Win Rate</th><th>Gross Profit</th><th>Gross Loss</th>
+<th>Net PnL</th><th>Commission</th><th>Expectancy</th><th>Profit Factor</th><th>Avg Holding</th></tr>
+{{range .Rows}}<tr><th class="l">{{.Name}}</th><td>{{.Trades}}</td><td>{{.Wins}}</td><td>{{.Losses}}</td><td>{{.WinRate}}%</td>
+<td>{{.GrossProfit}}</td><td>{{.GrossLoss}}</td><td>{{.NetPL}}</td><td>{{.Commission}}</td><td>{{.Expectancy}}</td>
+<td>{{.ProfitFactor}}</td><td>{{duration .AvgHoldSec}}</td></tr>
+{{end}}</table>
+
+<h2>Trades</h2>
+<table>
+<tr><th class="l">Open Time</th><th class="l">Close Time</th><th class="l">Side</th><th>Qty</th><th>Open</th><th>Close</th>
+<th>Commission</th><th>PnL</th></tr>
+{{range .R.TradeList}}<tr><td class="l">{{time .OpenTime}}</td><td class="l">{{time .CloseTime}}</td><td class="l">{{.Side}}</td>
+<td>{{.Qty}}</td><td>{{.OpenPrice}}</td><td>{{.ClosePrice}}</td><td>{{.Commission}}</td>
+<td class="{{if gt .PL 0.0}}win{{else}}loss{{end}}">{{.PL}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type row struct {
+	Name string
+	Stats
+}
+
+// WriteHTML writes the report as a self-contained HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	minEq, maxEq := 0.0, 0.0
+	for _, p := range r.Equity {
+		if p.Equity < minEq {
+			minEq = p.Equity
+		}
+		if p.Equity > maxEq {
+			maxEq = p.Equity
+		}
+	}
+	if maxEq == minEq {
+		maxEq = minEq + 1
+	}
+
+	var points strings.Builder
+	y := func(eq float64) float64 { return (maxEq - eq) / (maxEq - minEq) * (chartHeight - 1) }
+	for _, p := range r.Equity {
+		x := 0.0
+		if r.To > r.From {
+			x = float64(p.Time-r.From) / float64(r.To-r.From) * chartWidth
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f ", x, y(p.Equity))
+	}
+
+	return tmpl.Execute(w, map[string]interface{}{
+		"R":      r,
+		"W":      chartWidth,
+		"H":      chartHeight,
+		"Zero":   fmt.Sprintf("%.1f", y(0)),
+		"Points": strings.TrimSpace(points.String()),
+		"Rows":   []row{{"All", r.Stats}, {"Long", r.Long}, {"Short", r.Short}},
+	})
+}
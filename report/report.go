@@ -0,0 +1,243 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/rdb"
+	t "github.com/tonkla/autotp/types"
+)
+
+const (
+	SideLong  = "LONG"
+	SideShort = "SHORT"
+
+	msPerDay = 86400000
+)
+
+// Trade is a closed order with the commission of both its open and its close orders
+type Trade struct {
+	Side       string  `json:"side"`
+	Qty        float64 `json:"qty"`
+	OpenPrice  float64 `json:"openPrice"`
+	ClosePrice float64 `json:"closePrice"`
+	PL         float64 `json:"pl"`
+	Commission float64 `json:"commission"`
+	OpenTime   int64   `json:"openTime"`
+	CloseTime  int64   `json:"closeTime"`
+}
+
+// Stats is the performance of a group of trades, the profit factor is 0 when there is no loss
+type Stats struct {
+	Trades       int     `json:"trades"`
+	Wins         int     `json:"wins"`
+	Losses       int     `json:"losses"`
+	WinRate      float64 `json:"winRate"`
+	GrossProfit  float64 `json:"grossProfit"`
+	GrossLoss    float64 `json:"grossLoss"`
+	NetPL        float64 `json:"netPL"`
+	Commission   float64 `json:"commission"`
+	Expectancy   float64 `json:"expectancy"`
+	ProfitFactor float64 `json:"profitFactor"`
+	AvgHoldSec   int64   `json:"avgHoldingSec"`
+}
+
+// Point is a point of the equity curve
+type Point struct {
+	Time   int64   `json:"time"`
+	Equity float64 `json:"equity"`
+}
+
+// Report is the performance report of a bot
+type Report struct {
+	BotID    int64  `json:"botID"`
+	Exchange string `json:"exchange"`
+	Symbol   string `json:"symbol"`
+	Strategy string `json:"strategy"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+
+	Stats
+	MaxDrawdown float64 `json:"maxDrawdown"`
+	Sharpe      float64 `json:"sharpe"`
+	Sortino     float64 `json:"sortino"`
+
+	Long      Stats   `json:"long"`
+	Short     Stats   `json:"short"`
+	Equity    []Point `json:"equity"`
+	TradeList []Trade `json:"tradeList"`
+}
+
+// Trades returns the closed orders of the bot as trades, sorted by the close time
+func Trades(db *rdb.DB, qo t.QueryOrder) []Trade {
+	var trades []Trade
+	for _, o := range db.GetClosedOrders(qo) {
+		tr := Trade{
+			Side:       SideLong,
+			Qty:        o.Qty,
+			OpenPrice:  o.OpenPrice,
+			ClosePrice: o.ClosePrice,
+			PL:         o.PL,
+			Commission: o.Commission,
+			OpenTime:   o.OpenTime,
+			CloseTime:  o.CloseTime,
+		}
+		if o.PosSide == t.OrderPosSideShort || (o.PosSide == "" && o.Side == t.OrderSideSell) {
+			tr.Side = SideShort
+		}
+		if co := db.GetOrderByID(o.CloseOrderID); co != nil {
+			tr.Commission += co.Commission
+		}
+		trades = append(trades, tr)
+	}
+	return trades
+}
+
+// New returns the performance report of the trades, which must be sorted by the close time.
+// The Sharpe and the Sortino ratios are annualized from the daily PnL, with 365 trading days a year.
+func New(bp *t.BotParams, trades []Trade) *Report {
+	r := Report{
+		BotID:     bp.BotID,
+		Exchange:  bp.Exchange,
+		Symbol:    bp.Symbol,
+		Strategy:  bp.Strategy,
+		TradeList: trades,
+	}
+	if len(trades) == 0 {
+		return &r
+	}
+
+	var longs, shorts []Trade
+	for _, tr := range trades {
+		if tr.Side == SideShort {
+			shorts = append(shorts, tr)
+		} else {
+			longs = append(longs, tr)
+		}
+	}
+	r.Stats = newStats(trades, bp.PriceDigits)
+	r.Long = newStats(longs, bp.PriceDigits)
+	r.Short = newStats(shorts, bp.PriceDigits)
+
+	r.From = trades[0].OpenTime
+	r.To = trades[len(trades)-1].CloseTime
+	for _, tr := range trades {
+		if tr.OpenTime > 0 && tr.OpenTime < r.From {
+			r.From = tr.OpenTime
+		}
+	}
+
+	equity, peak := 0.0, 0.0
+	r.Equity = append(r.Equity, Point{Time: r.From, Equity: 0})
+	for _, tr := range trades {
+		equity += tr.PL
+		peak = math.Max(peak, equity)
+		r.MaxDrawdown = math.Max(r.MaxDrawdown, peak-equity)
+		r.Equity = append(r.Equity, Point{Time: tr.CloseTime, Equity: h.NormalizeDouble(equity, bp.PriceDigits)})
+	}
+	r.MaxDrawdown = h.NormalizeDouble(r.MaxDrawdown, bp.PriceDigits)
+
+	r.Sharpe, r.Sortino = ratios(dailyPL(trades))
+	return &r
+}
+
+func newStats(trades []Trade, digits int64) Stats {
+	var s Stats
+	var holdSec, held int64
+	for _, tr := range trades {
+		s.Trades++
+		if tr.PL > 0 {
+			s.Wins++
+			s.GrossProfit += tr.PL
+		} else {
+			s.Losses++
+			s.GrossLoss += tr.PL
+		}
+		s.NetPL += tr.PL
+		s.Commission += tr.Commission
+		if tr.OpenTime > 0 && tr.CloseTime > tr.OpenTime {
+			holdSec += (tr.CloseTime - tr.OpenTime) / 1000
+			held++
+		}
+	}
+	if s.Trades == 0 {
+		return s
+	}
+
+	s.WinRate = h.NormalizeDouble(float64(s.Wins)/float64(s.Trades)*100, 2)
+	s.Expectancy = h.NormalizeDouble(s.NetPL/float64(s.Trades), digits)
+	if s.GrossLoss < 0 {
+		s.ProfitFactor = h.NormalizeDouble(s.GrossProfit/-s.GrossLoss, 2)
+	}
+	if held > 0 {
+		s.AvgHoldSec = holdSec / held
+	}
+	s.GrossProfit = h.NormalizeDouble(s.GrossProfit, digits)
+	s.GrossLoss = h.NormalizeDouble(s.GrossLoss, digits)
+	s.NetPL = h.NormalizeDouble(s.NetPL, digits)
+	s.Commission = h.NormalizeDouble(s.Commission, digits)
+	return s
+}
+
+// dailyPL returns the PnL of every UTC day from the first close day to the last one
+func dailyPL(trades []Trade) []float64 {
+	first := trades[0].CloseTime / msPerDay
+	days := make([]float64, trades[len(trades)-1].CloseTime/msPerDay-first+1)
+	for _, tr := range trades {
+		days[tr.CloseTime/msPerDay-first] += tr.PL
+	}
+	return days
+}
+
+// ratios returns the annualized Sharpe and Sortino ratios of the daily PnL
+func ratios(days []float64) (float64, float64) {
+	if len(days) < 2 {
+		return 0, 0
+	}
+	mean := 0.0
+	for _, d := range days {
+		mean += d
+	}
+	mean /= float64(len(days))
+
+	variance, downside := 0.0, 0.0
+	for _, d := range days {
+		variance += (d - mean) * (d - mean)
+		if d < 0 {
+			downside += d * d
+		}
+	}
+	std := math.Sqrt(variance / float64(len(days)-1))
+	downDev := math.Sqrt(downside / float64(len(days)))
+
+	annual := math.Sqrt(365)
+	var sharpe, sortino float64
+	if std > 0 {
+		sharpe = h.NormalizeDouble(mean/std*annual, 2)
+	}
+	if downDev > 0 {
+		sortino = h.NormalizeDouble(mean/downDev*annual, 2)
+	}
+	return sharpe, sortino
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func formatTime(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04:05")
+}
+
+func formatDuration(sec int64) string {
+	return (time.Duration(sec) * time.Second).String()
+}
@@ -0,0 +1,67 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tonkla/autotp/types"
+)
+
+const day = 86400000
+
+func TestNew(t *testing.T) {
+	bp := types.BotParams{BotID: 1, Symbol: "BNBUSDT", PriceDigits: 2}
+	trades := []Trade{
+		{Side: SideLong, PL: 10, Commission: 1, OpenTime: 1, CloseTime: 3600001},
+		{Side: SideShort, PL: -4, Commission: 1, OpenTime: day, CloseTime: day + 7200000},
+		{Side: SideLong, PL: -6, Commission: 1, OpenTime: 2 * day, CloseTime: 2*day + 3600000},
+		{Side: SideShort, PL: 8, Commission: 1, OpenTime: 3 * day, CloseTime: 3*day + 7200000},
+	}
+	r := New(&bp, trades)
+
+	if r.Trades != 4 || r.Wins != 2 || r.WinRate != 50 || r.NetPL != 8 || r.Commission != 4 {
+		t.Errorf("Unexpected stats: %+v", r.Stats)
+	}
+	if r.Expectancy != 2 || r.ProfitFactor != 1.8 || r.AvgHoldSec != 5400 {
+		t.Errorf("Unexpected stats: %+v", r.Stats)
+	}
+	if r.MaxDrawdown != 10 {
+		t.Errorf("Expect max drawdown 10, got %f", r.MaxDrawdown)
+	}
+	if r.Long.Trades != 2 || r.Long.NetPL != 4 || r.Short.Trades != 2 || r.Short.NetPL != 4 {
+		t.Errorf("Unexpected long/short stats: %+v %+v", r.Long, r.Short)
+	}
+	if len(r.Equity) != 5 || r.Equity[4].Equity != 8 || r.Equity[2].Equity != 6 {
+		t.Errorf("Unexpected equity curve: %+v", r.Equity)
+	}
+	if r.Sharpe <= 0 || r.Sortino <= 0 {
+		t.Errorf("Expect positive Sharpe and Sortino ratios, got %f, %f", r.Sharpe, r.Sortino)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded.NetPL != 8 || len(decoded.TradeList) != 4 {
+		t.Errorf("Unexpected JSON: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := r.WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if html := buf.String(); !strings.Contains(html, "<polyline") || strings.Contains(html, "<script") {
+		t.Error("Expect a self-contained HTML with an equity curve")
+	}
+}
+
+func TestNewWithoutTrades(t *testing.T) {
+	r := New(&types.BotParams{}, nil)
+	var buf bytes.Buffer
+	if r.Trades != 0 || r.WriteJSON(&buf) != nil || r.WriteHTML(&buf) != nil {
+		t.Error("Expect an empty report")
+	}
+}
@@ -1,12 +1,27 @@
 package robot
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/tonkla/autotp/app"
+	"github.com/tonkla/autotp/exchange"
+	"github.com/tonkla/autotp/exchange/exerr"
 	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/strategy/common"
 	t "github.com/tonkla/autotp/types"
 )
 
+// pendingSec is the time in seconds, after which a PENDING order that cannot be found has not reached the exchange
+const pendingSec = 60
+
+// pauseSec is the time in seconds that the robot pauses for, after the exchange has refused its API keys
+const pauseSec = 300
+
 func Trade(ap *app.AppParams) {
+	if isPaused(ap) {
+		return
+	}
 	if ap.BP.OrderType == t.OrderTypeLimit {
 		placeAsMaker(ap)
 	} else if ap.BP.OrderType == t.OrderTypeMarket {
@@ -14,9 +29,42 @@ func Trade(ap *app.AppParams) {
 	}
 }
 
+// Sync synchronizes the statuses of the orders with the exchange, without placing any orders
+func Sync(ap *app.AppParams) {
+	if isPaused(ap) {
+		return
+	}
+	if ap.BP.OrderType == t.OrderTypeLimit {
+		syncOrders(ap)
+	}
+}
+
+// isPaused returns true while the robot has been paused by fail
+func isPaused(p *app.AppParams) bool {
+	return p.CL.Now13() < p.PausedUntil
+}
+
+// fail logs the error of a request, and pauses the robot when the exchange has refused its API keys
+func fail(p *app.AppParams, err error) {
+	h.Log(err)
+	if errors.Is(err, exerr.ErrUnauthorized) {
+		p.PausedUntil = p.CL.Now13() + pauseSec*1000
+		h.Log("robot", fmt.Sprintf("paused for %d seconds", pauseSec))
+	}
+}
+
 func placeAsMaker(p *app.AppParams) {
+	syncOrders(p)
+	cancelOrders(p)
+	closeOrders(p)
+	openLimitOrders(p)
+}
+
+func syncOrders(p *app.AppParams) {
+	syncPendingOrders(p)
 	if p.BP.Product == t.ProductSpot {
 		syncLimitOrder(p)
+		syncSLOrder(p)
 		syncTPOrder(p)
 	} else if p.BP.Product == t.ProductFutures {
 		syncLimitLongOrder(p)
@@ -25,73 +73,152 @@ func placeAsMaker(p *app.AppParams) {
 		syncSLShortOrder(p)
 		syncTPLongOrder(p)
 		syncTPShortOrder(p)
+		syncTrailOrders(p)
 	}
-	cancelOrders(p)
-	closeOrders(p)
-	openLimitOrders(p)
 }
 
 func placeAsTaker(p *app.AppParams) {
+	syncPendingOrders(p)
+	closeOrders(p)
 	openMarketOrders(p)
 }
 
 func cancelOrders(p *app.AppParams) {
-	for _, o := range p.TO.CancelOrders {
-		exo, err := p.EX.GetOrder(o)
-		if err != nil || exo == nil {
-			h.Log(err)
-			continue
+	br, ok := p.EX.(exchange.BatchRepository)
+	if !ok || p.BP.Product != t.ProductFutures || len(p.TO.CancelOrders) < 2 {
+		for _, o := range p.TO.CancelOrders {
+			cancelOrder(p, o)
 		}
-		if exo.Status != t.OrderStatusNew {
-			o.Status = exo.Status
-			o.UpdateTime = exo.UpdateTime
-			if exo.Status != t.OrderStatusFilled {
-				o.CloseTime = h.Now13()
-			}
-			err = p.DB.UpdateOrder(o)
-			if err != nil {
-				h.Log(err)
-			}
+		return
+	}
+
+	exos, errs := br.CancelBatchOrders(p.TO.CancelOrders)
+	for i, o := range p.TO.CancelOrders {
+		if errs[i] != nil || exos[i].Status != t.OrderStatusCanceled {
+			// The order may have been filled, its status is checked before it is canceled alone
+			cancelOrder(p, o)
 			continue
 		}
+		canceled(p, o, exos[i])
+	}
+}
 
-		exo, err = p.EX.CancelOrder(o)
-		if err != nil || exo == nil {
+func cancelOrder(p *app.AppParams, o t.Order) {
+	exo, err := p.EX.GetOrder(o)
+	if errors.Is(err, exerr.ErrOrderNotFound) {
+		// The order is not on the exchange, there is nothing to cancel
+		o.Status = t.OrderStatusCanceled
+		o.CloseTime = p.CL.Now13()
+		if err := p.DB.UpdateOrder(o); err != nil {
 			h.Log(err)
-			continue
 		}
-
+		return
+	}
+	if err != nil || exo == nil {
+		fail(p, err)
+		return
+	}
+	if exo.Status != t.OrderStatusNew {
 		o.Status = exo.Status
 		o.UpdateTime = exo.UpdateTime
-		o.CloseTime = h.Now13()
+		if exo.Status != t.OrderStatusFilled {
+			o.CloseTime = p.CL.Now13()
+		}
 		err = p.DB.UpdateOrder(o)
 		if err != nil {
 			h.Log(err)
-			continue
 		}
+		return
+	}
 
-		if o.PosSide != "" {
-			h.LogCanceledF(o)
-		} else {
-			h.LogCanceled(o)
+	exo, err = p.EX.CancelOrder(o)
+	if err != nil || exo == nil {
+		fail(p, err)
+		return
+	}
+	canceled(p, o, *exo)
+}
+
+// canceled records the order that has been canceled on the exchange
+func canceled(p *app.AppParams, o t.Order, exo t.Order) {
+	o.Status = exo.Status
+	o.UpdateTime = exo.UpdateTime
+	o.CloseTime = p.CL.Now13()
+	err := p.DB.UpdateOrder(o)
+	if err != nil {
+		h.Log(err)
+		return
+	}
+	// Canceling an order of a list cancels the whole list
+	closeList(p, o, t.OrderStatusCanceled)
+
+	if o.PosSide != "" {
+		h.LogCanceledF(o)
+	} else {
+		h.LogCanceled(o)
+	}
+}
+
+// closeList closes the other orders of the order list of the order locally, as the exchange has closed them
+func closeList(p *app.AppParams, o t.Order, status string) {
+	for _, lo := range p.DB.GetListOrders(o.ListID) {
+		if lo.ID == o.ID || lo.CloseTime > 0 {
+			continue
+		}
+		lo.Status = status
+		lo.UpdateTime = o.UpdateTime
+		lo.CloseTime = p.CL.Now13()
+		if err := p.DB.UpdateOrder(lo); err != nil {
+			h.Log(err)
 		}
 	}
 }
 
 func closeOrders(p *app.AppParams) {
-	for _, o := range p.TO.CloseOrders {
-		exo, err := p.EX.OpenStopOrder(o)
-		if err != nil || exo == nil {
+	orders := p.TO.CloseOrders
+	if p.BP.Product == t.ProductSpot && p.BP.OrderType == t.OrderTypeLimit {
+		orders = closeOCOOrders(p, orders)
+	}
+
+	var stopOrders []t.Order
+	for _, o := range orders {
+		if o.Type == t.OrderTypeMarket {
+			closeAtMarket(p, o)
+			continue
+		}
+		if p.BP.OrderType != t.OrderTypeLimit {
+			continue
+		}
+
+		if p.BP.Product == t.ProductFutures {
+			o.ReduceOnly = true
+			if p.BP.StopMarket {
+				if o.Type == t.OrderTypeFSL {
+					o.Type = t.OrderTypeFSLM
+				} else if o.Type == t.OrderTypeFTP {
+					o.Type = t.OrderTypeFTPM
+				}
+			}
+		}
+
+		if err := common.ApplyFilters(p.BP, &o, p.TK.Price); err != nil {
 			h.Log(err)
 			continue
 		}
+		stopOrders = append(stopOrders, o)
+	}
 
+	place(p, stopOrders, p.EX.OpenStopOrder, func(o t.Order, exo t.Order) {
 		o.RefID = exo.RefID
+		o.Status = exo.Status
 		o.OpenTime = exo.OpenTime
-		err = p.DB.CreateOrder(o)
+		// The order may have been repriced before it has been placed
+		o.OpenPrice = exo.OpenPrice
+		o.StopPrice = exo.StopPrice
+		err := p.DB.UpdateOrder(o)
 		if err != nil {
 			h.Log(err)
-			continue
+			return
 		}
 
 		if o.PosSide != "" {
@@ -99,24 +226,174 @@ func closeOrders(p *app.AppParams) {
 		} else {
 			h.LogNew(o)
 		}
+	})
+}
+
+// closeOCOOrders places the TP and the SL orders of the same open order as an OCO order list on the spot,
+// because each of them would reserve the whole quantity of the base asset. It returns the orders that are not paired.
+func closeOCOOrders(p *app.AppParams, orders []t.Order) []t.Order {
+	oc, ok := p.EX.(exchange.OCORepository)
+	if !ok {
+		return orders
+	}
+
+	var rest []t.Order
+	paired := make(map[string]bool)
+	for i, o := range orders {
+		if paired[o.ID] {
+			continue
+		}
+		if o.Type != t.OrderTypeSL && o.Type != t.OrderTypeTP {
+			rest = append(rest, o)
+			continue
+		}
+		pair := pairOf(p, orders[i+1:], o)
+		if pair == nil {
+			rest = append(rest, o)
+			continue
+		}
+		paired[pair.ID] = true
+
+		if o.Type == t.OrderTypeTP {
+			openOCO(p, oc, o, *pair)
+		} else {
+			openOCO(p, oc, *pair, o)
+		}
+	}
+	return rest
+}
+
+// pairOf returns the other close order of the same open order, from the orders or from the order placed alone before.
+// The order placed alone is canceled to release its reserved asset, then it is placed again in the list.
+func pairOf(p *app.AppParams, orders []t.Order, o t.Order) *t.Order {
+	other := t.OrderTypeTP
+	if o.Type == t.OrderTypeTP {
+		other = t.OrderTypeSL
+	}
+	for _, po := range orders {
+		if po.OpenOrderID == o.OpenOrderID && po.Type == other {
+			return &po
+		}
+	}
+
+	placed := p.DB.GetSLOrder(o.OpenOrderID)
+	if other == t.OrderTypeTP {
+		placed = p.DB.GetTPOrder(o.OpenOrderID)
+	}
+	if placed == nil || placed.Type != other || placed.Status != t.OrderStatusNew || placed.ListID != "" {
+		return nil
+	}
+	cancelOrder(p, *placed)
+	if co := p.DB.GetOrderByID(placed.ID); co == nil || co.Status != t.OrderStatusCanceled {
+		return nil
+	}
+
+	pair := *placed
+	pair.ID = h.GenID()
+	pair.RefID = ""
+	pair.Status = t.OrderStatusNew
+	pair.OpenTime = 0
+	pair.UpdateTime = 0
+	pair.CloseTime = 0
+	return &pair
+}
+
+// openOCO places the TP and the SL orders as an OCO order list, when one of them is filled the other expires
+func openOCO(p *app.AppParams, oc exchange.OCORepository, tpo t.Order, slo t.Order) {
+	listID := h.GenID()
+	tpo.ListID = listID
+	slo.ListID = listID
+	for _, o := range []*t.Order{&tpo, &slo} {
+		if err := common.ApplyFilters(p.BP, o, p.TK.Price); err != nil {
+			h.Log(err)
+			return
+		}
+	}
+
+	exos := submitList(p, []t.Order{tpo, slo}, func(orders []t.Order) ([]t.Order, error) {
+		return oc.OpenOCOOrder(orders[0], orders[1])
+	})
+	for _, exo := range exos {
+		err := p.DB.UpdateOrder(exo)
+		if err != nil {
+			h.Log(err)
+			continue
+		}
+		h.LogNew(exo)
+	}
+}
+
+// closeAtMarket closes the filled open order of the close order immediately, with an opposite market order.
+// The market order is submitted like the other orders, it is recorded by its ID before the exchange closes the order.
+func closeAtMarket(p *app.AppParams, o t.Order) {
+	open := p.DB.GetOrderByID(o.OpenOrderID)
+	if open == nil || open.Status != t.OrderStatusFilled || open.CloseTime > 0 {
+		return
+	}
+
+	// The market order keeps the ID of the close order, which the strategy may have recorded
+	id := o.ID
+	if id == "" {
+		id = h.GenID()
+	}
+	mo := t.Order{
+		ID:          id,
+		BotID:       open.BotID,
+		Exchange:    open.Exchange,
+		Symbol:      open.Symbol,
+		Side:        h.Reverse(open.Side),
+		PosSide:     open.PosSide,
+		Type:        t.OrderTypeMarket,
+		Qty:         open.Qty,
+		OpenOrderID: open.ID,
+		ReduceOnly:  p.BP.Product == t.ProductFutures,
+	}
+	closing := *open
+	closing.CloseOrderID = mo.ID
+	exo := submit(p, mo, func(t.Order) (*t.Order, error) {
+		return p.EX.CloseOrder(closing)
+	})
+	if exo == nil {
+		return
+	}
+
+	if exo.Side == t.OrderSideSell {
+		syncTPLong(*exo, p)
+	} else {
+		syncTPShort(*exo, p)
+	}
+
+	cancelStops(p, open.ID)
+}
+
+// cancelStops cancels the stop orders of the closed order, they are no longer needed
+func cancelStops(p *app.AppParams, openOrderID string) {
+	for _, so := range []*t.Order{p.DB.GetSLOrder(openOrderID), p.DB.GetTPOrder(openOrderID), p.DB.GetTrailOrder(openOrderID)} {
+		if so == nil || so.Status != t.OrderStatusNew {
+			continue
+		}
+		cancelOrder(p, *so)
 	}
 }
 
 func openLimitOrders(p *app.AppParams) {
+	var orders []t.Order
 	for _, o := range p.TO.OpenOrders {
-		exo, err := p.EX.OpenLimitOrder(o)
-		if err != nil || exo == nil {
+		if err := common.ApplyFilters(p.BP, &o, p.TK.Price); err != nil {
 			h.Log(err)
 			continue
 		}
+		orders = append(orders, o)
+	}
 
+	place(p, orders, p.EX.OpenLimitOrder, func(o t.Order, exo t.Order) {
 		o.RefID = exo.RefID
 		o.Status = exo.Status
 		o.OpenTime = exo.OpenTime
-		err = p.DB.CreateOrder(o)
+		err := p.DB.UpdateOrder(o)
 		if err != nil {
 			h.Log(err)
-			continue
+			return
 		}
 
 		if o.PosSide != "" {
@@ -124,7 +401,7 @@ func openLimitOrders(p *app.AppParams) {
 		} else {
 			h.LogNew(o)
 		}
-	}
+	})
 }
 
 func openMarketOrders(p *app.AppParams) {
@@ -134,19 +411,23 @@ func openMarketOrders(p *app.AppParams) {
 			o.Qty = _qty
 		}
 		o.Type = t.OrderTypeMarket
-		exo, err := p.EX.OpenMarketOrder(o)
-		if err != nil || exo == nil {
+		if err := common.ApplyFilters(p.BP, &o, p.TK.Price); err != nil {
 			h.Log(err)
 			continue
 		}
 
+		exo := submit(p, o, p.EX.OpenMarketOrder)
+		if exo == nil {
+			continue
+		}
+
 		o.RefID = exo.RefID
 		o.Status = exo.Status
 		o.OpenTime = exo.OpenTime
 		o.OpenPrice = exo.OpenPrice
 		o.Qty = exo.Qty
 		o.Commission = exo.Commission
-		err = p.DB.CreateOrder(o)
+		err := p.DB.UpdateOrder(o)
 		if err != nil {
 			h.Log(err)
 			continue
@@ -160,13 +441,337 @@ func openMarketOrders(p *app.AppParams) {
 	}
 }
 
+// place submits the orders one by one, or in batches when there are several futures orders.
+// placed is called with every order that has been placed, and its result.
+func place(p *app.AppParams, orders []t.Order, send func(t.Order) (*t.Order, error), placed func(t.Order, t.Order)) {
+	br, ok := p.EX.(exchange.BatchRepository)
+	if !ok || p.BP.Product != t.ProductFutures || len(orders) < 2 {
+		for _, o := range orders {
+			if exo := submit(p, o, send); exo != nil {
+				placed(o, *exo)
+			}
+		}
+		return
+	}
+
+	byID := make(map[string]t.Order, len(orders))
+	for _, o := range orders {
+		byID[o.ID] = o
+	}
+	for _, exo := range submitBatch(p, orders, br.OpenBatchOrders) {
+		placed(byID[exo.ID], exo)
+	}
+}
+
+// submit records the order before it is sent, then sends the order to the exchange once.
+// When the result is unknown, e.g. a timeout, the order is reconciled by its client order ID,
+// and it is kept PENDING until it has been found or its submission has expired.
+func submit(p *app.AppParams, o t.Order, send func(t.Order) (*t.Order, error)) *t.Order {
+	exos := submitList(p, []t.Order{o}, func(orders []t.Order) ([]t.Order, error) {
+		exo, err := send(orders[0])
+		if exo == nil {
+			return nil, err
+		}
+		return []t.Order{*exo}, err
+	})
+	if len(exos) == 0 {
+		return nil
+	}
+	return &exos[0]
+}
+
+// submitList submits the orders that are sent together, e.g. an order list, like submit.
+// It returns the orders that have been placed or found, the others are kept PENDING.
+func submitList(p *app.AppParams, orders []t.Order, send func([]t.Order) ([]t.Order, error)) []t.Order {
+	pos := record(p, orders)
+	if pos == nil {
+		return nil
+	}
+
+	exos, err := send(orders)
+	if err != nil && retry(p, orders, pos, err) {
+		exos, err = send(orders)
+	}
+	if err == nil && len(exos) == len(orders) {
+		return exos
+	}
+	if err != nil {
+		fail(p, err)
+	}
+
+	var found []t.Order
+	for _, po := range pos {
+		if o := settle(p, po, err); o != nil {
+			found = append(found, *o)
+		}
+	}
+	return found
+}
+
+// submitBatch submits the orders that are sent in batches, like submit. Every order has its own result,
+// it returns the orders that have been placed or found, the others are kept PENDING, rejected or canceled.
+func submitBatch(p *app.AppParams, orders []t.Order, send func([]t.Order) ([]t.Order, []error)) []t.Order {
+	pos := record(p, orders)
+	if pos == nil {
+		return nil
+	}
+
+	exos, errs := send(orders)
+
+	// The orders that can be sent again are sent together in another batch
+	var indexes []int
+	var retries []t.Order
+	for i := range orders {
+		if i < len(errs) && errs[i] != nil && retry(p, orders[i:i+1], pos[i:i+1], errs[i]) {
+			indexes = append(indexes, i)
+			retries = append(retries, orders[i])
+		}
+	}
+	if len(retries) > 0 {
+		rexos, rerrs := send(retries)
+		for n, i := range indexes {
+			errs[i] = nil
+			if n < len(rerrs) {
+				errs[i] = rerrs[n]
+			}
+			if i < len(exos) && n < len(rexos) {
+				exos[i] = rexos[n]
+			}
+		}
+	}
+
+	var found []t.Order
+	for i, po := range pos {
+		var err error
+		if i < len(errs) {
+			err = errs[i]
+		}
+		if err == nil && i < len(exos) && exos[i].RefID != "" {
+			found = append(found, exos[i])
+			continue
+		}
+		if err != nil {
+			fail(p, err)
+		}
+		if o := settle(p, po, err); o != nil {
+			found = append(found, *o)
+		}
+	}
+	return found
+}
+
+// record records the orders as PENDING before they are sent
+func record(p *app.AppParams, orders []t.Order) []t.Order {
+	var pos []t.Order
+	for _, o := range orders {
+		po := o
+		po.Status = t.OrderStatusPending
+		if po.OpenTime == 0 {
+			po.OpenTime = p.CL.Now13()
+		}
+		err := p.DB.CreateOrder(po)
+		if err != nil {
+			h.Log(err)
+			return nil
+		}
+		pos = append(pos, po)
+	}
+	return pos
+}
+
+// settle resolves the PENDING order that has not been placed by the error, the order refused by the exchange
+// is rejected, the order that has failed otherwise is canceled, and the order with an unknown result is reconciled
+func settle(p *app.AppParams, po t.Order, err error) *t.Order {
+	if err == nil || exerr.IsAmbiguous(err) {
+		return reconcile(p, po)
+	}
+	po.Status = t.OrderStatusCanceled
+	if exerr.IsRejected(err) {
+		po.Status = t.OrderStatusRejected
+	}
+	po.CloseTime = p.CL.Now13()
+	if err := p.DB.UpdateOrder(po); err != nil {
+		h.Log(err)
+	}
+	return nil
+}
+
+// retry returns true when the orders that have failed by the error can be sent again. The request that has not
+// been executed is sent again as it is, and the stop order that would trigger immediately is repriced from the ticker.
+func retry(p *app.AppParams, orders []t.Order, pos []t.Order, err error) bool {
+	if exerr.IsRetryable(err) {
+		h.Log("retry", err)
+		return true
+	}
+	if !errors.Is(err, exerr.ErrWouldTrigger) || len(orders) != 1 || !reprice(p, &orders[0]) {
+		return false
+	}
+	pos[0].OpenPrice = orders[0].OpenPrice
+	pos[0].StopPrice = orders[0].StopPrice
+	if err := p.DB.UpdateOrder(pos[0]); err != nil {
+		h.Log(err)
+		return false
+	}
+	h.Log("reprice", fmt.Sprintf("%s at %v/%v", orders[0].ID, orders[0].StopPrice, orders[0].OpenPrice))
+	return true
+}
+
+// reprice moves the stop order that would trigger immediately to the gaps of its type from the ticker,
+// the trailing stop order is activated immediately. It returns false when the order cannot be repriced.
+func reprice(p *app.AppParams, o *t.Order) bool {
+	price, digits, gap := p.TK.Price, p.BP.PriceDigits, p.BP.Gap
+	if price <= 0 {
+		return false
+	}
+
+	var stopPrice, openPrice float64
+	switch o.Type {
+	case t.OrderTypeFTSM:
+		if o.StopPrice <= 0 {
+			return false
+		}
+		// Without an activation price, the trailing stop starts from the latest price
+		stopPrice, openPrice = 0, price
+	case t.OrderTypeSL, t.OrderTypeFSL, t.OrderTypeFSLM:
+		if o.Side == t.OrderSideSell {
+			stopPrice = h.CalcStopLowerTicker(price, float64(gap.SLStop), digits)
+			openPrice = h.CalcStopLowerTicker(price, float64(gap.SLLimit), digits)
+		} else {
+			stopPrice = h.CalcStopUpperTicker(price, float64(gap.SLStop), digits)
+			openPrice = h.CalcStopUpperTicker(price, float64(gap.SLLimit), digits)
+		}
+	case t.OrderTypeTP, t.OrderTypeFTP, t.OrderTypeFTPM:
+		if o.Side == t.OrderSideSell {
+			stopPrice = h.CalcStopUpperTicker(price, float64(gap.TPStop), digits)
+			openPrice = h.CalcStopUpperTicker(price, float64(gap.TPLimit), digits)
+		} else {
+			stopPrice = h.CalcStopLowerTicker(price, float64(gap.TPStop), digits)
+			openPrice = h.CalcStopLowerTicker(price, float64(gap.TPLimit), digits)
+		}
+	default:
+		return false
+	}
+	if isMarketStop(o.Type) && o.Type != t.OrderTypeFTSM {
+		openPrice = stopPrice
+	}
+	if stopPrice == o.StopPrice && openPrice == o.OpenPrice {
+		return false
+	}
+
+	ro := *o
+	ro.StopPrice, ro.OpenPrice = stopPrice, openPrice
+	if err := common.ApplyFilters(p.BP, &ro, price); err != nil {
+		h.Log(err)
+		return false
+	}
+	*o = ro
+	return true
+}
+
+// reconcile looks the PENDING order up on the exchange by its client order ID, and returns the order when it exists.
+// The order that cannot be found after pendingSec seconds has not reached the exchange, it is canceled locally.
+func reconcile(p *app.AppParams, po t.Order) *t.Order {
+	exo, err := p.EX.GetOrder(po)
+	if err == nil && exo != nil && exo.RefID != "" && exo.Status != "" {
+		return reconciled(p, po, *exo)
+	}
+
+	// Not every exchange can look an order up by its client order ID, the order is searched in the open orders,
+	// then in the latest orders, as it may have been filled already
+	if err != nil && !errors.Is(err, exerr.ErrOrderNotFound) {
+		if oo := findByID(p.EX.GetOpenOrders(po.Symbol), po.ID); oo != nil {
+			return reconciled(p, po, *oo)
+		}
+		if ao := findByID(p.EX.GetAllOrders(po.Symbol, 50, 0, 0), po.ID); ao != nil {
+			return reconciled(p, po, *ao)
+		}
+	}
+
+	if (p.CL.Now13()-po.OpenTime)/1000 > pendingSec {
+		h.Log("reconcile", fmt.Sprintf("%s has not reached the exchange", po.ID))
+		po.Status = t.OrderStatusCanceled
+		po.CloseTime = p.CL.Now13()
+		if err := p.DB.UpdateOrder(po); err != nil {
+			h.Log(err)
+		}
+	}
+	return nil
+}
+
+// reconciled returns the PENDING order updated by the order that has been found on the exchange
+func reconciled(p *app.AppParams, po t.Order, exo t.Order) *t.Order {
+	found := po
+	found.RefID = exo.RefID
+	found.Status = exo.Status
+	found.UpdateTime = exo.UpdateTime
+	if exo.OpenPrice > 0 && (po.Type == t.OrderTypeMarket || isMarketStop(po.Type)) {
+		found.OpenPrice = exo.OpenPrice
+	}
+	if found.Status == t.OrderStatusFilled {
+		if commission := p.EX.GetCommission(po.Symbol, found.RefID); commission != nil {
+			found.Commission = *commission
+		}
+	}
+	return &found
+}
+
+// findByID returns the order of the client order ID, which has been placed on the exchange
+func findByID(orders []t.Order, id string) *t.Order {
+	for _, o := range orders {
+		if o.ID == id && o.RefID != "" && o.Status != "" {
+			return &o
+		}
+	}
+	return nil
+}
+
+// syncPendingOrders reconciles the orders that their submissions have not been confirmed
+func syncPendingOrders(p *app.AppParams) {
+	for _, po := range p.DB.GetPendingOrders(p.QO) {
+		o := reconcile(p, po)
+		if o == nil {
+			continue
+		}
+		err := p.DB.UpdateOrder(*o)
+		if err != nil {
+			h.Log(err)
+			continue
+		}
+
+		if o.Status == t.OrderStatusFilled {
+			if o.PosSide != "" {
+				h.LogFilledF(*o)
+			} else {
+				h.LogFilled(*o)
+			}
+		} else if o.PosSide != "" {
+			h.LogNewF(*o)
+		} else {
+			h.LogNew(*o)
+		}
+	}
+}
+
 func syncLimitOrder(p *app.AppParams) {
 	o := p.DB.GetHighestNewBuyOrder(p.QO)
 	if o == nil {
 		return
 	}
 
-	syncStatus(*o, p)
+	syncStatus(o, p)
+}
+
+func syncSLOrder(p *app.AppParams) {
+	slo := p.DB.GetHighestSLOrder(p.QO)
+	if slo == nil {
+		return
+	}
+
+	isTraded := syncStatus(slo, p)
+	if isTraded {
+		closeList(p, *slo, t.OrderStatusExpired)
+		syncSLLong(*slo, p)
+	}
 }
 
 func syncTPOrder(p *app.AppParams) {
@@ -175,8 +780,9 @@ func syncTPOrder(p *app.AppParams) {
 		return
 	}
 
-	isTraded := syncStatus(*tpo, p)
+	isTraded := syncStatus(tpo, p)
 	if isTraded {
+		closeList(p, *tpo, t.OrderStatusExpired)
 		syncTPLong(*tpo, p)
 	}
 }
@@ -187,7 +793,7 @@ func syncLimitLongOrder(p *app.AppParams) {
 		return
 	}
 
-	syncStatus(*o, p)
+	syncStatus(o, p)
 }
 
 func syncLimitShortOrder(p *app.AppParams) {
@@ -196,7 +802,7 @@ func syncLimitShortOrder(p *app.AppParams) {
 		return
 	}
 
-	syncStatus(*o, p)
+	syncStatus(o, p)
 }
 
 func syncSLLongOrder(p *app.AppParams) {
@@ -205,9 +811,10 @@ func syncSLLongOrder(p *app.AppParams) {
 		return
 	}
 
-	isTraded := syncStatus(*slo, p)
+	isTraded := syncStatus(slo, p)
 	if isTraded {
 		syncSLLong(*slo, p)
+		cancelTrail(p, slo.OpenOrderID)
 	}
 }
 
@@ -217,9 +824,10 @@ func syncSLShortOrder(p *app.AppParams) {
 		return
 	}
 
-	isTraded := syncStatus(*slo, p)
+	isTraded := syncStatus(slo, p)
 	if isTraded {
 		syncSLShort(*slo, p)
+		cancelTrail(p, slo.OpenOrderID)
 	}
 }
 
@@ -229,9 +837,10 @@ func syncTPLongOrder(p *app.AppParams) {
 		return
 	}
 
-	isTraded := syncStatus(*tpo, p)
+	isTraded := syncStatus(tpo, p)
 	if isTraded {
 		syncTPLong(*tpo, p)
+		cancelTrail(p, tpo.OpenOrderID)
 	}
 }
 
@@ -241,40 +850,73 @@ func syncTPShortOrder(p *app.AppParams) {
 		return
 	}
 
-	isTraded := syncStatus(*tpo, p)
+	isTraded := syncStatus(tpo, p)
 	if isTraded {
 		syncTPShort(*tpo, p)
+		cancelTrail(p, tpo.OpenOrderID)
 	}
 }
 
-func syncStatus(o t.Order, p *app.AppParams) bool {
-	exo, err := p.EX.GetOrder(o)
+// syncTrailOrders synchronizes the trailing stop orders, the order that has been filled closes its open order,
+// and the other stop orders of the open order are canceled
+func syncTrailOrders(p *app.AppParams) {
+	for _, tso := range p.DB.GetNewTrailOrders(p.QO) {
+		tso := tso
+		isTraded := syncStatus(&tso, p)
+		if !isTraded {
+			continue
+		}
+		if tso.Side == t.OrderSideSell {
+			syncSLLong(tso, p)
+		} else {
+			syncSLShort(tso, p)
+		}
+		cancelStops(p, tso.OpenOrderID)
+	}
+}
+
+// cancelTrail cancels the trailing stop order of the order that has been closed by another order
+func cancelTrail(p *app.AppParams, openOrderID string) {
+	if tso := p.DB.GetTrailOrder(openOrderID); tso != nil && tso.Status == t.OrderStatusNew {
+		cancelOrder(p, *tso)
+	}
+}
+
+// isMarketStop returns true when the stop order is filled at market, not at its limit price
+func isMarketStop(orderType string) bool {
+	return orderType == t.OrderTypeFSLM || orderType == t.OrderTypeFTPM || orderType == t.OrderTypeFTSM
+}
+
+// syncStatus updates the order by its status on the exchange, and returns true when the filled order has been traded
+func syncStatus(o *t.Order, p *app.AppParams) bool {
+	exo, err := p.EX.GetOrder(*o)
 	if err != nil || exo == nil {
-		h.Log(err)
+		fail(p, err)
 		return false
 	}
 
 	if exo.Status == t.OrderStatusNew {
-		if p.BP.TimeSecCancel > 0 && (h.Now13()-o.OpenTime)/1000 > p.BP.TimeSecCancel {
-			exo, err = p.EX.CancelOrder(o)
+		if p.BP.TimeSecCancel > 0 && (p.CL.Now13()-o.OpenTime)/1000 > p.BP.TimeSecCancel {
+			exo, err = p.EX.CancelOrder(*o)
 			if err != nil || exo == nil {
-				h.Log(err)
+				fail(p, err)
 				return false
 			}
 
 			o.Status = exo.Status
 			o.UpdateTime = exo.UpdateTime
-			o.CloseTime = h.Now13()
-			err = p.DB.UpdateOrder(o)
+			o.CloseTime = p.CL.Now13()
+			err = p.DB.UpdateOrder(*o)
 			if err != nil {
 				h.Log(err)
 				return false
 			}
+			closeList(p, *o, t.OrderStatusCanceled)
 
 			if o.PosSide != "" {
-				h.LogCanceledF(o)
+				h.LogCanceledF(*o)
 			} else {
-				h.LogCanceled(o)
+				h.LogCanceled(*o)
 			}
 		}
 		return false
@@ -290,42 +932,57 @@ func syncStatus(o t.Order, p *app.AppParams) bool {
 			t.OrderStatusRejected,
 		}
 		if h.ContainsString(canceledStatuses, exo.Status) {
-			o.CloseTime = h.Now13()
+			o.CloseTime = p.CL.Now13()
 		}
 
 		if exo.Status == t.OrderStatusFilled {
+			// A market stop order is filled at the market price, not at its limit price
+			if isMarketStop(o.Type) && exo.OpenPrice > 0 {
+				o.OpenPrice = exo.OpenPrice
+			}
 			commission := p.EX.GetCommission(p.BP.Symbol, o.RefID)
 			if commission != nil {
 				o.Commission = *commission
 			}
 		}
 
-		err := p.DB.UpdateOrder(o)
+		err := p.DB.UpdateOrder(*o)
 		if err != nil {
 			h.Log(err)
 			return false
 		}
+		if exo.Status == t.OrderStatusCanceled {
+			closeList(p, *o, t.OrderStatusCanceled)
+		}
 
 		if exo.Status == t.OrderStatusFilled {
 			if o.PosSide != "" {
-				h.LogFilledF(o)
+				h.LogFilledF(*o)
 			} else {
-				h.LogFilled(o)
+				h.LogFilled(*o)
 			}
 		}
 
 		if h.ContainsString(canceledStatuses, exo.Status) {
 			if o.PosSide != "" {
-				h.LogCanceledF(o)
+				h.LogCanceledF(*o)
 			} else {
-				h.LogCanceled(o)
+				h.LogCanceled(*o)
 			}
 		}
 	}
 
+	if o.CloseTime > 0 || o.Status != t.OrderStatusFilled {
+		return false
+	}
+	if tt, ok := p.EX.(exchange.TradeTracker); ok {
+		if traded, ok := tt.IsTraded(o.Symbol, o.RefID); ok {
+			return traded
+		}
+	}
 	orders, _ := p.EX.GetTradeList(o.Symbol, 5, 0, 0)
 	for _, to := range orders {
-		if o.RefID == to.RefID && o.CloseTime == 0 && o.Status == t.OrderStatusFilled {
+		if o.RefID == to.RefID {
 			return true
 		}
 	}
@@ -335,7 +992,7 @@ func syncStatus(o t.Order, p *app.AppParams) bool {
 func syncSLLong(slo t.Order, p *app.AppParams) {
 	o := p.DB.GetOrderByID(slo.OpenOrderID)
 	if o == nil {
-		slo.CloseTime = h.Now13()
+		slo.CloseTime = p.CL.Now13()
 		err := p.DB.UpdateOrder(slo)
 		if err != nil {
 			h.Log(err)
@@ -345,7 +1002,7 @@ func syncSLLong(slo t.Order, p *app.AppParams) {
 
 	o.CloseOrderID = slo.ID
 	o.ClosePrice = slo.OpenPrice
-	o.CloseTime = h.Now13()
+	o.CloseTime = p.CL.Now13()
 	o.PL = h.NormalizeDouble(((o.ClosePrice-o.OpenPrice)*slo.Qty)-o.Commission-slo.Commission, p.BP.PriceDigits)
 	err := p.DB.UpdateOrder(*o)
 	if err != nil {
@@ -360,13 +1017,17 @@ func syncSLLong(slo t.Order, p *app.AppParams) {
 		return
 	}
 
-	h.LogClosedF(*o, slo)
+	if o.PosSide != "" {
+		h.LogClosedF(*o, slo)
+	} else {
+		h.LogClosed(*o, slo)
+	}
 }
 
 func syncSLShort(slo t.Order, p *app.AppParams) {
 	o := p.DB.GetOrderByID(slo.OpenOrderID)
 	if o == nil {
-		slo.CloseTime = h.Now13()
+		slo.CloseTime = p.CL.Now13()
 		err := p.DB.UpdateOrder(slo)
 		if err != nil {
 			h.Log(err)
@@ -376,7 +1037,7 @@ func syncSLShort(slo t.Order, p *app.AppParams) {
 
 	o.CloseOrderID = slo.ID
 	o.ClosePrice = slo.OpenPrice
-	o.CloseTime = h.Now13()
+	o.CloseTime = p.CL.Now13()
 	o.PL = h.NormalizeDouble(((o.OpenPrice-o.ClosePrice)*slo.Qty)-o.Commission-slo.Commission, p.BP.PriceDigits)
 	err := p.DB.UpdateOrder(*o)
 	if err != nil {
@@ -397,7 +1058,7 @@ func syncSLShort(slo t.Order, p *app.AppParams) {
 func syncTPLong(tpo t.Order, p *app.AppParams) {
 	o := p.DB.GetOrderByID(tpo.OpenOrderID)
 	if o == nil {
-		tpo.CloseTime = h.Now13()
+		tpo.CloseTime = p.CL.Now13()
 		err := p.DB.UpdateOrder(tpo)
 		if err != nil {
 			h.Log(err)
@@ -407,7 +1068,7 @@ func syncTPLong(tpo t.Order, p *app.AppParams) {
 
 	o.CloseOrderID = tpo.ID
 	o.ClosePrice = tpo.OpenPrice
-	o.CloseTime = h.Now13()
+	o.CloseTime = p.CL.Now13()
 	o.PL = h.NormalizeDouble(((o.ClosePrice-o.OpenPrice)*tpo.Qty)-o.Commission-tpo.Commission, p.BP.PriceDigits)
 	err := p.DB.UpdateOrder(*o)
 	if err != nil {
@@ -432,7 +1093,7 @@ func syncTPLong(tpo t.Order, p *app.AppParams) {
 func syncTPShort(tpo t.Order, p *app.AppParams) {
 	o := p.DB.GetOrderByID(tpo.OpenOrderID)
 	if o == nil {
-		tpo.CloseTime = h.Now13()
+		tpo.CloseTime = p.CL.Now13()
 		err := p.DB.UpdateOrder(tpo)
 		if err != nil {
 			h.Log(err)
@@ -442,7 +1103,7 @@ func syncTPShort(tpo t.Order, p *app.AppParams) {
 
 	o.CloseOrderID = tpo.ID
 	o.ClosePrice = tpo.OpenPrice
-	o.CloseTime = h.Now13()
+	o.CloseTime = p.CL.Now13()
 	o.PL = h.NormalizeDouble(((o.OpenPrice-o.ClosePrice)*tpo.Qty)-o.Commission-tpo.Commission, p.BP.PriceDigits)
 	err := p.DB.UpdateOrder(*o)
 	if err != nil {
@@ -0,0 +1,514 @@
+package robot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tonkla/autotp/app"
+	"github.com/tonkla/autotp/clock"
+	"github.com/tonkla/autotp/exchange"
+	"github.com/tonkla/autotp/exchange/bitkub"
+	"github.com/tonkla/autotp/exchange/exerr"
+	"github.com/tonkla/autotp/exchange/sim"
+	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/rdb"
+	"github.com/tonkla/autotp/types"
+)
+
+const (
+	symbol = "BNBUSDT"
+	t0     = 1634083200000
+)
+
+// flakyClient fails to answer the placed orders, either after (accepted) or before (lost) placing them on the exchange
+type flakyClient struct {
+	*sim.Client
+	accepted bool
+	rejected bool
+	err      error
+}
+
+var errTimeout = &url.Error{Op: "Post", URL: "https://fapi.binance.com/fapi/v1/order", Err: context.DeadlineExceeded}
+
+func (c *flakyClient) OpenLimitOrder(o types.Order) (*types.Order, error) {
+	if c.rejected {
+		return nil, errors.New("OpenLimitOrder: Margin is insufficient.")
+	}
+	if c.accepted {
+		c.Client.OpenLimitOrder(o)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return nil, errTimeout
+}
+
+func (c *flakyClient) CloseOrder(o types.Order) (*types.Order, error) {
+	if c.accepted {
+		c.Client.CloseOrder(o)
+	}
+	return nil, errTimeout
+}
+
+func newAppParams(ex exchange.Repository) *app.AppParams {
+	db := rdb.Connect("file:robot" + h.GenID() + "?mode=memory&cache=shared")
+	bp := &types.BotParams{BotID: 1, Exchange: types.ExcBinance, Symbol: symbol, Product: types.ProductSpot,
+		OrderType: types.OrderTypeLimit}
+	return &app.AppParams{
+		EX: ex,
+		DB: db,
+		BP: bp,
+		QO: types.QueryOrder{BotID: bp.BotID, Exchange: bp.Exchange, Symbol: bp.Symbol},
+		CL: clock.NewSim(t0),
+		TK: types.Ticker{Symbol: symbol, Price: 100, Time: t0},
+	}
+}
+
+// errClient fails the placed orders by its errors in turn, then it places them on the exchange
+type errClient struct {
+	*sim.Client
+	errs []error
+}
+
+func (c *errClient) next() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func (c *errClient) OpenLimitOrder(o types.Order) (*types.Order, error) {
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	return c.Client.OpenLimitOrder(o)
+}
+
+func (c *errClient) OpenStopOrder(o types.Order) (*types.Order, error) {
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	return c.Client.OpenStopOrder(o)
+}
+
+func buyOrder(id string) types.Order {
+	return types.Order{ID: id, BotID: 1, Exchange: types.ExcBinance, Symbol: symbol, Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Status: types.OrderStatusNew, Qty: 1, OpenPrice: 99}
+}
+
+func TestSubmitAccepted(t *testing.T) {
+	ex := &flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0), accepted: true}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	openLimitOrders(p)
+
+	// The order has been found by its client order ID
+	o := p.DB.GetOrderByID("1")
+	if o == nil || o.Status != types.OrderStatusNew || o.RefID == "" {
+		t.Fatalf("Expect the reconciled order, got %+v", o)
+	}
+	if n, _ := ex.CountOpenOrders(symbol); n != 1 {
+		t.Errorf("Expect the order placed once, got %d", n)
+	}
+}
+
+func TestSubmitAcceptedWrapped(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+	timeout := h.RequestTimeout
+	h.RequestTimeout = 100 * time.Millisecond
+	defer func() { h.RequestTimeout = timeout }()
+
+	// Bitkub wraps the timeout of its request
+	_, err := bitkub.NewClient("key", "secret").WithBaseURL(srv.URL).OpenLimitOrder(buyOrder("1"))
+	if !exerr.IsAmbiguous(err) {
+		t.Fatalf("Expect an ambiguous error, got %v", err)
+	}
+
+	ex := &flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0), accepted: true, err: err}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	openLimitOrders(p)
+
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusNew || o.RefID == "" {
+		t.Fatalf("Expect the reconciled order, got %+v", o)
+	}
+}
+
+// noLookupClient cannot look an order up by its client order ID, like Bitkub
+type noLookupClient struct {
+	flakyClient
+}
+
+func (c *noLookupClient) GetOrder(o types.Order) (*types.Order, error) {
+	return nil, exerr.New("GetOrder", 0, "no order ID", exerr.ErrInvalidOrder)
+}
+
+func TestSubmitFilledWithoutLookup(t *testing.T) {
+	ex := &noLookupClient{flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0), accepted: true}}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	// The marketable order has been filled at once, it is no longer an open order
+	o := buyOrder("1")
+	o.OpenPrice = 101
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{o}}
+	openLimitOrders(p)
+
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusFilled || o.RefID == "" {
+		t.Fatalf("Expect the filled order found in the latest orders, got %+v", o)
+	}
+}
+
+func TestSubmitLost(t *testing.T) {
+	ex := &flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	openLimitOrders(p)
+
+	// The order may still reach the exchange
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusPending {
+		t.Fatalf("Expect the PENDING order, got %+v", o)
+	}
+	syncOrders(p)
+	if o := p.DB.GetOrderByID("1"); o.Status != types.OrderStatusPending {
+		t.Fatalf("Expect the PENDING order, got %+v", o)
+	}
+
+	p.CL.(*clock.Sim).Add((pendingSec + 1) * 1000)
+	syncOrders(p)
+	if o := p.DB.GetOrderByID("1"); o.Status != types.OrderStatusCanceled || o.CloseTime == 0 {
+		t.Errorf("Expect the order canceled locally, got %+v", o)
+	}
+}
+
+func TestSubmitRejected(t *testing.T) {
+	ex := &flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0), rejected: true}
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	openLimitOrders(p)
+
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusCanceled {
+		t.Errorf("Expect the rejected order canceled, got %+v", o)
+	}
+}
+
+func TestSubmitRetried(t *testing.T) {
+	ex := &errClient{Client: sim.NewClient(symbol, "1m", 0, 0),
+		errs: []error{exerr.New("OpenLimitOrder", -1003, "Too many requests", exerr.ErrRateLimited)}}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	openLimitOrders(p)
+
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusNew || o.RefID == "" {
+		t.Fatalf("Expect the order placed by the retry, got %+v", o)
+	}
+	if n, _ := ex.CountOpenOrders(symbol); n != 1 {
+		t.Errorf("Expect the order placed once, got %d", n)
+	}
+}
+
+func TestSubmitTimestamp(t *testing.T) {
+	ex := &errClient{Client: sim.NewClient(symbol, "1m", 0, 0),
+		errs: []error{exerr.New("OpenLimitOrder", -1021, "Timestamp for this request is outside of the recvWindow.",
+			exerr.ErrTimestamp)}}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	openLimitOrders(p)
+
+	// The client has already retried the request after synchronizing its clock
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusCanceled {
+		t.Fatalf("Expect the canceled order, got %+v", o)
+	}
+	if n, _ := ex.CountOpenOrders(symbol); n != 0 {
+		t.Errorf("Expect no open orders, got %d", n)
+	}
+}
+
+func TestSubmitRejectedByKind(t *testing.T) {
+	ex := &errClient{Client: sim.NewClient(symbol, "1m", 0, 0),
+		errs: []error{exerr.New("OpenLimitOrder", -2019, "Margin is insufficient.", exerr.ErrInsufficientBalance)}}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	openLimitOrders(p)
+
+	// The order would be refused again, it is not retried
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusRejected || o.CloseTime == 0 {
+		t.Fatalf("Expect the rejected order, got %+v", o)
+	}
+	if n, _ := ex.CountOpenOrders(symbol); n != 0 {
+		t.Errorf("Expect no open orders, got %d", n)
+	}
+}
+
+func TestSubmitRepriced(t *testing.T) {
+	ex := &errClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+	p.BP.Product = types.ProductFutures
+	p.BP.PriceDigits = 2
+	p.BP.Gap = types.StopLimit{SLStop: 100, SLLimit: 200}
+
+	// The price has fallen below the SL order of a LONG, it would trigger immediately
+	sl := types.Order{ID: "sl", BotID: 1, Exchange: types.ExcBinance, Symbol: symbol, Side: types.OrderSideSell,
+		PosSide: types.OrderPosSideLong, Type: types.OrderTypeFSL, Status: types.OrderStatusNew, Qty: 1,
+		OpenPrice: 100.5, StopPrice: 101, OpenOrderID: "1"}
+	p.TO = types.TradeOrders{CloseOrders: []types.Order{sl}}
+	closeOrders(p)
+
+	o := p.DB.GetOrderByID("sl")
+	if o == nil || o.Status != types.OrderStatusNew || o.RefID == "" || o.OpenPrice != 98 {
+		t.Fatalf("Expect the SL order repriced below the market, got %+v", o)
+	}
+	if oos := ex.GetOpenOrders(symbol); len(oos) != 1 || oos[0].StopPrice != 99 || oos[0].OpenPrice != 98 {
+		t.Errorf("Expect the repriced order on the exchange, got %+v", oos)
+	}
+}
+
+func TestPause(t *testing.T) {
+	ex := &errClient{Client: sim.NewClient(symbol, "1m", 0, 0),
+		errs: []error{exerr.New("OpenLimitOrder", -2015, "Invalid API-key, IP, or permissions for action.",
+			exerr.ErrUnauthorized)}}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("1")}}
+	Trade(p)
+	if o := p.DB.GetOrderByID("1"); o == nil || o.Status != types.OrderStatusCanceled {
+		t.Fatalf("Expect the canceled order, got %+v", o)
+	}
+	if p.PausedUntil != t0+pauseSec*1000 {
+		t.Fatalf("Expect the robot paused, got %d", p.PausedUntil)
+	}
+
+	p.TO = types.TradeOrders{OpenOrders: []types.Order{buyOrder("2")}}
+	Trade(p)
+	if o := p.DB.GetOrderByID("2"); o != nil {
+		t.Fatalf("Expect no orders while the robot is paused, got %+v", o)
+	}
+
+	p.CL.(*clock.Sim).Add(pauseSec * 1000)
+	Trade(p)
+	if o := p.DB.GetOrderByID("2"); o == nil || o.Status != types.OrderStatusNew {
+		t.Errorf("Expect the order placed after the pause, got %+v", o)
+	}
+}
+
+func TestOCOClose(t *testing.T) {
+	ex := &flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	open := buyOrder("1")
+	open.Status = types.OrderStatusFilled
+	open.OpenPrice = 100
+	p.DB.CreateOrder(open)
+
+	closeOrder := func(id string, typ string, price float64, stop float64) types.Order {
+		return types.Order{ID: id, BotID: 1, Exchange: types.ExcBinance, Symbol: symbol, Side: types.OrderSideSell,
+			Type: typ, Status: types.OrderStatusNew, Qty: 1, OpenPrice: price, StopPrice: stop, OpenOrderID: "1"}
+	}
+
+	// The TP order is placed alone, then it is replaced by an OCO of the TP and the SL orders
+	p.TO = types.TradeOrders{CloseOrders: []types.Order{closeOrder("tp", types.OrderTypeTP, 110, 109)}}
+	closeOrders(p)
+	p.TO = types.TradeOrders{CloseOrders: []types.Order{closeOrder("sl", types.OrderTypeSL, 94, 95)}}
+	closeOrders(p)
+
+	if o := p.DB.GetOrderByID("tp"); o.Status != types.OrderStatusCanceled {
+		t.Errorf("Expect the TP order placed alone canceled, got %+v", o)
+	}
+	tpo, slo := p.DB.GetTPOrder("1"), p.DB.GetSLOrder("1")
+	if tpo == nil || slo == nil || tpo.ListID == "" || tpo.ListID != slo.ListID || tpo.OpenPrice != 110 ||
+		tpo.Status != types.OrderStatusNew || slo.Status != types.OrderStatusNew {
+		t.Fatalf("Expect the linked TP and SL orders, got %+v and %+v", tpo, slo)
+	}
+	if n, _ := ex.CountOpenOrders(symbol); n != 2 {
+		t.Errorf("Expect 2 open orders, got %d", n)
+	}
+
+	// The TP order is filled, the open order is closed by it and the SL order has expired
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 111, Time: t0 + 60000})
+	syncOrders(p)
+
+	o := p.DB.GetOrderByID("1")
+	if o.CloseTime == 0 || o.CloseOrderID != tpo.ID || o.ClosePrice != 110 {
+		t.Errorf("Expect the open order closed by the TP order, got %+v", o)
+	}
+	if slo = p.DB.GetOrderByID(slo.ID); slo.Status != types.OrderStatusExpired || slo.CloseTime == 0 {
+		t.Errorf("Expect the SL order expired, got %+v", slo)
+	}
+}
+
+func TestCloseAtMarket(t *testing.T) {
+	ex := &flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0), accepted: true}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+
+	open := buyOrder("1")
+	open.Type = types.OrderTypeMarket
+	exo, _ := ex.OpenMarketOrder(open)
+	p.DB.CreateOrder(*exo)
+
+	// The market order has closed the open order, its result is found by its ID
+	p.TO = types.TradeOrders{CloseOrders: []types.Order{{ID: "c", BotID: 1, Exchange: types.ExcBinance, Symbol: symbol,
+		Side: types.OrderSideSell, Type: types.OrderTypeMarket, Qty: 1, OpenOrderID: "1"}}}
+	closeOrders(p)
+
+	o := p.DB.GetOrderByID("1")
+	if o.CloseTime == 0 || o.CloseOrderID == "" || o.ClosePrice != 100 {
+		t.Fatalf("Expect the open order closed, got %+v", o)
+	}
+	if mo := p.DB.GetOrderByID(o.CloseOrderID); mo == nil || mo.Status != types.OrderStatusFilled || mo.RefID == "" ||
+		mo.Side != types.OrderSideSell {
+		t.Errorf("Expect the filled market order, got %+v", mo)
+	}
+}
+
+// batchClient counts the batches, and rejects the orders at the price of 1
+type batchClient struct {
+	*sim.Client
+	batches int
+}
+
+func (c *batchClient) OpenBatchOrders(orders []types.Order) ([]types.Order, []error) {
+	c.batches++
+	results, errs := c.Client.OpenBatchOrders(orders)
+	for i, o := range orders {
+		if o.OpenPrice == 1 {
+			c.Client.CancelOrder(results[i])
+			results[i], errs[i] = types.Order{}, errors.New("OpenBatchOrders: Margin is insufficient.")
+		}
+	}
+	return results, errs
+}
+
+func (c *batchClient) CancelBatchOrders(orders []types.Order) ([]types.Order, []error) {
+	c.batches++
+	return c.Client.CancelBatchOrders(orders)
+}
+
+func TestBatchOrders(t *testing.T) {
+	ex := &batchClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	db := rdb.Connect("file:robot" + h.GenID() + "?mode=memory&cache=shared")
+	defer db.Close()
+	bp := &types.BotParams{BotID: 1, Exchange: types.ExcBinance, Symbol: symbol, Product: types.ProductFutures,
+		OrderType: types.OrderTypeLimit}
+	p := &app.AppParams{EX: ex, DB: db, BP: bp, CL: clock.NewSim(t0),
+		QO: types.QueryOrder{BotID: bp.BotID, Exchange: bp.Exchange, Symbol: bp.Symbol}}
+
+	var orders []types.Order
+	for _, price := range []float64{99, 98, 1} {
+		o := buyOrder(h.GenID())
+		o.PosSide = types.OrderPosSideLong
+		o.OpenPrice = price
+		orders = append(orders, o)
+	}
+	p.TO = types.TradeOrders{OpenOrders: orders}
+	openLimitOrders(p)
+
+	if ex.batches != 1 {
+		t.Errorf("Expect the orders placed in a batch, got %d batches", ex.batches)
+	}
+	for _, o := range orders[:2] {
+		if do := db.GetOrderByID(o.ID); do.Status != types.OrderStatusNew || do.RefID == "" {
+			t.Errorf("Expect the placed order, got %+v", do)
+		}
+	}
+	if do := db.GetOrderByID(orders[2].ID); do.Status != types.OrderStatusCanceled {
+		t.Errorf("Expect the rejected order canceled, got %+v", do)
+	}
+
+	p.TO = types.TradeOrders{CancelOrders: []types.Order{*db.GetOrderByID(orders[0].ID), *db.GetOrderByID(orders[1].ID)}}
+	cancelOrders(p)
+	if ex.batches != 2 {
+		t.Errorf("Expect the orders canceled in a batch, got %d batches", ex.batches)
+	}
+	for _, o := range orders[:2] {
+		if do := db.GetOrderByID(o.ID); do.Status != types.OrderStatusCanceled || do.CloseTime == 0 {
+			t.Errorf("Expect the canceled order, got %+v", do)
+		}
+	}
+}
+
+func TestTrailOrder(t *testing.T) {
+	ex := &flakyClient{Client: sim.NewClient(symbol, "1m", 0, 0)}
+	ex.Tick(types.Ticker{Symbol: symbol, Price: 100, Time: t0})
+	p := newAppParams(ex)
+	defer p.DB.Close()
+	p.BP.Product = types.ProductFutures
+
+	open := buyOrder("1")
+	open.PosSide = types.OrderPosSideLong
+	open.Type = types.OrderTypeMarket
+	open.OpenPrice = 100
+	exo, _ := ex.OpenMarketOrder(open)
+	open.RefID = exo.RefID
+	open.Status = exo.Status
+	p.DB.CreateOrder(open)
+
+	closeOrder := func(id string, typ string, stop float64) types.Order {
+		return types.Order{ID: id, BotID: 1, Exchange: types.ExcBinance, Symbol: symbol, Side: types.OrderSideSell,
+			PosSide: types.OrderPosSideLong, Type: typ, Status: types.OrderStatusNew, Qty: 1, OpenPrice: stop,
+			StopPrice: stop, OpenOrderID: "1"}
+	}
+	sl := closeOrder("sl", types.OrderTypeFSL, 90)
+	tso := closeOrder("tso", types.OrderTypeFTSM, 105)
+	tso.CallbackRate = 2
+	p.TO = types.TradeOrders{CloseOrders: []types.Order{sl, tso}}
+	closeOrders(p)
+	if o := p.DB.GetTrailOrder("1"); o == nil || o.Status != types.OrderStatusNew || o.RefID == "" {
+		t.Fatalf("Expect the placed trailing stop order, got %+v", o)
+	}
+
+	// The trailing stop is activated at 105, and it is filled at 2% from the highest price of 110
+	for i, price := range []float64{110, 107} {
+		ex.Tick(types.Ticker{Symbol: symbol, Price: price, Time: t0 + int64(i+1)*60000})
+	}
+	syncOrders(p)
+
+	o := p.DB.GetOrderByID("1")
+	if o.CloseTime == 0 || o.CloseOrderID != "tso" || o.ClosePrice != 107.8 {
+		t.Errorf("Expect the open order closed by the trailing stop order, got %+v", o)
+	}
+	if o = p.DB.GetOrderByID("sl"); o.Status != types.OrderStatusCanceled {
+		t.Errorf("Expect the SL order canceled, got %+v", o)
+	}
+}
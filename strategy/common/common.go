@@ -1,8 +1,10 @@
 package common
 
 import (
+	"fmt"
 	"math"
 
+	"github.com/tonkla/autotp/clock"
 	h "github.com/tonkla/autotp/helper"
 	"github.com/tonkla/autotp/rdb"
 	"github.com/tonkla/autotp/talib"
@@ -308,7 +310,7 @@ func GetHLRatio(prices []t.HistoricalPrice, ticker t.Ticker) float64 {
 }
 
 // CloseProfitSpot creates STOP orders for profitable SPOT orders at the ticker price
-func CloseProfitSpot(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, t0 int64) []t.Order {
+func CloseProfitSpot(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, t0 int64, cl clock.Clock) []t.Order {
 	var orders []t.Order
 	var order *t.Order
 	for _, o := range db.GetFilledLimitOrders(qo) {
@@ -317,7 +319,7 @@ func CloseProfitSpot(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Tick
 				if t0 > o.OpenTime {
 					order = TPLongNow(db, bp, ticker, o)
 				}
-			} else if (h.Now13()-o.UpdateTime)/1000.0 > 600 {
+			} else if (cl.Now13()-o.UpdateTime)/1000.0 > 600 {
 				order = TPLongNow(db, bp, ticker, o)
 			}
 			if order != nil {
@@ -329,7 +331,7 @@ func CloseProfitSpot(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Tick
 }
 
 // CloseProfitLong creates STOP orders for profitable LONG orders at the ticker price
-func CloseProfitLong(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, t0 int64) []t.Order {
+func CloseProfitLong(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, t0 int64, cl clock.Clock) []t.Order {
 	var orders []t.Order
 	var order *t.Order
 	for _, o := range db.GetFilledLimitLongOrders(qo) {
@@ -338,7 +340,7 @@ func CloseProfitLong(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Tick
 				if t0 > o.OpenTime {
 					order = TPLongNow(db, bp, ticker, o)
 				}
-			} else if (h.Now13()-o.UpdateTime)/1000.0 > 600 {
+			} else if (cl.Now13()-o.UpdateTime)/1000.0 > 600 {
 				order = TPLongNow(db, bp, ticker, o)
 			}
 			if order != nil {
@@ -350,7 +352,7 @@ func CloseProfitLong(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Tick
 }
 
 // CloseProfitShort creates STOP orders for profitable SHORT orders at the ticker price
-func CloseProfitShort(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, t0 int64) []t.Order {
+func CloseProfitShort(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, t0 int64, cl clock.Clock) []t.Order {
 	var orders []t.Order
 	var order *t.Order
 	for _, o := range db.GetFilledLimitShortOrders(qo) {
@@ -359,7 +361,7 @@ func CloseProfitShort(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Tic
 				if t0 > o.OpenTime {
 					order = TPShortNow(db, bp, ticker, o)
 				}
-			} else if (h.Now13()-o.UpdateTime)/1000.0 > 600 {
+			} else if (cl.Now13()-o.UpdateTime)/1000.0 > 600 {
 				order = TPShortNow(db, bp, ticker, o)
 			}
 			if order != nil {
@@ -408,6 +410,50 @@ func CloseShort(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) [
 	return orders
 }
 
+// CloseLongNow creates MARKET orders for active LONG orders on the futures, they are closed immediately.
+// The other products fall back to CloseLong.
+func CloseLongNow(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.Order {
+	if bp.Product != t.ProductFutures {
+		return CloseLong(db, bp, qo, ticker)
+	}
+	var orders []t.Order
+	for _, o := range db.GetFilledLimitLongOrders(qo) {
+		orders = append(orders, closeNow(bp, ticker, o))
+	}
+	return orders
+}
+
+// CloseShortNow creates MARKET orders for active SHORT orders on the futures, they are closed immediately.
+// The other products fall back to CloseShort.
+func CloseShortNow(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.Order {
+	if bp.Product != t.ProductFutures {
+		return CloseShort(db, bp, qo, ticker)
+	}
+	var orders []t.Order
+	for _, o := range db.GetFilledLimitShortOrders(qo) {
+		orders = append(orders, closeNow(bp, ticker, o))
+	}
+	return orders
+}
+
+// closeNow creates a MARKET order that closes the order, it is reduce-only on the futures
+func closeNow(bp *t.BotParams, ticker t.Ticker, o t.Order) t.Order {
+	return t.Order{
+		ID:          h.GenID(),
+		BotID:       bp.BotID,
+		Exchange:    bp.Exchange,
+		Symbol:      bp.Symbol,
+		Side:        h.Reverse(o.Side),
+		PosSide:     o.PosSide,
+		Type:        t.OrderTypeMarket,
+		Status:      t.OrderStatusNew,
+		Qty:         h.NormalizeDouble(o.Qty, bp.QtyDigits),
+		OpenPrice:   ticker.Price,
+		OpenOrderID: o.ID,
+		ReduceOnly:  bp.Product == t.ProductFutures,
+	}
+}
+
 // CloseOpposite creates STOP orders for the older opposite order
 // when LONG/SHORT orders have been openned at the same time
 func CloseOpposite(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.Order {
@@ -425,7 +471,7 @@ func CloseOpposite(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker
 }
 
 // TimeSL creates SL orders based on the order open time
-func TimeSL(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.Order {
+func TimeSL(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, cl clock.Clock) []t.Order {
 	if bp.TimeSecSL <= 0 {
 		return nil
 	}
@@ -437,7 +483,7 @@ func TimeSL(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.O
 			continue
 		}
 
-		if ticker.Price < o.OpenPrice && (h.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecSL {
+		if ticker.Price < o.OpenPrice && (cl.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecSL {
 			_o := SLLongNow(db, bp, ticker, o)
 			if _o != nil {
 				closeOrders = append(closeOrders, *_o)
@@ -450,7 +496,7 @@ func TimeSL(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.O
 			continue
 		}
 
-		if ticker.Price > o.OpenPrice && (h.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecSL {
+		if ticker.Price > o.OpenPrice && (cl.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecSL {
 			_o := SLShortNow(db, bp, ticker, o)
 			if _o != nil {
 				closeOrders = append(closeOrders, *_o)
@@ -462,7 +508,7 @@ func TimeSL(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.O
 }
 
 // TimeTP creates TP orders based on the order open time
-func TimeTP(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.Order {
+func TimeTP(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, cl clock.Clock) []t.Order {
 	if bp.TimeSecTP <= 0 {
 		return nil
 	}
@@ -474,7 +520,7 @@ func TimeTP(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.O
 			continue
 		}
 
-		if ticker.Price > o.OpenPrice && (h.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecTP {
+		if ticker.Price > o.OpenPrice && (cl.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecTP {
 			_o := TPLongNow(db, bp, ticker, o)
 			if _o != nil {
 				closeOrders = append(closeOrders, *_o)
@@ -487,7 +533,7 @@ func TimeTP(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker) []t.O
 			continue
 		}
 
-		if ticker.Price < o.OpenPrice && (h.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecTP {
+		if ticker.Price < o.OpenPrice && (cl.Now13()-o.UpdateTime)/1000.0 > bp.TimeSecTP {
 			_o := TPShortNow(db, bp, ticker, o)
 			if _o != nil {
 				closeOrders = append(closeOrders, *_o)
@@ -854,3 +900,225 @@ func TPShortNow(db *rdb.DB, bp *t.BotParams, ticker t.Ticker, o t.Order) *t.Orde
 	}
 	return &tpo
 }
+
+// Trail creates the trailing stops of active orders, it returns the close orders and the orders to be canceled.
+// The futures of Binance are trailed by TRAILING_STOP_MARKET orders, the others are trailed by the client.
+func Trail(db *rdb.DB, bp *t.BotParams, qo t.QueryOrder, ticker t.Ticker, atr float64) ([]t.Order, []t.Order) {
+	if bp.QuoteTrail <= 0 && bp.PercentTrail <= 0 && bp.AtrTrail <= 0 {
+		return nil, nil
+	}
+
+	var orders []t.Order
+	if bp.Product == t.ProductFutures {
+		orders = append(db.GetFilledLimitLongOrders(qo), db.GetFilledLimitShortOrders(qo)...)
+	} else {
+		orders = db.GetFilledLimitOrders(qo)
+	}
+
+	var closeOrders, cancelOrders []t.Order
+	for _, o := range orders {
+		distance := trailDistance(bp, o, atr)
+		if distance <= 0 {
+			continue
+		}
+
+		if bp.Product == t.ProductFutures && bp.Exchange == t.ExcBinance {
+			if tso := trailOrder(db, bp, ticker, o, distance); tso != nil {
+				closeOrders = append(closeOrders, *tso)
+			}
+			continue
+		}
+
+		co, cancels := trailStop(db, bp, ticker, o, distance)
+		if co != nil {
+			closeOrders = append(closeOrders, *co)
+			cancelOrders = append(cancelOrders, cancels...)
+		}
+	}
+	return closeOrders, cancelOrders
+}
+
+// trailDistance returns the distance of the trailing stop from the price, by a value of the quote currency,
+// a percent of the open price or a volatility
+func trailDistance(bp *t.BotParams, o t.Order, atr float64) float64 {
+	if bp.QuoteTrail > 0 {
+		if o.Qty <= 0 {
+			return 0
+		}
+		return bp.QuoteTrail / o.Qty
+	} else if bp.PercentTrail > 0 {
+		return o.OpenPrice * bp.PercentTrail / 100
+	} else if bp.AtrTrail > 0 && atr > 0 {
+		return bp.AtrTrail * atr
+	}
+	return 0
+}
+
+// trailOrder creates a TRAILING_STOP_MARKET order of the order, it is activated when the profit reaches
+// the distance, then its callback rate keeps the distance from the best price
+func trailOrder(db *rdb.DB, bp *t.BotParams, ticker t.Ticker, o t.Order, distance float64) *t.Order {
+	if db.GetTrailOrder(o.ID) != nil {
+		return nil
+	}
+
+	isLong := o.Side == t.OrderSideBuy
+	activation := o.OpenPrice + distance
+	if !isLong {
+		activation = o.OpenPrice - distance
+	}
+	if activation <= 0 {
+		return nil
+	}
+
+	// The callback rate of Binance is from 0.1% to 5%, by 0.1%
+	rate := math.Round(distance/activation*1000) / 10
+	if rate < 0.1 {
+		rate = 0.1
+	} else if rate > 5 {
+		rate = 5
+	}
+
+	tso := t.Order{
+		ID:           h.GenID(),
+		BotID:        bp.BotID,
+		Exchange:     bp.Exchange,
+		Symbol:       bp.Symbol,
+		Side:         h.Reverse(o.Side),
+		PosSide:      o.PosSide,
+		Type:         t.OrderTypeFTSM,
+		Status:       t.OrderStatusNew,
+		Qty:          h.NormalizeDouble(o.Qty, bp.QtyDigits),
+		StopPrice:    h.NormalizeDouble(activation, bp.PriceDigits),
+		OpenPrice:    h.NormalizeDouble(activation, bp.PriceDigits),
+		CallbackRate: rate,
+		OpenOrderID:  o.ID,
+	}
+	// The price has passed the activation price, the order is activated at the current price
+	if (isLong && ticker.Price >= activation) || (!isLong && ticker.Price <= activation) {
+		tso.StopPrice = 0
+		tso.OpenPrice = ticker.Price
+	}
+	return &tso
+}
+
+// trailStop ratchets the stored stop price of the order to the distance from the ticker price, once it is in profit.
+// When the price crosses the stop price, it returns the close order and the stop orders of the order to be canceled.
+func trailStop(db *rdb.DB, bp *t.BotParams, ticker t.Ticker, o t.Order, distance float64) (*t.Order, []t.Order) {
+	ts := db.GetTrailingStop(o.ID)
+	if ts == nil {
+		ts = &t.TrailingStop{OpenOrderID: o.ID}
+	}
+	if ts.CloseOrderID != "" {
+		// The close order has been fired, it is fired again only when it has been canceled or rejected
+		if co := db.GetOrderByID(ts.CloseOrderID); co != nil && co.Status != t.OrderStatusCanceled &&
+			co.Status != t.OrderStatusRejected {
+			return nil, nil
+		}
+	}
+
+	isLong := o.Side == t.OrderSideBuy
+	stopPrice := h.NormalizeDouble(ticker.Price-distance, bp.PriceDigits)
+	if !isLong {
+		stopPrice = h.NormalizeDouble(ticker.Price+distance, bp.PriceDigits)
+	}
+
+	changed := false
+	if isLong && stopPrice >= o.OpenPrice && stopPrice > ts.StopPrice {
+		ts.StopPrice = stopPrice
+		changed = true
+	} else if !isLong && stopPrice <= o.OpenPrice && (ts.StopPrice == 0 || stopPrice < ts.StopPrice) {
+		ts.StopPrice = stopPrice
+		changed = true
+	}
+
+	var co *t.Order
+	var cancelOrders []t.Order
+	if ts.StopPrice > 0 && ((isLong && ticker.Price <= ts.StopPrice) || (!isLong && ticker.Price >= ts.StopPrice)) {
+		co, cancelOrders = fireStop(db, bp, ticker, o)
+		ts.CloseOrderID = co.ID
+		changed = true
+	}
+
+	if changed {
+		ts.UpdateTime = ticker.Time
+		if err := db.SaveTrailingStop(*ts); err != nil {
+			h.Log(err)
+			return nil, nil
+		}
+	}
+	return co, cancelOrders
+}
+
+// fireStop creates the MARKET order that closes the order at the ticker price.
+// On the spot, the SL and the TP orders reserving the base asset are canceled before it.
+func fireStop(db *rdb.DB, bp *t.BotParams, ticker t.Ticker, o t.Order) (*t.Order, []t.Order) {
+	co := closeNow(bp, ticker, o)
+	if bp.Product == t.ProductFutures {
+		return &co, nil
+	}
+
+	var cancelOrders []t.Order
+	for _, so := range []*t.Order{db.GetSLOrder(o.ID), db.GetTPOrder(o.ID)} {
+		if so != nil && so.Status == t.OrderStatusNew {
+			cancelOrders = append(cancelOrders, *so)
+		}
+	}
+	return &co, cancelOrders
+}
+
+// ApplyFilters snaps the prices to the tick size and the quantity to the lot size of the symbol,
+// then validates the order against the filters of the exchange at the market price
+func ApplyFilters(bp *t.BotParams, o *t.Order, marketPrice float64) error {
+	si := bp.SymbolInfo
+	if si == nil {
+		return nil
+	}
+
+	o.Qty = h.FloorToStep(o.Qty, si.StepSize)
+	o.StopPrice = h.RoundToStep(o.StopPrice, si.TickSize)
+	isMarket := o.Type == t.OrderTypeFSLM || o.Type == t.OrderTypeFTPM || o.Type == t.OrderTypeFTSM
+	if o.Type != t.OrderTypeMarket && !isMarket {
+		o.OpenPrice = h.RoundToStep(o.OpenPrice, si.TickSize)
+	}
+
+	if o.Qty <= 0 || o.Qty < si.MinQty || (si.MaxQty > 0 && o.Qty > si.MaxQty) {
+		return fmt.Errorf("ApplyFilters: invalid quantity %v of %s", o.Qty, o.ID)
+	}
+
+	price := o.OpenPrice
+	if o.Type == t.OrderTypeMarket || price <= 0 {
+		price = marketPrice
+	}
+	if o.StopPrice > 0 && isMarket {
+		price = o.StopPrice
+	}
+	// The futures close orders only reduce the position, they are not bound by the notional
+	isClose := bp.Product == t.ProductFutures && o.OpenOrderID != ""
+	if !isClose && price*o.Qty < si.MinNotional {
+		return fmt.Errorf("ApplyFilters: notional %v of %s is less than %v", price*o.Qty, o.ID, si.MinNotional)
+	}
+
+	if marketPrice > 0 && o.Type != t.OrderTypeMarket {
+		if (si.MultiplierUp > 0 && price > marketPrice*si.MultiplierUp) ||
+			(si.MultiplierDown > 0 && price < marketPrice*si.MultiplierDown) {
+			return fmt.Errorf("ApplyFilters: price %v of %s is out of the percent price bounds", price, o.ID)
+		}
+	}
+	return nil
+}
+
+// FilterOrders returns the orders that pass the filters of the symbol, after they have been snapped
+func FilterOrders(bp *t.BotParams, orders []t.Order, ticker t.Ticker) []t.Order {
+	if bp.SymbolInfo == nil || len(orders) == 0 {
+		return orders
+	}
+	var result []t.Order
+	for _, o := range orders {
+		if err := ApplyFilters(bp, &o, ticker.Price); err != nil {
+			h.Log(err)
+			continue
+		}
+		result = append(result, o)
+	}
+	return result
+}
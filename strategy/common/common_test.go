@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	binance "github.com/tonkla/autotp/exchange/binance/spot"
+	h "github.com/tonkla/autotp/helper"
+	"github.com/tonkla/autotp/rdb"
 	"github.com/tonkla/autotp/talib"
 	"github.com/tonkla/autotp/types"
 )
@@ -148,3 +150,88 @@ func TestGetHighsLows(t *testing.T) {
 	h, l := GetHighsLows(prices)
 	t.Errorf("H0=%f, L0=%f", h[len(h)-1], l[len(l)-1])
 }
+
+func TestApplyFilters(t *testing.T) {
+	bp := &types.BotParams{Product: types.ProductFutures, SymbolInfo: &types.SymbolInfo{Symbol: symbol,
+		TickSize: 0.01, StepSize: 0.01, MinQty: 0.01, MinNotional: 5, MultiplierUp: 1.05, MultiplierDown: 0.95}}
+
+	o := types.Order{ID: "1", Type: types.OrderTypeLimit, Side: types.OrderSideBuy, Qty: 0.0567, OpenPrice: 480.1234}
+	if err := ApplyFilters(bp, &o, 481); err != nil || o.Qty != 0.05 || o.OpenPrice != 480.12 {
+		t.Errorf("Unexpected order: %+v, %v", o, err)
+	}
+
+	// Less than the min notional, 0.01 * 480
+	o = types.Order{ID: "2", Type: types.OrderTypeLimit, Side: types.OrderSideBuy, Qty: 0.01, OpenPrice: 480}
+	if err := ApplyFilters(bp, &o, 481); err == nil {
+		t.Error("Expect the notional less than the min notional")
+	}
+	// The futures close orders are not bound by the notional
+	o.OpenOrderID = "1"
+	if err := ApplyFilters(bp, &o, 481); err != nil {
+		t.Error(err)
+	}
+
+	o = types.Order{ID: "3", Type: types.OrderTypeLimit, Side: types.OrderSideBuy, Qty: 1, OpenPrice: 450}
+	if err := ApplyFilters(bp, &o, 481); err == nil {
+		t.Error("Expect the price out of the percent price bounds")
+	}
+
+	orders := FilterOrders(bp, []types.Order{{ID: "4", Type: types.OrderTypeMarket, Qty: 0.005},
+		{ID: "5", Type: types.OrderTypeMarket, Qty: 0.019}}, types.Ticker{Price: 481})
+	if len(orders) != 0 {
+		t.Errorf("Expect no orders, got %+v", orders)
+	}
+}
+
+func TestTrail(t *testing.T) {
+	db := rdb.Connect("file:common" + h.GenID() + "?mode=memory&cache=shared")
+	defer db.Close()
+
+	bp := &types.BotParams{BotID: 1, Exchange: types.ExcBinance, Symbol: symbol, Product: types.ProductSpot,
+		PriceDigits: 2, QtyDigits: 2, QuoteTrail: 5}
+	qo := types.QueryOrder{BotID: bp.BotID, Exchange: bp.Exchange, Symbol: bp.Symbol}
+	open := types.Order{ID: "1", BotID: bp.BotID, Exchange: bp.Exchange, Symbol: bp.Symbol, Side: types.OrderSideBuy,
+		Type: types.OrderTypeLimit, Status: types.OrderStatusFilled, Qty: 1, OpenPrice: 100}
+	db.CreateOrder(open)
+	tpo := types.Order{ID: "2", BotID: bp.BotID, Exchange: bp.Exchange, Symbol: bp.Symbol, Side: types.OrderSideSell,
+		Type: types.OrderTypeTP, Status: types.OrderStatusNew, Qty: 1, OpenPrice: 120, OpenOrderID: open.ID}
+	db.CreateOrder(tpo)
+
+	// The stop starts trailing when the price is in profit by the distance, and it never moves down
+	for _, price := range []float64{103, 108, 110, 107} {
+		closeOrders, _ := Trail(db, bp, qo, types.Ticker{Symbol: symbol, Price: price}, 0)
+		if len(closeOrders) > 0 {
+			t.Fatalf("Expect no close orders at %v, got %+v", price, closeOrders)
+		}
+	}
+	if ts := db.GetTrailingStop(open.ID); ts == nil || ts.StopPrice != 105 {
+		t.Fatalf("Expect the stop price at 105, got %+v", ts)
+	}
+
+	// The price crosses the stop, a MARKET order closes it after the TP order that reserves the base asset
+	closeOrders, cancelOrders := Trail(db, bp, qo, types.Ticker{Symbol: symbol, Price: 104.9}, 0)
+	if len(closeOrders) != 1 || closeOrders[0].Type != types.OrderTypeMarket || closeOrders[0].Side != types.OrderSideSell ||
+		closeOrders[0].OpenOrderID != open.ID || closeOrders[0].ReduceOnly {
+		t.Fatalf("Expect a MARKET order, got %+v", closeOrders)
+	}
+	if len(cancelOrders) != 1 || cancelOrders[0].ID != tpo.ID {
+		t.Errorf("Expect the TP order canceled, got %+v", cancelOrders)
+	}
+	db.CreateOrder(closeOrders[0])
+	if closeOrders, _ = Trail(db, bp, qo, types.Ticker{Symbol: symbol, Price: 104}, 0); len(closeOrders) > 0 {
+		t.Errorf("Expect the MARKET order fired once, got %+v", closeOrders)
+	}
+
+	// The futures of Binance are trailed by the exchange
+	bp.Product = types.ProductFutures
+	short := open
+	short.ID = "3"
+	short.Side = types.OrderSideSell
+	short.PosSide = types.OrderPosSideShort
+	db.CreateOrder(short)
+	closeOrders, _ = Trail(db, bp, qo, types.Ticker{Symbol: symbol, Price: 99}, 0)
+	if len(closeOrders) != 1 || closeOrders[0].Type != types.OrderTypeFTSM || closeOrders[0].Side != types.OrderSideBuy ||
+		closeOrders[0].StopPrice != 95 || closeOrders[0].CallbackRate != 5 {
+		t.Fatalf("Expect a trailing stop order, got %+v", closeOrders)
+	}
+}
@@ -1,6 +1,7 @@
 package daily
 
 import (
+	"github.com/tonkla/autotp/clock"
 	"github.com/tonkla/autotp/exchange"
 	h "github.com/tonkla/autotp/helper"
 	"github.com/tonkla/autotp/rdb"
@@ -13,13 +14,15 @@ type Strategy struct {
 	DB *rdb.DB
 	BP *t.BotParams
 	EX exchange.Repository
+	CL clock.Clock
 }
 
-func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository) Strategy {
+func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository, cl clock.Clock) Strategy {
 	return Strategy{
 		DB: db,
 		BP: bp,
 		EX: ex,
+		CL: cl,
 	}
 }
 
@@ -36,12 +39,12 @@ func (s Strategy) OnTick(ticker t.Ticker) *t.TradeOrders {
 		if s.BP.CloseLong {
 			cancelOrders = append(cancelOrders, s.DB.GetNewStopLongOrders(qo)...)
 			cancelOrders = append(cancelOrders, s.DB.GetNewLimitLongOrders(qo)...)
-			closeOrders = append(closeOrders, common.CloseLong(s.DB, s.BP, qo, ticker)...)
+			closeOrders = append(closeOrders, common.CloseLongNow(s.DB, s.BP, qo, ticker)...)
 		}
 		if s.BP.CloseShort {
 			cancelOrders = append(cancelOrders, s.DB.GetNewStopShortOrders(qo)...)
 			cancelOrders = append(cancelOrders, s.DB.GetNewLimitShortOrders(qo)...)
-			closeOrders = append(closeOrders, common.CloseShort(s.DB, s.BP, qo, ticker)...)
+			closeOrders = append(closeOrders, common.CloseShortNow(s.DB, s.BP, qo, ticker)...)
 		}
 		return &t.TradeOrders{
 			CloseOrders:  closeOrders,
@@ -94,6 +97,10 @@ func (s Strategy) OnTick(ticker t.Ticker) *t.TradeOrders {
 	if s.BP.AutoSL {
 		closeOrders = append(closeOrders, common.SLLong(s.DB, s.BP, qo, ticker, atr)...)
 		closeOrders = append(closeOrders, common.SLShort(s.DB, s.BP, qo, ticker, atr)...)
+
+		trailOrders, trailCancels := common.Trail(s.DB, s.BP, qo, ticker, atr)
+		closeOrders = append(closeOrders, trailOrders...)
+		cancelOrders = append(cancelOrders, trailCancels...)
 	}
 
 	if s.BP.AutoTP {
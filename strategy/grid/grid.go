@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/tonkla/autotp/clock"
 	"github.com/tonkla/autotp/exchange"
 	h "github.com/tonkla/autotp/helper"
 	"github.com/tonkla/autotp/rdb"
@@ -16,13 +17,15 @@ type Strategy struct {
 	DB *rdb.DB
 	BP *t.BotParams
 	EX exchange.Repository
+	CL clock.Clock
 }
 
-func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository) Strategy {
+func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository, cl clock.Clock) Strategy {
 	return Strategy{
 		DB: db,
 		BP: bp,
 		EX: ex,
+		CL: cl,
 	}
 }
 
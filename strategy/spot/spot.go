@@ -3,6 +3,7 @@ package spot
 import (
 	"math"
 
+	"github.com/tonkla/autotp/clock"
 	"github.com/tonkla/autotp/exchange"
 	h "github.com/tonkla/autotp/helper"
 	"github.com/tonkla/autotp/rdb"
@@ -15,18 +16,20 @@ type Strategy struct {
 	DB *rdb.DB
 	BP *t.BotParams
 	EX exchange.Repository
+	CL clock.Clock
 }
 
-func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository) Strategy {
+func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository, cl clock.Clock) Strategy {
 	return Strategy{
 		DB: db,
 		BP: bp,
 		EX: ex,
+		CL: cl,
 	}
 }
 
 func (s Strategy) OnTick(ticker t.Ticker) *t.TradeOrders {
-	var openOrders, closeOrders []t.Order
+	var openOrders, closeOrders, cancelOrders []t.Order
 
 	const numberOfBars = 30
 
@@ -59,6 +62,16 @@ func (s Strategy) OnTick(ticker t.Ticker) *t.TradeOrders {
 		qo.Qty = qty
 	}
 
+	if s.BP.AutoSL {
+		closeOrders, cancelOrders = common.Trail(s.DB, s.BP, qo, ticker, atr)
+		if len(closeOrders) > 0 {
+			return &t.TradeOrders{
+				CloseOrders:  closeOrders,
+				CancelOrders: cancelOrders,
+			}
+		}
+	}
+
 	if s.BP.AutoTP {
 		if ticker.Price > hma_0 {
 			closeOrders = append(closeOrders, common.TPSpot(s.DB, s.BP, qo, ticker, atr)...)
@@ -3,6 +3,7 @@ package strategy
 import (
 	"errors"
 
+	"github.com/tonkla/autotp/clock"
 	"github.com/tonkla/autotp/exchange"
 	"github.com/tonkla/autotp/rdb"
 	"github.com/tonkla/autotp/strategy/daily"
@@ -17,17 +18,17 @@ type Repository interface {
 	OnTick(t.Ticker) *t.TradeOrders
 }
 
-func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository) (Repository, error) {
+func New(db *rdb.DB, bp *t.BotParams, ex exchange.Repository, cl clock.Clock) (Repository, error) {
 	if bp.Strategy == t.StrategyGrid {
-		return grid.New(db, bp, ex), nil
+		return grid.New(db, bp, ex, cl), nil
 	} else if bp.Strategy == t.StrategySpot {
-		return spot.New(db, bp, ex), nil
+		return spot.New(db, bp, ex, cl), nil
 	} else if bp.Strategy == t.StrategyDaily {
-		return daily.New(db, bp, ex), nil
+		return daily.New(db, bp, ex, cl), nil
 	} else if bp.Strategy == t.StrategyScalping {
-		return scalping.New(db, bp, ex), nil
+		return scalping.New(db, bp, ex, cl), nil
 	} else if bp.Strategy == t.StrategyTrend {
-		return trend.New(db, bp, ex), nil
+		return trend.New(db, bp, ex, cl), nil
 	}
 	return nil, errors.New("strategy not found")
 }
@@ -5,10 +5,26 @@ const (
 	ExcBitkub  = "BITKUB"
 	ExcFTX     = "FTX"
 	ExcSatang  = "SATANG"
+	ExcSET     = "SET"
 
 	ProductSpot    = "SPOT"
 	ProductFutures = "FUTURES"
 
+	MarketDataPoll   = "POLL"
+	MarketDataStream = "STREAM"
+
+	ModeLive  = "LIVE"
+	ModePaper = "PAPER"
+
+	NetworkMainnet = "MAINNET"
+	NetworkTestnet = "TESTNET"
+
+	MarginTypeIsolated = "ISOLATED"
+	MarginTypeCrossed  = "CROSSED"
+
+	PositionModeHedge  = "HEDGE"
+	PositionModeOneWay = "ONE_WAY"
+
 	StrategyGrid     = "GRID"
 	StrategySpot     = "SPOT"
 	StrategyDaily    = "DAILY"
@@ -16,16 +32,20 @@ const (
 	StrategyTrend    = "TREND"
 
 	OrderStatusNew      = "NEW"
+	OrderStatusPartial  = "PARTIALLY_FILLED"
 	OrderStatusFilled   = "FILLED"
 	OrderStatusCanceled = "CANCELED"
 	OrderStatusExpired  = "EXPIRED"
 	OrderStatusRejected = "REJECTED"
+	// OrderStatusPending is the status of an order that has been recorded, but its submission has not been confirmed
+	OrderStatusPending = "PENDING"
 
 	OrderSideBuy  = "BUY"
 	OrderSideSell = "SELL"
 
 	OrderPosSideLong  = "LONG"
 	OrderPosSideShort = "SHORT"
+	OrderPosSideBoth  = "BOTH"
 
 	OrderTypeLimit  = "LIMIT"
 	OrderTypeMarket = "MARKET"
@@ -33,6 +53,9 @@ const (
 	OrderTypeTP     = "TAKE_PROFIT_LIMIT"
 	OrderTypeFSL    = "STOP"
 	OrderTypeFTP    = "TAKE_PROFIT"
+	OrderTypeFSLM   = "STOP_MARKET"
+	OrderTypeFTPM   = "TAKE_PROFIT_MARKET"
+	OrderTypeFTSM   = "TRAILING_STOP_MARKET"
 
 	TrendNo    = 0
 	TrendUp1   = 1
@@ -68,6 +91,17 @@ type HistoricalPrice struct {
 	Close  float64
 }
 
+type Candle struct {
+	Exchange  string `gorm:"primaryKey"`
+	Symbol    string `gorm:"primaryKey"`
+	Timeframe string `gorm:"primaryKey"`
+	OpenTime  int64  `gorm:"primaryKey;autoIncrement:false"`
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+}
+
 type Order struct {
 	ID       string `gorm:"index"`
 	RefID    string `gorm:"index"`
@@ -89,16 +123,34 @@ type Order struct {
 
 	OpenOrderID  string `gorm:"index"`
 	CloseOrderID string `gorm:"index"`
+	// ListID links the orders of an order list, e.g. the TP and the SL orders of a spot OCO
+	ListID string `gorm:"index"`
 
 	CloseTime  int64 `gorm:"index"`
 	OpenTime   int64
 	UpdateTime int64
 
+	// CallbackRate is the percent that a trailing stop order follows the price by, from its activation price at StopPrice
+	CallbackRate float64
+
+	// ReduceOnly and ClosePosition are sent with the futures close orders, they are not recorded
+	ReduceOnly    bool `gorm:"-"`
+	ClosePosition bool `gorm:"-"`
+
 	// OpenOrder *Order `gorm:"references:OpenOrderID"`
 	// CloseOrder  *Order  `gorm:"foreignKey:CloseOrderID"`
 	// CloseOrders []Order `gorm:"foreignKey:OpenOrderID"`
 }
 
+// TrailingStop is the stop price of an open order, that is ratcheted by the client-side trailing stop
+type TrailingStop struct {
+	OpenOrderID string `gorm:"primaryKey"`
+	StopPrice   float64
+	// CloseOrderID is the close order that has been fired when the price crossed the stop price
+	CloseOrderID string
+	UpdateTime   int64
+}
+
 type QueryOrder struct {
 	ID        string
 	RefID     string
@@ -171,6 +223,62 @@ type TradeOrder struct {
 	IsMaker         bool
 }
 
+type OrderUpdate struct {
+	Symbol          string
+	ID              string
+	RefID           string
+	Side            string
+	PosSide         string
+	Status          string
+	LastQty         float64
+	LastPrice       float64
+	Commission      float64
+	CommissionAsset string
+	RealizedPnL     float64
+	UpdateTime      int64
+	IsMaker         bool
+}
+
+type Balance struct {
+	Asset  string
+	Free   float64
+	Locked float64
+}
+
+// SymbolInfo is the trading rules of a symbol, the filters that the orders must pass on the exchange.
+// The percent-price multipliers bound the order price around the market price, unbounded when 0.
+type SymbolInfo struct {
+	Symbol         string
+	TickSize       float64
+	StepSize       float64
+	MinQty         float64
+	MaxQty         float64
+	MinNotional    float64
+	MultiplierUp   float64
+	MultiplierDown float64
+}
+
+// FuturesBalance is the balance of an asset of the futures account
+type FuturesBalance struct {
+	Asset         string
+	Wallet        float64
+	Available     float64
+	UnrealizedPnL float64
+}
+
+// Position is the open position of a symbol on the futures, Qty is negative for a SHORT position in the one-way mode
+type Position struct {
+	Symbol           string
+	PosSide          string
+	Qty              float64
+	EntryPrice       float64
+	MarkPrice        float64
+	UnrealizedPnL    float64
+	LiquidationPrice float64
+	Leverage         int64
+	MarginType       string
+}
+
 type ExOrder struct {
 	Symbol string
 	Price  float64
@@ -190,8 +298,20 @@ type BotParams struct {
 	DbName    string
 	OrderType string
 	View      string
+	Mode      string
+
+	// Network is MAINNET or TESTNET, BaseURL and WsURL override the REST and the WebSocket endpoints of its exchange
+	Network string
+	BaseURL string
+	WsURL   string
 
 	IntervalSec int64
+	CacheKlines bool
+	UserStream  bool
+
+	MarketData    string
+	TickerSource  string
+	MinIntervalMs int64
 
 	Exchange    string
 	Symbol      string
@@ -202,6 +322,8 @@ type BotParams struct {
 	QtyDigits   int64
 	BaseQty     float64
 	QuoteQty    float64
+	MakerFee    float64
+	TakerFee    float64
 
 	StartPrice float64
 	UpperPrice float64
@@ -232,10 +354,26 @@ type BotParams struct {
 	TimeSecSL  int64
 	TimeSecTP  int64
 
+	// QuoteTrail, PercentTrail and AtrTrail are the distances of the trailing stops, the first one set is used
+	QuoteTrail   float64
+	PercentTrail float64
+	AtrTrail     float64
+
 	TimeSecCancel int64
 
 	CloseLong  bool
 	CloseShort bool
+	StopMarket bool
+
+	Leverage     int64
+	MarginType   string
+	PositionMode string
+
+	// RecvWindow is the milliseconds that a signed request is valid for, after its timestamp
+	RecvWindow int64
+
+	// SymbolInfo is loaded from the exchange, it is not configured
+	SymbolInfo *SymbolInfo
 
 	Gap StopLimit
 }